@@ -0,0 +1,311 @@
+package roulette
+
+import (
+	"context"
+	"errors"
+	"reflect"
+)
+
+// ErrCycleLimit is reported when the facts were still changing after MaxCycles forward chaining cycles.
+var ErrCycleLimit = errors.New("forward chaining did not reach a fixpoint within MaxCycles")
+
+// errNotScheduled marks the rules a forward chaining cycle did not evaluate, their report is kept from the
+// previous cycle.
+var errNotScheduled = errors.New("rule is not scheduled")
+
+// ruleAgenda selects the rules of a ruleset evaluated in a forward chaining cycle and observes their evaluation.
+type ruleAgenda interface {
+	scheduled(rule int) bool
+	evaluating(rule int)
+	evaluated(rule int)
+	fired() *firedRules
+	values() map[string]interface{}
+}
+
+// fact is an input value the rules can change: a struct pointed to or the value of a Facts key.
+type fact struct {
+	typeName string
+	val      reflect.Value // the pointer or the map holding the fact
+	key      reflect.Value // the key of the fact in the map
+}
+
+func (f fact) value() reflect.Value {
+	if f.key.IsValid() {
+		return f.val.MapIndex(f.key)
+	}
+	return f.val.Elem()
+}
+
+// chaining is the state of a forward chaining execution. Every evaluation of a rule and every change of a fact
+// is ordered by seq: a rule is scheduled again when a fact of its types changed after its last evaluation. The
+// rules which fired in any cycle count for prioritiesCount and activation groups, and the values set with
+// .R.Set in a cycle are kept for the next.
+type chaining struct {
+	rulesets    []TextTemplateRuleset
+	facts       []fact
+	snapshot    []reflect.Value
+	seq         int
+	changedAt   map[string]int
+	evaluatedAt [][]int
+	firedRules  []*firedRules
+	setValues   []map[string]interface{}
+}
+
+func newChaining(vals interface{}, rulesets []TextTemplateRuleset) *chaining {
+	c := &chaining{
+		rulesets:    rulesets,
+		facts:       chainedFacts(vals),
+		changedAt:   make(map[string]int),
+		evaluatedAt: make([][]int, len(rulesets)),
+		firedRules:  make([]*firedRules, len(rulesets)),
+		setValues:   make([]map[string]interface{}, len(rulesets)),
+	}
+	c.snapshot = make([]reflect.Value, len(c.facts))
+	for i := range rulesets {
+		c.evaluatedAt[i] = make([]int, len(rulesets[i].Rules))
+		c.firedRules[i] = newFiredRules()
+	}
+	return c
+}
+
+// chainedFacts returns the facts of the input values which can be changed by the rules.
+func chainedFacts(vals interface{}) []fact {
+	var facts []fact
+	var add func(val interface{})
+	add = func(val interface{}) {
+		switch v := val.(type) {
+		case []interface{}:
+			for _, e := range v {
+				add(e)
+			}
+		case Facts:
+			m := reflect.ValueOf(v)
+			for typeName := range v {
+				facts = append(facts, fact{typeName: typeName, val: m, key: reflect.ValueOf(typeName)})
+			}
+		default:
+			rv := reflect.ValueOf(val)
+			if rv.Kind() == reflect.Ptr && !rv.IsNil() && rv.Elem().Kind() == reflect.Struct {
+				facts = append(facts, fact{typeName: rv.Type().Elem().String(), val: rv})
+			}
+		}
+	}
+	add(vals)
+	return facts
+}
+
+// pending reports whether a rule evaluated before is scheduled again. The rules which were not evaluated, e.g.
+// for their types, are only evaluated again with the others, and the rules skipped after the rules which fired
+// are not evaluated again.
+func (c *chaining) pending() bool {
+	for i := range c.rulesets {
+		a := rulesetAgenda{chain: c, ruleset: i}
+		for j, at := range c.evaluatedAt[i] {
+			if at > 0 && a.scheduled(j) && c.firedRules[i].skip(c.rulesets[i], j) == nil {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// agenda returns the agenda of a ruleset.
+func (c *chaining) agenda(ruleset int) ruleAgenda {
+	return rulesetAgenda{chain: c, ruleset: ruleset}
+}
+
+type rulesetAgenda struct {
+	chain   *chaining
+	ruleset int
+}
+
+func (a rulesetAgenda) scheduled(rule int) bool {
+	at := a.chain.evaluatedAt[a.ruleset][rule]
+	if at == 0 {
+		return true
+	}
+	for _, typeName := range a.chain.rulesets[a.ruleset].Rules[rule].config.expectTypes {
+		if a.chain.changedAt[typeName] > at {
+			return true
+		}
+	}
+	return false
+}
+
+func (a rulesetAgenda) fired() *firedRules {
+	return a.chain.firedRules[a.ruleset]
+}
+
+func (a rulesetAgenda) values() map[string]interface{} {
+	return a.chain.setValues[a.ruleset]
+}
+
+func (a rulesetAgenda) evaluating(rule int) {
+	c := a.chain
+	c.seq += 2
+	c.evaluatedAt[a.ruleset][rule] = c.seq
+	for i, f := range c.facts {
+		c.snapshot[i] = shallowCopy(f.value())
+	}
+}
+
+// evaluated records the facts changed by the rule after the rule's evaluation, so that the rules evaluated
+// before are scheduled again. A rule with NoLoop is evaluated after its own changes.
+func (a rulesetAgenda) evaluated(rule int) {
+	c := a.chain
+	changed := false
+	for i, f := range c.facts {
+		if !sameValue(c.snapshot[i], f.value()) {
+			c.changedAt[f.typeName] = c.seq + 1
+			changed = true
+		}
+	}
+	if changed && c.rulesets[a.ruleset].Rules[rule].NoLoop {
+		c.evaluatedAt[a.ruleset][rule] = c.seq + 1
+	}
+}
+
+// shallowCopy copies a struct or the entries of a map, the values they refer to are shared.
+func shallowCopy(v reflect.Value) reflect.Value {
+	if v.Kind() == reflect.Interface && !v.IsNil() {
+		v = v.Elem()
+	}
+	switch v.Kind() {
+	case reflect.Struct:
+		c := reflect.New(v.Type()).Elem()
+		c.Set(v)
+		return c
+	case reflect.Map:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.MakeMapWithSize(v.Type(), v.Len())
+		for _, k := range v.MapKeys() {
+			c.SetMapIndex(k, v.MapIndex(k))
+		}
+		return c
+	}
+	return v
+}
+
+// sameValue compares the fields of structs and the entries of maps, the values they refer to are compared by
+// identity.
+func sameValue(a, b reflect.Value) bool {
+	if a.Kind() == reflect.Interface && !a.IsNil() {
+		a = a.Elem()
+	}
+	if b.Kind() == reflect.Interface && !b.IsNil() {
+		b = b.Elem()
+	}
+	if !a.IsValid() || !b.IsValid() {
+		return a.IsValid() == b.IsValid()
+	}
+	if a.Type() != b.Type() {
+		return false
+	}
+
+	switch a.Kind() {
+	case reflect.Struct:
+		for i := 0; i < a.NumField(); i++ {
+			if !sameValue(a.Field(i), b.Field(i)) {
+				return false
+			}
+		}
+		return true
+	case reflect.Map:
+		if a.Len() != b.Len() {
+			return false
+		}
+		for _, k := range a.MapKeys() {
+			if !sameValue(a.MapIndex(k), b.MapIndex(k)) {
+				return false
+			}
+		}
+		return true
+	case reflect.Array:
+		for i := 0; i < a.Len(); i++ {
+			if !sameValue(a.Index(i), b.Index(i)) {
+				return false
+			}
+		}
+		return true
+	case reflect.Interface:
+		return a.IsNil() && b.IsNil()
+	case reflect.Slice:
+		return a.Pointer() == b.Pointer() && a.Len() == b.Len()
+	case reflect.Ptr, reflect.Func, reflect.Chan, reflect.UnsafePointer:
+		return a.Pointer() == b.Pointer()
+	case reflect.Bool:
+		return a.Bool() == b.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return a.Int() == b.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return a.Uint() == b.Uint()
+	case reflect.Float32, reflect.Float64:
+		return a.Float() == b.Float()
+	case reflect.Complex64, reflect.Complex128:
+		return a.Complex() == b.Complex()
+	case reflect.String:
+		return a.String() == b.String()
+	}
+	return false
+}
+
+// executeChaining executes the rulesets in cycles until no rule changes a fact or MaxCycles cycles were
+// executed. The report of a rule is of its last evaluation.
+func (p TextTemplateParser) executeChaining(ctx context.Context, vals interface{}, workflowMatches []bool) ExecutionReport {
+	chain := newChaining(vals, p.xml.Rulesets)
+
+	var report ExecutionReport
+	for cycle := 1; ; cycle++ {
+		cycleReport := p.executeRulesets(ctx, vals, workflowMatches, chain)
+		for i, ruleset := range cycleReport.Rulesets {
+			if ruleset.Values != nil {
+				chain.setValues[i] = ruleset.Values
+			}
+		}
+		if cycle == 1 {
+			report = cycleReport
+		} else {
+			report.merge(cycleReport)
+		}
+		report.Cycles = cycle
+
+		if report.Err != nil || !chain.pending() {
+			break
+		}
+		if cycle >= p.config.MaxCycles {
+			report.Err = ErrCycleLimit
+			break
+		}
+	}
+	return report
+}
+
+// merge keeps the reports of the rules evaluated by a later cycle, and the ruleset outcome and values of the
+// later cycle.
+func (r *ExecutionReport) merge(cycle ExecutionReport) {
+	if cycle.Err != nil {
+		r.Err = cycle.Err
+	}
+	for i, ruleset := range cycle.Rulesets {
+		if r.Rulesets[i].Rules == nil {
+			r.Rulesets[i] = ruleset
+			continue
+		}
+
+		merged := &r.Rulesets[i]
+		merged.Variant, merged.Skipped, merged.Err = ruleset.Variant, ruleset.Skipped, ruleset.Err
+		for j, rule := range ruleset.Rules {
+			if rule.Err != errNotScheduled {
+				merged.Rules[j] = rule
+			}
+		}
+		for k, v := range ruleset.Values {
+			if merged.Values == nil {
+				merged.Values = make(map[string]interface{}, len(ruleset.Values))
+			}
+			merged.Values[k] = v
+		}
+	}
+}
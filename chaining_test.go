@@ -0,0 +1,109 @@
+package roulette
+
+import (
+	"context"
+	"log"
+	"reflect"
+	"testing"
+)
+
+func TestForwardChaining(t *testing.T) {
+	config := TextTemplateParserConfig{ForwardChaining: true, MaxCycles: 5}
+	parser, err := NewTextTemplateParserFiles([]string{"testrules/rules_chaining.xml"}, config)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	t2 := &T2{A: 1}
+	report := parser.ExecuteContext(WithWorkflow(context.Background(), "eligibility"), t2)
+	if t2.A != 3 || report.Err != nil {
+		log.Fatalf("Expected the change of the second rule to fire the first, got %d %v", t2.A, report.Err)
+	}
+	if report.Cycles != 3 {
+		log.Fatalf("Expected a fixpoint after 3 cycles, got %d", report.Cycles)
+	}
+	eligibility, _ := report.Ruleset("eligibility")
+	if eligibility.Rules[0].Status != RuleFalse || eligibility.Rules[1].Status != RuleFalse {
+		log.Fatalf("Expected the reports of the last evaluations, got %v", report)
+	}
+
+	tt := &T{}
+	report = parser.ExecuteContext(WithWorkflow(context.Background(), "loop"), tt)
+	if report.Err != ErrCycleLimit || report.Cycles != 5 || tt.A != 5 {
+		log.Fatalf("Expected the cycle limit, got %v after %d cycles with %d", report.Err, report.Cycles, tt.A)
+	}
+
+	tt = &T{}
+	report = parser.ExecuteContext(WithWorkflow(context.Background(), "noLoop"), tt)
+	if report.Err != nil || report.Cycles != 1 || tt.A != 1 {
+		log.Fatalf("Expected a noLoop rule to be evaluated once, got %v after %d cycles with %d", report.Err, report.Cycles, tt.A)
+	}
+}
+
+func TestForwardChainingLimits(t *testing.T) {
+	config := TextTemplateParserConfig{ForwardChaining: true, MaxCycles: 5}
+	parser, err := NewTextTemplateParserFiles([]string{"testrules/rules_chaining.xml"}, config)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	t2 := &T2{A: 1}
+	report := parser.ExecuteContext(WithWorkflow(context.Background(), "limit"), t2)
+	if report.Err != nil || report.Cycles != 2 || t2.A != 2 {
+		log.Fatalf("Expected a fixpoint after 2 cycles, got %v after %d cycles with %d", report.Err, report.Cycles, t2.A)
+	}
+
+	// the reports are of the second cycle, where a and c were false
+	p, _ := report.Ruleset("p")
+	if p.Rules[0].Status != RuleFalse || p.Rules[1].Err != ErrPrioritiesCount {
+		log.Fatalf("Expected the rule fired in the first cycle to count for prioritiesCount, got %v", p)
+	}
+	group, _ := report.Ruleset("group")
+	if group.Rules[0].Status != RuleFalse || group.Rules[1].Err != ErrActivationGroup {
+		log.Fatalf("Expected the rule fired in the first cycle to count for its activation group, got %v", group)
+	}
+}
+
+func TestForwardChainingValues(t *testing.T) {
+	config := TextTemplateParserConfig{ForwardChaining: true, MaxCycles: 5}
+	parser, err := NewTextTemplateParserFiles([]string{"testrules/rules_chaining.xml"}, config)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	t2 := &T2{A: 1}
+	report := parser.ExecuteContext(WithWorkflow(context.Background(), "values"), t2)
+	if report.Err != nil || report.Cycles != 2 || t2.A != 7 {
+		log.Fatalf("Expected a fixpoint after 2 cycles, got %v after %d cycles with %d", report.Err, report.Cycles, t2.A)
+	}
+
+	// the rule fired again in the second cycle, where the value set in the first cycle is kept
+	values, _ := report.Ruleset("values")
+	expected := map[string]interface{}{"a": 7, "initial": 1}
+	if !reflect.DeepEqual(values.Values, expected) {
+		log.Fatalf("Expected the values of the last cycle %v, got %v", expected, values.Values)
+	}
+}
+
+func TestForwardChainingFacts(t *testing.T) {
+	config := TextTemplateParserConfig{ForwardChaining: true}
+	parser, err := NewTextTemplateParserFiles([]string{"testrules/rules_chaining.xml"}, config)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	facts := Facts{"roulette.T2": map[string]interface{}{"A": 0}}
+	report := parser.ExecuteContext(WithWorkflow(context.Background(), "eligibility"), facts)
+	if report.Err != nil || report.Cycles != 1 {
+		log.Fatalf("Expected facts without changes to reach a fixpoint, got %v after %d cycles", report.Err, report.Cycles)
+	}
+
+	if !sameValue(shallowCopy(reflect.ValueOf(facts)), reflect.ValueOf(facts)) {
+		log.Fatal("Expected a copy of the facts to be the same")
+	}
+	snapshot := shallowCopy(reflect.ValueOf(facts))
+	facts["roulette.T2"] = map[string]interface{}{"A": 1}
+	if sameValue(snapshot, reflect.ValueOf(facts)) {
+		log.Fatal("Expected a changed fact to differ from its copy")
+	}
+}
@@ -0,0 +1,229 @@
+// Command roulette validates, lints and runs rule files without writing a go program.
+//
+//	roulette validate [flags] files...  compile the rule files and report every invalid rule
+//	roulette lint [flags] files...      report suspicious rulesets and rules
+//	roulette run [flags] files...       execute the rules on json facts read from stdin or -input
+//	roulette test [flags] files...      run the <test> elements of the rulesets
+//	roulette explain [flags] files...   trace every stage of the rule pipelines for the facts of run
+//
+// Rule files are xml, json or yaml and can be glob patterns. The facts of run are json objects, one per line,
+// keyed by the type names of filterTypes:
+//
+//	{"types.Person": {"Age": 30, "Salary": 100}, "types.Company": {"Name": "myntra"}}
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/myntra/roulette"
+)
+
+const usage = `usage: roulette <command> [flags] files...
+
+commands:
+  validate  compile the rule files and report every invalid rule
+  lint      report suspicious rulesets and rules
+  run       execute the rules on json facts, one object per line, read from stdin or -input
+  test      run the <test> elements of the rulesets
+  explain   trace every stage of the rule pipelines for the facts of run
+`
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// run runs the command and returns the exit code.
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprint(stderr, usage)
+		return 2
+	}
+
+	command := args[0]
+	flags := flag.NewFlagSet(command, flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	workflow := flags.String("workflow", "", "execute only the rulesets matching the workflow pattern")
+	wildcard := flags.Bool("wildcard", false, "the workflow pattern is a wildcard pattern instead of a regex")
+	input := flags.String("input", "", "run, explain: read the facts from the jsonl file instead of stdin")
+	cover := flags.Bool("cover", false, "run, test: print the coverage of the rulesets, rules and branches")
+	coverHTML := flags.String("coverhtml", "", "run, test: write the rule files annotated with their coverage to the html file")
+
+	switch command {
+	case "validate", "lint", "run", "test", "explain":
+	default:
+		fmt.Fprintf(stderr, "unknown command %s\n%s", command, usage)
+		return 2
+	}
+
+	err := flags.Parse(args[1:])
+	if err != nil {
+		return 2
+	}
+
+	if flags.NArg() == 0 {
+		fmt.Fprintf(stderr, "no rule files\n%s", usage)
+		return 2
+	}
+
+	config := roulette.TextTemplateParserConfig{
+		WorkflowPattern:           *workflow,
+		IsWildcardWorkflowPattern: *wildcard,
+		LogLevel:                  "error",
+		Strict:                    command == "validate" || command == "lint",
+	}
+
+	if *cover || *coverHTML != "" {
+		config.Coverage = roulette.NewCoverage()
+	}
+
+	var results []interface{}
+	if command == "run" || command == "explain" {
+		config.Result = roulette.NewResultCallback(func(val interface{}) {
+			results = append(results, val)
+		})
+	}
+
+	parser, err := roulette.NewTextTemplateParserFiles(flags.Args(), config)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+
+	switch command {
+	case "validate":
+		fmt.Fprintln(stdout, "ok")
+		return 0
+	case "lint":
+		warnings, err := roulette.Lint(parser)
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return 1
+		}
+		for _, warning := range warnings {
+			fmt.Fprintln(stdout, warning)
+		}
+		if len(warnings) > 0 {
+			return 1
+		}
+		return 0
+	case "test":
+		code := runTests(parser, stdout, stderr)
+		if err := writeCoverage(config.Coverage, *cover, *coverHTML, stdout); err != nil {
+			fmt.Fprintln(stderr, err)
+			return 1
+		}
+		return code
+	}
+
+	in := stdin
+	if *input != "" {
+		f, err := os.Open(*input)
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return 1
+		}
+		defer f.Close()
+		in = f
+	}
+
+	code := 0
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		facts, err := roulette.ParseFacts([]byte(line))
+		if err != nil {
+			fmt.Fprintf(stderr, "input %d: %v\n", n, err)
+			code = 1
+			continue
+		}
+
+		results = results[:0]
+		if command == "explain" {
+			trace, err := roulette.Explain(parser, facts)
+			if err != nil {
+				fmt.Fprintln(stderr, err)
+				return 1
+			}
+			fmt.Fprintf(stdout, "input %d:\n%s", n, trace)
+		} else {
+			fmt.Fprintf(stdout, "input %d:\n%s", n, parser.Execute(facts))
+		}
+		for _, result := range results {
+			fmt.Fprintf(stdout, "result: %v\n", result)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+
+	if err := writeCoverage(config.Coverage, *cover, *coverHTML, stdout); err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+
+	return code
+}
+
+// writeCoverage prints the coverage and writes it to the html file.
+func writeCoverage(coverage *roulette.Coverage, text bool, htmlFile string, stdout io.Writer) error {
+	if text {
+		err := coverage.WriteText(stdout)
+		if err != nil {
+			return err
+		}
+	}
+
+	if htmlFile == "" {
+		return nil
+	}
+
+	f, err := os.Create(htmlFile)
+	if err != nil {
+		return err
+	}
+
+	err = coverage.WriteHTML(f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// runTests runs the rule tests and returns 1 if a test failed or there are none.
+func runTests(parser roulette.Parser, stdout, stderr io.Writer) int {
+	results, err := roulette.RunRuleTests(parser)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	if len(results) == 0 {
+		fmt.Fprintln(stdout, "no tests")
+		return 1
+	}
+
+	failed := 0
+	for _, result := range results {
+		fmt.Fprintln(stdout, result)
+		if !result.Passed() {
+			failed++
+		}
+	}
+
+	fmt.Fprintf(stdout, "%d passed, %d failed\n", len(results)-failed, failed)
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestRun(t *testing.T) {
+	rules := "../../testrules/rules_facts.yaml"
+
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"validate", rules}, nil, &stdout, &stderr); code != 0 {
+		t.Fatalf("validate: expected exit code 0, got %d: %s", code, stderr.String())
+	}
+
+	stdout.Reset()
+	if code := run([]string{"validate", "../../testrules/rules_strict.xml"}, nil, &stdout, &stderr); code != 1 {
+		t.Fatalf("validate: expected exit code 1, got %d", code)
+	}
+
+	stdout.Reset()
+	if code := run([]string{"lint", rules}, nil, &stdout, &stderr); code != 1 || strings.Count(stdout.String(), "\n") != 2 {
+		t.Fatalf("lint: expected 2 warnings, got %d: %s", code, stdout.String())
+	}
+
+	stdout.Reset()
+	stdin := strings.NewReader(`{"types.Person": {"Name": "a", "Age": 30}}` + "\n\n" + `{"types.Person": {"Name": "b", "Age": 10}}`)
+	if code := run([]string{"run", rules}, stdin, &stdout, &stderr); code != 0 {
+		t.Fatalf("run: expected exit code 0, got %d: %s", code, stderr.String())
+	}
+
+	out := stdout.String()
+	if !strings.Contains(out, "input 1:\nruleset personRules:\n  rule adult (priority 1): fired") ||
+		!strings.Contains(out, "result: a\n") || strings.Contains(out, "result: b") {
+		t.Fatalf("run: unexpected output %s", out)
+	}
+
+	stdout.Reset()
+	if code := run([]string{"test", "../../testrules/rules_tests.xml"}, nil, &stdout, &stderr); code != 1 ||
+		!strings.Contains(stdout.String(), "ok testedRules/noneFire") || !strings.HasSuffix(stdout.String(), "1 passed, 3 failed\n") {
+		t.Fatalf("test: unexpected output %d: %s", code, stdout.String())
+	}
+
+	stdout.Reset()
+	html := t.TempDir() + "/coverage.html"
+	stdin = strings.NewReader(`{"types.Person": {"Name": "b", "Age": 10}}`)
+	if code := run([]string{"run", "-cover", "-coverhtml", html, rules}, stdin, &stdout, &stderr); code != 0 ||
+		!strings.Contains(stdout.String(), "rule adult (../../testrules/rules_facts.yaml): evaluated 1, fired 0, false 1, errored 0") {
+		t.Fatalf("run: expected the coverage, got %d: %s", code, stdout.String())
+	}
+
+	stdout.Reset()
+	stdin = strings.NewReader(`{"types.Person": {"Name": "b", "Age": 10}}`)
+	if code := run([]string{"explain", rules}, stdin, &stdout, &stderr); code != 0 ||
+		!strings.Contains(stdout.String(), "    ge .types.Person.Age 18 -> false\n        .types.Person.Age = 10\n") {
+		t.Fatalf("explain: expected the trace, got %d: %s", code, stdout.String())
+	}
+
+	stderr.Reset()
+	if code := run([]string{"test", "../../testrules/rules_expr.xml"}, nil, &stdout, &stderr); code != 1 ||
+		!strings.Contains(stderr.String(), "unsupported for the expr syntax") {
+		t.Fatalf("test: expected the expr syntax to be unsupported, got %d: %s", code, stderr.String())
+	}
+
+	if code := run([]string{"deploy", rules}, nil, &stdout, &stderr); code != 2 {
+		t.Fatalf("expected exit code 2 for an unknown command, got %d", code)
+	}
+}
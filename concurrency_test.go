@@ -0,0 +1,152 @@
+package roulette
+
+import (
+	"log"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestConcurrentExecute(t *testing.T) {
+	var count int64
+	callback := func(vals interface{}) {
+		atomic.AddInt64(&count, 1)
+	}
+
+	config := TextTemplateParserConfig{
+		Result: NewResultCallback(callback),
+	}
+
+	parser, err := NewParser(readFile("testrules/rules_callback.xml"), config)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	executor := NewSimpleExecutor(parser)
+
+	const goroutines = 16
+	const iterations = 100
+
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < iterations; i++ {
+				t21 := &T2{A: 1, B: 2}
+				t22 := &T2{A: 3, B: 4}
+				report := executor.Execute(t21, t22)
+				if t21.A != 10 {
+					t.Errorf("Expected value to be 10, got %d", t21.A)
+				}
+				ruleset, _ := report.Ruleset("simpleParserTestRules")
+				if len(ruleset.Fired()) != 2 {
+					t.Errorf("Expected 2 rules to fire, got %v", ruleset.Fired())
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if count != goroutines*iterations*3 {
+		t.Fatalf("Expected %d callbacks, got %d", goroutines*iterations*3, count)
+	}
+}
+
+func TestConcurrentParsers(t *testing.T) {
+	parser1, err := NewParser(readFile("testrules/rules_array_same_type.xml"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	parser2, err := NewParser(readFile("testrules/rules_priorities.xml"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				t21 := &T2{A: 1, B: 2}
+				t22 := &T2{A: 1, B: 2}
+				parser1.Execute([]interface{}{t21, t22, map[string]interface{}{"key": i}})
+				if t21.A != 5 {
+					t.Errorf("Expected value to be 5, got %d", t21.A)
+				}
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				t2 := &T2{A: 1, B: 2}
+				parser2.Execute([]interface{}{t2})
+				if t2.A != 5 {
+					t.Errorf("Expected value to be 5, got %d", t2.A)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestSameTypeIndex(t *testing.T) {
+	index := newSameTypeIndex()
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 20; i++ {
+				index.get(i)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if index.get(12) != "12" {
+		t.Fatalf("Expected index 12, got %s", index.get(12))
+	}
+}
+
+func BenchmarkParallelSimpleParser(b *testing.B) {
+	config := TextTemplateParserConfig{
+		LogLevel: "fatal",
+	}
+	parser, err := NewParser(readFile("testrules/rules_callback.xml"), config)
+	if err != nil {
+		log.Fatal(err)
+	}
+	executor := NewSimpleExecutor(parser)
+
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		t2 := &T2{A: 1, B: 2}
+		for pb.Next() {
+			executor.Execute(t2)
+		}
+	})
+}
+
+func BenchmarkParallelSameType(b *testing.B) {
+	config := TextTemplateParserConfig{
+		LogLevel: "fatal",
+	}
+	parser, err := NewParser(readFile("testrules/rules_array_same_type.xml"), config)
+	if err != nil {
+		log.Fatal(err)
+	}
+	executor := NewSimpleExecutor(parser)
+
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		t21 := &T2{A: 1, B: 2}
+		t22 := &T2{A: 1, B: 2}
+		for pb.Next() {
+			executor.Execute(t21, t22)
+		}
+	})
+}
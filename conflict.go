@@ -0,0 +1,144 @@
+package roulette
+
+import (
+	"fmt"
+	"text/template"
+	"text/template/parse"
+)
+
+// the conflict resolution strategies of a ruleset.
+const (
+	// StrategyFirst executes every rule as it fires, in order of priority. It is the default.
+	StrategyFirst = "first"
+	// StrategyCollect evaluates the when blocks of all the rules first and executes the then blocks of the
+	// matches chosen by the ruleset's resolver. Rules with an expression fire as they match.
+	StrategyCollect = "collect"
+)
+
+// the tie breaks of the rules with the same priority.
+const (
+	// TieBreakDeclaration keeps the rules in the order they are declared.
+	TieBreakDeclaration = "declaration"
+	// TieBreakSpecificity orders the rules by their specificity, most specific first, then by declaration.
+	TieBreakSpecificity = "specificity"
+)
+
+// Match is a rule whose when block matched in a ruleset with the collect strategy.
+type Match struct {
+	Rule            string
+	Priority        int
+	ActivationGroup string
+	Specificity     int
+
+	index int // of the rule in the ruleset
+	tmpl  *template.Template
+}
+
+// ConflictResolver chooses the matches of a ruleset with the collect strategy whose then blocks are executed.
+// The matches are in order of execution, it returns the indexes of the chosen matches in the order they are
+// executed. prioritiesCount and activation groups still apply to the chosen matches.
+type ConflictResolver interface {
+	Resolve(matches []Match) []int
+}
+
+// ConflictResolverFunc is a func implementing ConflictResolver.
+type ConflictResolverFunc func(matches []Match) []int
+
+// Resolve calls f(matches).
+func (f ConflictResolverFunc) Resolve(matches []Match) []int {
+	return f(matches)
+}
+
+var (
+	// AllMatchesResolver chooses every match in order. It is the default resolver.
+	AllMatchesResolver ConflictResolver = ConflictResolverFunc(func(matches []Match) []int {
+		chosen := make([]int, len(matches))
+		for i := range matches {
+			chosen[i] = i
+		}
+		return chosen
+	})
+
+	// FirstMatchResolver chooses the first match.
+	FirstMatchResolver ConflictResolver = ConflictResolverFunc(func(matches []Match) []int {
+		if len(matches) == 0 {
+			return nil
+		}
+		return []int{0}
+	})
+
+	// UniqueMatchResolver chooses the match if there is only one.
+	UniqueMatchResolver ConflictResolver = ConflictResolverFunc(func(matches []Match) []int {
+		if len(matches) != 1 {
+			return nil
+		}
+		return []int{0}
+	})
+
+	// MostSpecificResolver chooses the most specific match, the first of the most specific ones.
+	MostSpecificResolver ConflictResolver = ConflictResolverFunc(func(matches []Match) []int {
+		if len(matches) == 0 {
+			return nil
+		}
+		best := 0
+		for i, match := range matches {
+			if match.Specificity > matches[best].Specificity {
+				best = i
+			}
+		}
+		return []int{best}
+	})
+)
+
+// builtinResolvers are the resolvers a ruleset refers to by name.
+var builtinResolvers = map[string]ConflictResolver{
+	"all":          AllMatchesResolver,
+	"first":        FirstMatchResolver,
+	"unique":       UniqueMatchResolver,
+	"mostSpecific": MostSpecificResolver,
+}
+
+// newResolver returns the resolver of a ruleset with the collect strategy and nil otherwise. The resolvers of
+// the config override the builtin ones.
+func newResolver(ruleset *TextTemplateRuleset, resolvers map[string]ConflictResolver) (ConflictResolver, error) {
+	switch ruleset.Strategy {
+	case "", StrategyFirst:
+		if ruleset.Resolver != "" {
+			return nil, fmt.Errorf("resolver %s needs the %s strategy", ruleset.Resolver, StrategyCollect)
+		}
+		return nil, nil
+	case StrategyCollect:
+	default:
+		return nil, fmt.Errorf("strategy %q is not %s or %s", ruleset.Strategy, StrategyFirst, StrategyCollect)
+	}
+
+	name := ruleset.Resolver
+	if name == "" {
+		name = "all"
+	}
+	if resolver, ok := resolvers[name]; ok {
+		return resolver, nil
+	}
+	if resolver, ok := builtinResolvers[name]; ok {
+		return resolver, nil
+	}
+	return nil, fmt.Errorf("resolver %q is not registered", name)
+}
+
+// specificity returns the number of functions called by the rule's condition, e.g. 3 for
+// and (ge .Age 18) (eq .Country "IN").
+func specificity(tmpl *template.Template) int {
+	if tmpl == nil || tmpl.Tree == nil {
+		return 0
+	}
+
+	count := 0
+	walkNodes(tmpl.Tree.Root, func(node parse.Node) {
+		if cmd, ok := node.(*parse.CommandNode); ok && len(cmd.Args) > 0 {
+			if _, ok := cmd.Args[0].(*parse.IdentifierNode); ok {
+				count++
+			}
+		}
+	})
+	return count
+}
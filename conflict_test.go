@@ -0,0 +1,113 @@
+package roulette
+
+import (
+	"log"
+	"strings"
+	"testing"
+)
+
+func TestActivationGroups(t *testing.T) {
+	parser, err := NewTextTemplateParserFiles([]string{"testrules/rules_conflict.xml"},
+		TextTemplateParserConfig{Result: NewResultCallback(func(interface{}) {})})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	groups, _ := parser.Execute(&T2{A: 20}).Ruleset("groups")
+	if groups.Rules[0].Status != RuleFired || groups.Rules[1].Err != ErrActivationGroup || groups.Rules[2].Status != RuleFired {
+		log.Fatalf("Expected only the first firing rule of the group, got %v", groups)
+	}
+
+	groups, _ = parser.Execute(&T2{A: 7}).Ruleset("groups")
+	if groups.Rules[0].Status != RuleFalse || groups.Rules[1].Status != RuleFired {
+		log.Fatalf("Expected the second rule of the group to fire, got %v", groups)
+	}
+}
+
+func TestTieBreak(t *testing.T) {
+	parser, err := NewTextTemplateParserFiles([]string{"testrules/rules_conflict.xml"},
+		TextTemplateParserConfig{Result: NewResultCallback(func(interface{}) {})})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	report := parser.Execute(&T2{A: 20})
+	declaration, _ := report.Ruleset("declaration")
+	if declaration.Rules[0].Name != "b" || declaration.Rules[1].Name != "a" {
+		log.Fatalf("Expected the rules in order of declaration, got %v", declaration)
+	}
+
+	specific, _ := report.Ruleset("specificity")
+	if specific.Rules[0].Name != "narrow" || specific.Rules[1].Name != "broad" {
+		log.Fatalf("Expected the most specific rule first, got %v", specific)
+	}
+
+	warnings, err := Lint(parser)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, w := range warnings {
+		if strings.Contains(w.Message, "same priority") {
+			log.Fatalf("Expected no warning for rules ordered by a tieBreak, got %v", w)
+		}
+	}
+}
+
+func TestCollectStrategy(t *testing.T) {
+	var results []interface{}
+	config := TextTemplateParserConfig{
+		Result: NewResultCallback(func(val interface{}) {
+			results = append(results, val)
+		}),
+	}
+
+	parser, err := NewTextTemplateParserFiles([]string{"testrules/rules_conflict.xml"}, config)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	collect, _ := parser.Execute(&T2{A: 20}).Ruleset("collect")
+	if len(results) != 1 || results[0] != "narrow" {
+		log.Fatalf("Expected the most specific match to be executed, got %v", results)
+	}
+	if collect.Rules[0].Err != ErrNotChosen || collect.Rules[1].Status != RuleFired || collect.Rules[2].Status != RuleFalse {
+		log.Fatalf("Expected the matches to be resolved, got %v", collect)
+	}
+
+	// a resolver of the config overrides the builtin ones
+	results = nil
+	config.Resolvers = map[string]ConflictResolver{
+		"mostSpecific": ConflictResolverFunc(func(matches []Match) []int {
+			return []int{1, 0}
+		}),
+	}
+	parser, err = NewTextTemplateParserFiles([]string{"testrules/rules_conflict.xml"}, config)
+	if err != nil {
+		log.Fatal(err)
+	}
+	parser.Execute(&T2{A: 20})
+	if len(results) != 2 || results[0] != "narrow" || results[1] != "broad" {
+		log.Fatalf("Expected the matches chosen by the config's resolver in its order, got %v", results)
+	}
+}
+
+func TestConflictInvalid(t *testing.T) {
+	rulesets := []struct {
+		attrs string
+		err   string
+	}{
+		{`strategy="best"`, "strategy"},
+		{`strategy="collect" resolver="unknown"`, "not registered"},
+		{`resolver="first"`, "needs the collect strategy"},
+		{`tieBreak="alphabetical"`, "tieBreak"},
+	}
+
+	for _, r := range rulesets {
+		data := `<roulette><ruleset name="r" dataKey="TestData" filterTypes="roulette.T2" ` + r.attrs +
+			`><rule name="r"><r>true</r></rule></ruleset></roulette>`
+		_, err := NewParser([]byte(data), TextTemplateParserConfig{Strict: true})
+		if err == nil || !strings.Contains(err.Error(), r.err) {
+			log.Fatalf("Expected an error containing %q for %s, got %v", r.err, r.attrs, err)
+		}
+	}
+}
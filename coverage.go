@@ -0,0 +1,454 @@
+package roulette
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/template/parse"
+)
+
+// coverFunc is the func called by the probes added to the branches of the rule templates
+const coverFunc = "__cover"
+
+// Coverage collects which rulesets, rules and template branches were executed by the parsers it is set on.
+// Set it in TextTemplateParserConfig, execute a suite of inputs and write the coverage as text or html.
+// Compiling a ruleset again, e.g. by a ReloadingParser, resets its coverage.
+type Coverage struct {
+	mu       sync.Mutex
+	rulesets []*rulesetCoverage
+}
+
+// RulesetCoverage is the coverage of a ruleset.
+type RulesetCoverage struct {
+	Name            string
+	Pos             string // file:line of the ruleset. lines are known for xml rules
+	Executed        int    // inputs matched by filterTypes
+	FilterSkipped   int    // inputs not matched by filterTypes
+	WorkflowSkipped int    // inputs skipped because the workflow did not match
+	Rules           []RuleCoverage
+}
+
+// RuleCoverage is the coverage of a rule.
+type RuleCoverage struct {
+	Name      string
+	Pos       string
+	Evaluated int // times the template was executed
+	Fired     int
+	False     int
+	Errored   int
+	Branches  []BranchCoverage
+}
+
+// BranchCoverage counts the branches taken by an if, with or range of a rule template.
+type BranchCoverage struct {
+	Action   string // e.g. if eq .types.Person.Age 18
+	Location string // template:line:col
+	Then     int    // times the body was executed
+	Else     int    // times the else branch, or no branch without an else, was taken
+}
+
+// Dead returns true if the rule was never evaluated.
+func (r RuleCoverage) Dead() bool {
+	return r.Evaluated == 0
+}
+
+type rulesetCoverage struct {
+	RulesetCoverage
+	file  string
+	lines []int // the lines of the rules
+	hits  [][]int64
+}
+
+// NewCoverage returns an empty coverage.
+func NewCoverage() *Coverage {
+	return &Coverage{}
+}
+
+// register adds the compiled ruleset and adds the probes to the branches of its rule templates.
+func (c *Coverage) register(ruleset *TextTemplateRuleset) *rulesetCoverage {
+	cov := &rulesetCoverage{
+		RulesetCoverage: RulesetCoverage{Name: ruleset.Name, Pos: ruleset.pos.String()},
+		file:            ruleset.pos.file,
+	}
+
+	for i := range ruleset.Rules {
+		rule := &ruleset.Rules[i]
+		ruleCov := RuleCoverage{Name: rule.Name, Pos: rule.pos.String()}
+		if rule.config.template != nil {
+			ruleCov.Branches = addProbes(rule)
+		}
+
+		cov.Rules = append(cov.Rules, ruleCov)
+		cov.lines = append(cov.lines, rule.pos.line)
+		cov.hits = append(cov.hits, make([]int64, 2*len(ruleCov.Branches)))
+
+		if rule.config.template != nil {
+			hits := cov.hits[i]
+			rule.config.template.Funcs(map[string]interface{}{
+				coverFunc: func(probe int) string {
+					atomic.AddInt64(&hits[probe], 1)
+					return ""
+				},
+			})
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, r := range c.rulesets {
+		if r.Name == cov.Name {
+			c.rulesets[i] = cov
+			return cov
+		}
+	}
+	c.rulesets = append(c.rulesets, cov)
+	return cov
+}
+
+// addProbes adds a probe at the start of the body and of the else branch of every if, with and range of
+// the rule's templates. The probes of the nth branch are 2n and 2n+1.
+func addProbes(rule *Rule) []BranchCoverage {
+	var branches []BranchCoverage
+
+	templates := rule.config.template.Templates()
+	sort.Slice(templates, func(i, j int) bool { return templates[i].Name() < templates[j].Name() })
+
+	for _, tmpl := range templates {
+		if tmpl.Tree == nil {
+			continue
+		}
+
+		tree := tmpl.Tree
+		var probe func(node parse.Node)
+		probe = func(node parse.Node) {
+			var branch *parse.BranchNode
+			var action string
+			switch n := node.(type) {
+			case *parse.IfNode:
+				branch, action = &n.BranchNode, "if"
+			case *parse.WithNode:
+				branch, action = &n.BranchNode, "with"
+			case *parse.RangeNode:
+				branch, action = &n.BranchNode, "range"
+			default:
+				return
+			}
+
+			location, _ := tree.ErrorContext(node)
+			id := len(branches)
+			branches = append(branches, BranchCoverage{Action: action + " " + branch.Pipe.String(), Location: location})
+
+			// the nested branches are numbered after this one
+			walkTop(branch.List, probe)
+			if branch.ElseList != nil {
+				walkTop(branch.ElseList, probe)
+			}
+
+			branch.List.Nodes = append([]parse.Node{probeNode(2 * id)}, branch.List.Nodes...)
+			if branch.ElseList == nil {
+				branch.ElseList = &parse.ListNode{NodeType: parse.NodeList}
+			}
+			branch.ElseList.Nodes = append([]parse.Node{probeNode(2*id + 1)}, branch.ElseList.Nodes...)
+		}
+
+		walkTop(tree.Root, probe)
+	}
+
+	return branches
+}
+
+// walkTop calls fn for the top level actions of the list. fn walks the nested ones.
+func walkTop(list *parse.ListNode, fn func(parse.Node)) {
+	for _, node := range list.Nodes {
+		fn(node)
+	}
+}
+
+// probeNode returns the action {{__cover id}} which outputs nothing.
+func probeNode(id int) parse.Node {
+	return &parse.ActionNode{
+		NodeType: parse.NodeAction,
+		Pipe: &parse.PipeNode{
+			NodeType: parse.NodePipe,
+			Cmds: []*parse.CommandNode{{
+				NodeType: parse.NodeCommand,
+				Args: []parse.Node{
+					&parse.IdentifierNode{NodeType: parse.NodeIdentifier, Ident: coverFunc},
+					numberNode(id),
+				},
+			}},
+		},
+	}
+}
+
+// record adds the outcome of an execution of the ruleset.
+func (r *rulesetCoverage) record(c *Coverage, report RulesetReport) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if report.Skipped {
+		switch report.Err {
+		case ErrFilterTypes:
+			r.FilterSkipped++
+		case ErrWorkflowMismatch:
+			r.WorkflowSkipped++
+		}
+		return
+	}
+
+	r.Executed++
+	for i, rule := range report.Rules {
+		if i >= len(r.Rules) {
+			break
+		}
+
+		switch rule.Status {
+		case RuleFired:
+			r.Rules[i].Fired++
+		case RuleFalse:
+			r.Rules[i].False++
+		case RuleErrored:
+			r.Rules[i].Errored++
+		default:
+			continue
+		}
+		r.Rules[i].Evaluated++
+	}
+}
+
+// Rulesets returns a snapshot of the coverage of the rulesets, in the order they were compiled.
+func (c *Coverage) Rulesets() []RulesetCoverage {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	rulesets := make([]RulesetCoverage, len(c.rulesets))
+	for i, r := range c.rulesets {
+		rulesets[i] = r.RulesetCoverage
+		rulesets[i].Rules = make([]RuleCoverage, len(r.Rules))
+		for j, rule := range r.Rules {
+			rule.Branches = append([]BranchCoverage(nil), rule.Branches...)
+			for k := range rule.Branches {
+				rule.Branches[k].Then = int(atomic.LoadInt64(&r.hits[j][2*k]))
+				rule.Branches[k].Else = int(atomic.LoadInt64(&r.hits[j][2*k+1]))
+			}
+			rulesets[i].Rules[j] = rule
+		}
+	}
+	return rulesets
+}
+
+// coverageTotals counts the rules evaluated and fired and the branches taken.
+func coverageTotals(rulesets []RulesetCoverage) (rules, evaluated, fired, branches, taken int) {
+	for _, ruleset := range rulesets {
+		for _, rule := range ruleset.Rules {
+			rules++
+			if rule.Evaluated > 0 {
+				evaluated++
+			}
+			if rule.Fired > 0 {
+				fired++
+			}
+			for _, branch := range rule.Branches {
+				branches += 2
+				if branch.Then > 0 {
+					taken++
+				}
+				if branch.Else > 0 {
+					taken++
+				}
+			}
+		}
+	}
+	return
+}
+
+// WriteText writes the coverage of every ruleset, rule and branch. Rules which were never evaluated are marked DEAD.
+func (c *Coverage) WriteText(w io.Writer) error {
+	rulesets := c.Rulesets()
+
+	var buf bytes.Buffer
+	writeRulesetsText(&buf, rulesets)
+
+	rules, evaluated, fired, branches, taken := coverageTotals(rulesets)
+	fmt.Fprintf(&buf, "rules evaluated %s, rules fired %s, branches taken %s\n",
+		percent(evaluated, rules), percent(fired, rules), percent(taken, branches))
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+func writeRulesetsText(buf *bytes.Buffer, rulesets []RulesetCoverage) {
+	for _, ruleset := range rulesets {
+		fmt.Fprintf(buf, "ruleset %s%s: executed %d, skipped by filterTypes %d, skipped by workflow %d\n",
+			ruleset.Name, posSuffix(ruleset.Pos), ruleset.Executed, ruleset.FilterSkipped, ruleset.WorkflowSkipped)
+
+		for _, rule := range ruleset.Rules {
+			dead := ""
+			if rule.Dead() {
+				dead = " DEAD"
+			}
+			fmt.Fprintf(buf, "  rule %s%s: evaluated %d, fired %d, false %d, errored %d%s\n",
+				rule.Name, posSuffix(rule.Pos), rule.Evaluated, rule.Fired, rule.False, rule.Errored, dead)
+
+			for _, branch := range rule.Branches {
+				fmt.Fprintf(buf, "    %s (%s): then %d, else %d\n", branch.Action, branch.Location, branch.Then, branch.Else)
+			}
+		}
+	}
+}
+
+func posSuffix(pos string) string {
+	if pos == "" {
+		return ""
+	}
+	return " (" + pos + ")"
+}
+
+func percent(n, total int) string {
+	if total == 0 {
+		return "0/0"
+	}
+	return fmt.Sprintf("%d/%d (%.1f%%)", n, total, 100*float64(n)/float64(total))
+}
+
+// coverageLine is a line of a rule file in the html view.
+type coverageLine struct {
+	Number int
+	Text   string
+	Class  string // fired, evaluated or dead for the lines of a rule
+	Title  string
+	Notes  []string // the branches of the rule, after its first line
+}
+
+type coverageFile struct {
+	Name  string
+	Lines []coverageLine
+}
+
+// WriteHTML writes the rule files annotated with the coverage of their rules. Rules which fired, were only
+// evaluated and were never evaluated are highlighted. Rulesets read from data instead of a file are written as text.
+func (c *Coverage) WriteHTML(w io.Writer) error {
+	rulesets := c.Rulesets()
+
+	var files []*coverageFile
+	byName := map[string]*coverageFile{}
+	var unannotated bytes.Buffer
+
+	c.mu.Lock()
+	sources := make([]string, len(c.rulesets))
+	ruleLines := make([][]int, len(c.rulesets))
+	for i, r := range c.rulesets {
+		sources[i] = r.file
+		ruleLines[i] = r.lines
+	}
+	c.mu.Unlock()
+
+	for i, ruleset := range rulesets {
+		file, ok := byName[sources[i]]
+		if !ok && sources[i] != "" {
+			lines, err := readLines(sources[i])
+			if err == nil {
+				file = &coverageFile{Name: sources[i], Lines: lines}
+				files = append(files, file)
+				byName[sources[i]] = file
+			}
+		}
+
+		if file == nil {
+			writeRulesetsText(&unannotated, []RulesetCoverage{ruleset})
+			continue
+		}
+
+		for j, rule := range ruleset.Rules {
+			if j >= len(ruleLines[i]) || ruleLines[i][j] <= 0 || ruleLines[i][j] > len(file.Lines) {
+				continue
+			}
+			annotateRule(file.Lines, ruleLines[i][j]-1, rule)
+		}
+	}
+
+	rules, evaluated, fired, branches, taken := coverageTotals(rulesets)
+	return coverageTemplate.Execute(w, map[string]interface{}{
+		"Files":       files,
+		"Unannotated": unannotated.String(),
+		"Summary": fmt.Sprintf("rules evaluated %s, rules fired %s, branches taken %s",
+			percent(evaluated, rules), percent(fired, rules), percent(taken, branches)),
+	})
+}
+
+// annotateRule marks the lines from the rule's start element to its end element.
+func annotateRule(lines []coverageLine, start int, rule RuleCoverage) {
+	class := "dead"
+	switch {
+	case rule.Fired > 0:
+		class = "fired"
+	case rule.Evaluated > 0:
+		class = "evaluated"
+	}
+
+	title := fmt.Sprintf("rule %s: evaluated %d, fired %d, false %d, errored %d",
+		rule.Name, rule.Evaluated, rule.Fired, rule.False, rule.Errored)
+
+	for i := start; i < len(lines); i++ {
+		lines[i].Class = class
+		lines[i].Title = title
+		if strings.Contains(lines[i].Text, "</rule>") || i == start && strings.Contains(lines[i].Text, "/>") {
+			break
+		}
+	}
+
+	for _, branch := range rule.Branches {
+		lines[start].Notes = append(lines[start].Notes,
+			fmt.Sprintf("%s (%s): then %d, else %d", branch.Action, branch.Location, branch.Then, branch.Else))
+	}
+}
+
+func readLines(name string) ([]coverageLine, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var lines []coverageLine
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		lines = append(lines, coverageLine{Number: len(lines) + 1, Text: scanner.Text()})
+	}
+	return lines, scanner.Err()
+}
+
+var coverageTemplate = template.Must(template.New("coverage").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>roulette coverage</title>
+<style>
+body { font-family: sans-serif; }
+pre { font-family: monospace; margin: 0; }
+.line { white-space: pre; font-family: monospace; }
+.number { color: #999; display: inline-block; width: 4em; text-align: right; margin-right: 1em; }
+.fired { background: #c8f0c8; }
+.evaluated { background: #f8f0b0; }
+.dead { background: #f4c0c0; }
+.note { font-family: monospace; color: #555; margin-left: 5em; }
+</style>
+</head>
+<body>
+<p>{{.Summary}}. <span class="fired">fired</span> <span class="evaluated">evaluated, never fired</span> <span class="dead">never evaluated</span></p>
+{{range .Files}}<h3>{{.Name}}</h3>
+{{range .Lines}}<div class="line {{.Class}}"{{if .Title}} title="{{.Title}}"{{end}}><span class="number">{{.Number}}</span>{{.Text}}</div>
+{{range .Notes}}<div class="note">{{.}}</div>
+{{end}}{{end}}{{end}}{{if .Unannotated}}<h3>rule data</h3>
+<pre>{{.Unannotated}}</pre>
+{{end}}</body>
+</html>
+`))
@@ -0,0 +1,81 @@
+package roulette
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"testing"
+)
+
+func TestCoverage(t *testing.T) {
+	coverage := NewCoverage()
+	parser, err := NewTextTemplateParserFiles([]string{"testrules/rules_coverage.xml"}, TextTemplateParserConfig{Coverage: coverage})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	for _, t2 := range []*T2{{A: 1, B: 2}, {A: 1, B: 3}, {A: 1, B: 2}} {
+		report := parser.Execute(t2)
+		if ruleset, _ := report.Ruleset("coveredRules"); t2.B == 2 && len(ruleset.Fired()) != 1 {
+			log.Fatalf("Expected the probes not to change the rule output, got %v", report)
+		}
+	}
+
+	rulesets := coverage.Rulesets()
+	if len(rulesets) != 2 || rulesets[0].Executed != 3 || rulesets[1].FilterSkipped != 3 {
+		log.Fatalf("Expected the ruleset coverage, got %+v", rulesets)
+	}
+
+	branches := rulesets[0].Rules[0]
+	if branches.Fired != 2 || branches.False != 1 || len(branches.Branches) != 2 {
+		log.Fatalf("Expected the rule coverage, got %+v", branches)
+	}
+
+	// with .TestData is always taken, if eq .roulette.T2.B 2 twice
+	if b := branches.Branches[0]; b.Action != "with .TestData" || b.Then != 3 || b.Else != 0 {
+		log.Fatalf("Expected the with branch coverage, got %+v", b)
+	}
+	if b := branches.Branches[1]; b.Action != "if eq .roulette.T2.B 2" || b.Then != 2 || b.Else != 1 {
+		log.Fatalf("Expected the if branch coverage, got %+v", b)
+	}
+
+	if !rulesets[1].Rules[0].Dead() || rulesets[0].Rules[1].Dead() {
+		log.Fatal("Expected only the rule of deadRules to be dead")
+	}
+
+	var text bytes.Buffer
+	err = coverage.WriteText(&text)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	for _, line := range []string{
+		"ruleset coveredRules (testrules/rules_coverage.xml:2): executed 3, skipped by filterTypes 0, skipped by workflow 0",
+		"  rule neverFires (testrules/rules_coverage.xml:14): evaluated 3, fired 0, false 3, errored 0",
+		"    if eq .roulette.T2.B 2 (branches:1:55): then 2, else 1",
+		"  rule dead (testrules/rules_coverage.xml:20): evaluated 0, fired 0, false 0, errored 0 DEAD",
+		"rules evaluated 2/3 (66.7%), rules fired 1/3 (33.3%), branches taken 4/8 (50.0%)",
+	} {
+		if !strings.Contains(text.String(), line+"\n") {
+			log.Fatalf("Expected %q in the coverage, got\n%s", line, text.String())
+		}
+	}
+
+	var html bytes.Buffer
+	err = coverage.WriteHTML(&html)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	for _, s := range []string{
+		`<div class="line fired" title="rule branches: evaluated 3, fired 2, false 1, errored 0"><span class="number">4</span>`,
+		`<div class="line evaluated" title="rule neverFires: evaluated 3, fired 0, false 3, errored 0"><span class="number">14</span>`,
+		`<div class="line dead" title="rule dead: evaluated 0, fired 0, false 0, errored 0"><span class="number">22</span>`,
+		`<div class="line "><span class="number">17</span>`,
+		`<div class="note">if eq .roulette.T2.B 2 (branches:1:55): then 2, else 1</div>`,
+	} {
+		if !strings.Contains(html.String(), s) {
+			log.Fatalf("Expected %q in the html coverage, got\n%s", s, html.String())
+		}
+	}
+}
@@ -0,0 +1,295 @@
+package roulette
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// the hit policies of a decision table.
+const (
+	// HitPolicyFirst executes the first matching row. It is the default.
+	HitPolicyFirst = "first"
+	// HitPolicyUnique executes the matching row if only one row matches, none of the matching rows otherwise.
+	HitPolicyUnique = "unique"
+	// HitPolicyCollect executes every matching row.
+	HitPolicyCollect = "collect"
+)
+
+// DecisionTable is a <decisiontable src="discounts.csv"/> element of a rule file, a ruleset of the rows of a
+// csv file. src is relative to the including file. The header of the csv names the columns:
+//
+//   - a field path, e.g. types.Person.Age, is a condition on the field. A cell is empty or - for any value,
+//     a value, a comparison like >=5, !=5, a range like 15..30 or a list of values like SSE,PE.
+//   - the resultKey, e.g. result, puts the values of its cells.
+//   - a setter path, e.g. types.Person.SetDiscount, calls the setter with the values of its cells.
+//
+// Every row is a rule whose when block is the and of its conditions and whose then block puts and sets its
+// values. The filterTypes default to the types of the columns.
+type DecisionTable struct {
+	Name        string `xml:"name,attr" json:"name" yaml:"name"`
+	Src         string `xml:"src,attr" json:"src" yaml:"src"`
+	HitPolicy   string `xml:"hitPolicy,attr,omitempty" json:"hitPolicy,omitempty" yaml:"hitPolicy,omitempty"`
+	FilterTypes string `xml:"filterTypes,attr,omitempty" json:"filterTypes,omitempty" yaml:"filterTypes,omitempty"`
+	DataKey     string `xml:"dataKey,attr" json:"dataKey" yaml:"dataKey"`
+	ResultKey   string `xml:"resultKey,attr,omitempty" json:"resultKey,omitempty" yaml:"resultKey,omitempty"`
+	Workflow    string `xml:"workflow,attr,omitempty" json:"workflow,omitempty" yaml:"workflow,omitempty"`
+}
+
+// decisionTable are the rows of a decision table, expanded into the rules of its ruleset when compiled with
+// the parser's delimiters.
+type decisionTable struct {
+	rows []tableRow
+}
+
+type tableRow struct {
+	line       int
+	conditions []string
+	actions    []string
+}
+
+var fieldPath = regexp.MustCompile(`^(\.[A-Za-z_][A-Za-z0-9_]*)+$`)
+
+// ruleset returns the ruleset of the table with the rows of the csv data read from src.
+func (d DecisionTable) ruleset(src string, data []byte) (TextTemplateRuleset, error) {
+	ruleset := TextTemplateRuleset{
+		Name:            d.Name,
+		FilterTypes:     d.FilterTypes,
+		DataKey:         d.DataKey,
+		ResultKey:       d.ResultKey,
+		Workflow:        d.Workflow,
+		PrioritiesCount: "all",
+		pos:             sourcePos{file: src},
+	}
+
+	resultKey := d.ResultKey
+	if resultKey == "" {
+		resultKey = "result"
+	}
+
+	switch d.HitPolicy {
+	case "", HitPolicyFirst:
+	case HitPolicyUnique:
+		ruleset.Strategy, ruleset.Resolver = StrategyCollect, "unique"
+	case HitPolicyCollect:
+		ruleset.Strategy, ruleset.Resolver = StrategyCollect, "all"
+	default:
+		return ruleset, fmt.Errorf("hitPolicy %q is not %s, %s or %s", d.HitPolicy, HitPolicyFirst, HitPolicyUnique, HitPolicyCollect)
+	}
+
+	r := csv.NewReader(bytes.NewReader(data))
+	r.Comment = '#'
+	r.TrimLeadingSpace = true
+	header, err := r.Read()
+	if err == io.EOF {
+		return ruleset, fmt.Errorf("%s has no header", src)
+	}
+	if err != nil {
+		return ruleset, err
+	}
+
+	// the header, a field path is relative to the dataKey
+	paths := make([]string, len(header))
+	types := map[string]bool{}
+	for i, name := range header {
+		name = strings.TrimSpace(name)
+		if name == resultKey {
+			continue
+		}
+
+		paths[i] = "." + strings.TrimPrefix(name, ".")
+		if !fieldPath.MatchString(paths[i]) {
+			return ruleset, fmt.Errorf("%s: column %q is not a field path or %s", src, name, resultKey)
+		}
+		if elems := strings.Split(paths[i][1:], "."); len(elems) > 2 {
+			types[elems[0]+"."+elems[1]] = true
+		}
+	}
+
+	if ruleset.FilterTypes == "" {
+		var typeNames []string
+		for typeName := range types {
+			typeNames = append(typeNames, typeName)
+		}
+		sort.Strings(typeNames)
+		ruleset.FilterTypes = strings.Join(typeNames, ",")
+	}
+
+	table := &decisionTable{}
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return ruleset, err
+		}
+
+		line, _ := r.FieldPos(0)
+		row := tableRow{line: line}
+		for j, cell := range record {
+			cell = strings.TrimSpace(cell)
+			switch {
+			case paths[j] == "":
+				if cell != "" {
+					row.actions = append(row.actions, fmt.Sprintf(".%s.Put %s", resultKey, tableValue(cell)))
+				}
+			case strings.HasPrefix(paths[j][strings.LastIndex(paths[j], ".")+1:], "Set"):
+				if cell != "" {
+					row.actions = append(row.actions, fmt.Sprintf("%s %s", paths[j], tableValue(cell)))
+				}
+			default:
+				condition, err := tableCondition(paths[j], cell)
+				if err != nil {
+					return ruleset, fmt.Errorf("%s:%d: column %s: %v", src, line, header[j], err)
+				}
+				if condition != "" {
+					row.conditions = append(row.conditions, condition)
+				}
+			}
+		}
+		table.rows = append(table.rows, row)
+	}
+
+	ruleset.table = table
+	return ruleset, nil
+}
+
+// tableCondition returns the condition of a cell on the field path, empty for any value.
+func tableCondition(path, cell string) (string, error) {
+	for _, op := range []struct{ prefix, fn string }{
+		{">=", "ge"}, {"<=", "le"}, {"!=", ""}, {">", "gt"}, {"<", "lt"}, {"=", ""},
+	} {
+		if !strings.HasPrefix(cell, op.prefix) {
+			continue
+		}
+
+		value := strings.TrimSpace(strings.TrimPrefix(cell, op.prefix))
+		if value == "" {
+			return "", fmt.Errorf("%q has no value", cell)
+		}
+		switch op.prefix {
+		case "!=":
+			return "(not " + tableEquals(path, value) + ")", nil
+		case "=":
+			return tableEquals(path, value), nil
+		}
+		return fmt.Sprintf("(%s %s %s)", op.fn, path, tableValue(value)), nil
+	}
+
+	switch {
+	case cell == "" || cell == "-":
+		return "", nil
+	case strings.Contains(cell, ".."):
+		bounds := strings.SplitN(cell, "..", 2)
+		low, high := strings.TrimSpace(bounds[0]), strings.TrimSpace(bounds[1])
+		if low == "" || high == "" {
+			return "", fmt.Errorf("range %q has no bound", cell)
+		}
+		return fmt.Sprintf("(in %s %s %s)", path, tableValue(low), tableValue(high)), nil
+	case strings.Contains(cell, ","):
+		// or takes two values, the values are nested
+		values := strings.Split(cell, ",")
+		condition := tableEquals(path, strings.TrimSpace(values[len(values)-1]))
+		for i := len(values) - 2; i >= 0; i-- {
+			condition = fmt.Sprintf("(or %s %s)", tableEquals(path, strings.TrimSpace(values[i])), condition)
+		}
+		return condition, nil
+	}
+	return tableEquals(path, cell), nil
+}
+
+// tableEquals compares the field to a value. eq is false for zero values, the values are compared by range.
+func tableEquals(path, value string) string {
+	switch tableValue(value) {
+	case "true":
+		return "(and " + path + ")"
+	case "false":
+		return "(not " + path + ")"
+	}
+	return fmt.Sprintf("(in %s %s %s)", path, tableValue(value), tableValue(value))
+}
+
+// tableValue returns the template constant of a cell value: a number, a bool or a string.
+func tableValue(value string) string {
+	if s, err := strconv.Unquote(value); err == nil {
+		return strconv.Quote(s)
+	}
+	if i, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return strconv.FormatInt(i, 10)
+	}
+	if f, err := strconv.ParseFloat(value, 64); err == nil {
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	}
+	if value == "true" || value == "false" {
+		return value
+	}
+	return strconv.Quote(value)
+}
+
+// rules returns the rules of the rows in order, with the delimiters of the parser.
+func (d *decisionTable) rules(ruleset *TextTemplateRuleset, delimLeft, delimRight string) []Rule {
+	action := func(expr string) string {
+		return delimLeft + expr + delimRight
+	}
+
+	var rules []Rule
+	for i, row := range d.rows {
+		// a row without conditions names the types it expects in a comment
+		condition := action("/* "+ruleset.FilterTypes+" */") + action("true")
+		if len(row.conditions) > 0 {
+			condition = action("and " + strings.Join(row.conditions, " "))
+		}
+
+		rule := Rule{
+			Name:     fmt.Sprintf("row %d", row.line),
+			Priority: i + 1,
+			When:     action("with ."+ruleset.DataKey) + condition + action("end"),
+			pos:      sourcePos{file: ruleset.pos.file, line: row.line},
+		}
+		if len(row.actions) > 0 {
+			rule.Then = action("with ." + ruleset.DataKey)
+			for _, expr := range row.actions {
+				rule.Then += action(expr)
+			}
+			rule.Then += action("end")
+		}
+		if ruleset.Strategy == "" {
+			// the first matching row
+			rule.ActivationGroup = ruleset.Name
+		}
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+// NewDecisionTableParser returns a parser of the decision table with the rows of the csv data. The table's src
+// is not read.
+func NewDecisionTableParser(data []byte, table DecisionTable, config ...TextTemplateParserConfig) (Parser, error) {
+	cfg := TextTemplateParserConfig{}
+	if len(config) > 0 {
+		cfg = config[0]
+	}
+
+	cfg, err := defaultConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	src := table.Src
+	if src == "" {
+		src = table.Name + ".csv"
+	}
+
+	ruleset, err := table.ruleset(src, data)
+	if err != nil {
+		return nil, fmt.Errorf("decisiontable %s: %v", table.Name, err)
+	}
+
+	return newTextTemplateParser(XMLData{Rulesets: []TextTemplateRuleset{ruleset}}, cfg)
+}
@@ -0,0 +1,102 @@
+package roulette
+
+import (
+	"log"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDecisionTable(t *testing.T) {
+	var results []interface{}
+	config := TextTemplateParserConfig{
+		Result: NewResultCallback(func(val interface{}) {
+			results = append(results, val)
+		}),
+	}
+
+	tests := []struct {
+		hitPolicy string
+		input     T2
+		results   []interface{}
+		a         int
+	}{
+		{HitPolicyFirst, T2{A: 70, B: 1}, []interface{}{"senior"}, 70},
+		{HitPolicyCollect, T2{A: 70, B: 1}, []interface{}{"senior", "any"}, 70},
+		{HitPolicyUnique, T2{A: 70, B: 1}, nil, 70},
+		{HitPolicyCollect, T2{A: 30, B: 2}, []interface{}{"adult", "any"}, 30},
+		{HitPolicyCollect, T2{A: 30, B: 3}, []interface{}{"any"}, 30},
+		{HitPolicyFirst, T2{A: 10}, []interface{}{"child"}, 1},
+	}
+
+	for _, test := range tests {
+		table := DecisionTable{Name: "discounts", HitPolicy: test.hitPolicy, DataKey: "TestData"}
+		parser, err := NewDecisionTableParser(readFile("testrules/discounts.csv"), table, config)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		results = nil
+		input := test.input
+		report := parser.Execute(&input)
+		if !reflect.DeepEqual(results, test.results) || input.A != test.a {
+			log.Fatalf("Expected %v and A %d with the %s hit policy for %v, got %v and %d\n%v",
+				test.results, test.a, test.hitPolicy, test.input, results, input.A, report)
+		}
+	}
+}
+
+func TestDecisionTableFile(t *testing.T) {
+	var results []interface{}
+	config := TextTemplateParserConfig{
+		Result: NewResultCallback(func(val interface{}) {
+			results = append(results, val)
+		}),
+	}
+
+	parser, err := NewTextTemplateParserFiles([]string{"testrules/rules_table.xml"}, config)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	report := parser.Execute(&T2{A: 70, B: 1})
+	if len(report.Rulesets) != 2 || report.Rulesets[1].Name != "discounts" {
+		log.Fatalf("Expected the decision table after the rulesets, got %v", report)
+	}
+
+	discounts := report.Rulesets[1]
+	if discounts.Rules[0].Name != "row 3" || discounts.Rules[0].Status != RuleFired || discounts.Rules[2].Status != RuleFalse {
+		log.Fatalf("Expected the rows as rules, got %v", discounts)
+	}
+	if !reflect.DeepEqual(results, []interface{}{"senior", "any"}) {
+		log.Fatalf("Expected the results of the matching rows, got %v", results)
+	}
+
+	// the table is kept as an element
+	converted, err := ConvertRules(readFile("testrules/rules_table.xml"), FormatXML, FormatJSON)
+	if err != nil || !strings.Contains(string(converted), `"src": "discounts.csv"`) {
+		log.Fatalf("Expected the decision table to be converted, got %s %v", converted, err)
+	}
+}
+
+func TestDecisionTableInvalid(t *testing.T) {
+	tables := []struct {
+		csv       string
+		hitPolicy string
+		err       string
+	}{
+		{"roulette.T2.A,result\n>=,x\n", "", `">=" has no value`},
+		{"roulette.T2.A,result\n1..,x\n", "", "has no bound"},
+		{"1A,result\n1,x\n", "", "is not a field path"},
+		{"", "", "has no header"},
+		{"roulette.T2.A,result\n1,x\n", "any", "hitPolicy"},
+	}
+
+	for _, table := range tables {
+		_, err := NewDecisionTableParser([]byte(table.csv),
+			DecisionTable{Name: "t", DataKey: "TestData", HitPolicy: table.hitPolicy})
+		if err == nil || !strings.Contains(err.Error(), table.err) {
+			log.Fatalf("Expected an error containing %q for %q, got %v", table.err, table.csv, err)
+		}
+	}
+}
@@ -1,15 +1,25 @@
 package roulette
 
-import "time"
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
 
 // SimpleExecute interface provides methods to retrieve a parser and a method which executes on the incoming values.
 type SimpleExecute interface {
-	Execute(vals ...interface{})
+	Execute(vals ...interface{}) ExecutionReport
+	ExecuteContext(ctx context.Context, vals ...interface{}) ExecutionReport
 }
 
 // QueueExecute interface provides methods to retrieve a parser and a method which executes on the incoming values on the input channel.
 type QueueExecute interface {
 	Execute(in <-chan interface{}, out chan<- interface{}) // in channel to write, out channel to read.
+	ExecuteContext(ctx context.Context, in <-chan interface{}, out chan<- interface{})
+	Metrics() QueueMetrics
+	Shutdown(ctx context.Context) error
 	CloseResult()
 }
 
@@ -20,116 +30,398 @@ type SimpleExecutor struct {
 
 // Execute executes rules in order of priority.
 // one(true): executes in order of priority until a high priority rule is successful, after which execution stops
-func (s *SimpleExecutor) Execute(vals ...interface{}) {
-	s.Parser.Execute(vals)
+// The returned report lists the outcome of every ruleset and rule.
+func (s *SimpleExecutor) Execute(vals ...interface{}) ExecutionReport {
+	return s.Parser.Execute(vals)
+}
+
+// ExecuteContext is like Execute but stops evaluating rules once the context is done.
+func (s *SimpleExecutor) ExecuteContext(ctx context.Context, vals ...interface{}) ExecutionReport {
+	return s.Parser.ExecuteContext(ctx, vals)
+}
+
+// QueuePolicy decides what the QueueExecutor does when a buffer is full.
+type QueuePolicy int
+
+const (
+	// QueueBlock blocks the sender until there is space in the buffer.
+	QueueBlock QueuePolicy = iota
+	// QueueDrop drops the value and counts it in QueueMetrics.
+	QueueDrop
+)
+
+// default QueueExecutorConfig values
+var (
+	defaultQueueWorkers    = runtime.NumCPU()
+	defaultQueueMaxPending = 1024
+)
+
+// QueueExecutorConfig sets the optional config for the QueueExecutor
+type QueueExecutorConfig struct {
+	Workers     int         // number of inputs evaluated concurrently. default is runtime.NumCPU()
+	InputBuffer int         // inputs buffered while all workers are busy. default is Workers
+	MaxPending  int         // results buffered while out is not read, the rules putting more block with QueueBlock. default is 1024
+	Policy      QueuePolicy // applied when the input buffer or pending results are full. default is QueueBlock
+}
+
+// QueueMetrics is a snapshot of the QueueExecutor's queues.
+type QueueMetrics struct {
+	Queued         int64 // inputs waiting for a worker
+	InFlight       int64 // inputs being evaluated
+	Pending        int64 // results waiting to be sent on out, in order after the earlier inputs' results
+	Processed      int64 // inputs evaluated
+	DroppedInputs  int64 // inputs dropped because the input buffer was full
+	DroppedResults int64 // results dropped because pending results were full
+}
+
+// ShutdownError is returned by Shutdown when the context expired before all the results were sent on out.
+type ShutdownError struct {
+	Dropped int64 // results which were not sent on out
+	Err     error // the context's error
+}
+
+func (e *ShutdownError) Error() string {
+	return fmt.Sprintf("shutdown: %d results dropped: %v", e.Dropped, e.Err)
+}
+
+// inputSeqKey is the context key of the sequence number of an input read by the QueueExecutor
+type inputSeqKey struct{}
+
+// queuedInput is an input read by the QueueExecutor
+type queuedInput struct {
+	seq  uint64
+	vals interface{}
 }
 
 // QueueExecutor implements the QueueExecute
 type QueueExecutor struct {
+	metrics QueueMetrics // first for 64-bit atomic alignment
+
 	Parser Parser
+
+	config      QueueExecutorConfig
+	work        chan queuedInput
+	cancel      context.CancelFunc
+	stopping    chan struct{} // closed to stop reading the in channel
+	stopOnce    sync.Once
+	workersDone chan struct{}
+	drainDone   chan struct{}
 }
 
 // Execute ...
 func (q *QueueExecutor) Execute(in <-chan interface{}, out chan<- interface{}) {
+	q.ExecuteContext(context.Background(), in, out)
+}
 
-	go q.drainQueue(out)
-	go q.fillQueue(in)
+// ExecuteContext is like Execute but stops reading the in channel and evaluating rules once the context is done.
+func (q *QueueExecutor) ExecuteContext(ctx context.Context, in <-chan interface{}, out chan<- interface{}) {
+
+	// a QueueExecutor which was not created by NewQueueExecutor has the default config
+	q.config = defaultQueueConfig(q.config)
+
+	// the rules putting results block while MaxPending results are pending
+	if resultQueue, ok := q.Parser.GetResult().(ResultQueue); ok {
+		maxWaiting := 0
+		if q.config.Policy == QueueBlock {
+			maxWaiting = q.config.MaxPending
+		}
+		resultQueue.bound(maxWaiting)
+	}
+
+	ctx, q.cancel = context.WithCancel(ctx)
+	q.work = make(chan queuedInput, q.config.InputBuffer)
+	q.stopping = make(chan struct{})
+	q.workersDone = make(chan struct{})
+	q.drainDone = make(chan struct{})
+
+	var workers sync.WaitGroup
+	workers.Add(q.config.Workers)
+	for i := 0; i < q.config.Workers; i++ {
+		go func() {
+			defer workers.Done()
+			q.processWorker(ctx)
+		}()
+	}
+
+	go func() {
+		workers.Wait()
+		close(q.workersDone)
+	}()
+
+	go func() {
+		defer close(q.drainDone)
+		q.drainQueue(ctx, out)
+	}()
+
+	go q.fillQueue(ctx, in)
 
 }
 
-func (q *QueueExecutor) processWorker(vals interface{}) {
-	q.process(vals)
+// Shutdown stops reading the in channel, waits for the in-flight evaluations, sends all their results on out
+// and closes out. If the context expires first, the remaining evaluations are stopped, out is closed and a
+// *ShutdownError reports the number of results dropped. Results are flushed only for a ResultQueue.
+func (q *QueueExecutor) Shutdown(ctx context.Context) error {
+	if q.work == nil {
+		// not executing
+		return nil
+	}
+
+	droppedBefore := atomic.LoadInt64(&q.metrics.DroppedResults)
+	q.stopOnce.Do(func() { close(q.stopping) })
+
+	var err error
+	select {
+	case <-q.workersDone:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	drained := false
+	select {
+	case <-q.drainDone:
+		drained = true
+	default:
+	}
+
+	resultQueue, isResultQueue := q.Parser.GetResult().(ResultQueue)
+	if err == nil && isResultQueue && !drained {
+		err = resultQueue.flush(ctx, q.drainDone)
+	}
+
+	if err != nil {
+		q.cancel()
+	}
+
+	if isResultQueue {
+		atomic.AddInt64(&q.metrics.DroppedResults, resultQueue.abort())
+		resultQueue.closeGet()
+	}
+
+	select {
+	case <-q.drainDone:
+	case <-ctx.Done():
+		err = ctx.Err()
+		q.cancel()
+		<-q.drainDone
+	}
+
+	dropped := atomic.LoadInt64(&q.metrics.DroppedResults) - droppedBefore
+	if err != nil || dropped > 0 {
+		return &ShutdownError{Dropped: dropped, Err: err}
+	}
+
+	return nil
 }
 
-func (q *QueueExecutor) process(vals interface{}) error {
+// Metrics returns a snapshot of the executor's queues.
+func (q *QueueExecutor) Metrics() QueueMetrics {
+	return QueueMetrics{
+		Queued:         int64(len(q.work)),
+		InFlight:       atomic.LoadInt64(&q.metrics.InFlight),
+		Pending:        atomic.LoadInt64(&q.metrics.Pending),
+		Processed:      atomic.LoadInt64(&q.metrics.Processed),
+		DroppedInputs:  atomic.LoadInt64(&q.metrics.DroppedInputs),
+		DroppedResults: atomic.LoadInt64(&q.metrics.DroppedResults),
+	}
+}
+
+func (q *QueueExecutor) processWorker(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case v, ok := <-q.work:
+			if !ok {
+				return
+			}
+			q.process(ctx, v)
+		}
+	}
+}
+
+func (q *QueueExecutor) process(ctx context.Context, input queuedInput) error {
+
+	atomic.AddInt64(&q.metrics.InFlight, 1)
+	q.Parser.ExecuteContext(context.WithValue(ctx, inputSeqKey{}, input.seq), input.vals)
+	atomic.AddInt64(&q.metrics.InFlight, -1)
+	atomic.AddInt64(&q.metrics.Processed, 1)
+
+	if resultQueue, ok := q.Parser.GetResult().(ResultQueue); ok && resultQueue.config.Ordered {
+		resultQueue.markInputDone(ctx, input.seq)
+	}
 
-	q.Parser.Execute(vals)
 	return nil
 }
 
-func (q *QueueExecutor) fillQueue(in <-chan interface{}) {
+func (q *QueueExecutor) fillQueue(ctx context.Context, in <-chan interface{}) {
+	defer close(q.work)
+
+	// inputs are numbered in the order they are read
+	var seq uint64
 fill:
 	for {
 		select {
+		case <-ctx.Done():
+			break fill
+		case <-q.stopping:
+			break fill
 		case v, ok := <-in:
 			if !ok {
 				break fill
 			}
 
-			go q.processWorker(v)
-			//TODO: quit the loop clean
-			//TODO: Pool of process workers
+			input := queuedInput{seq: seq, vals: v}
+
+			if q.config.Policy == QueueDrop {
+				select {
+				case q.work <- input:
+					seq++
+				default:
+					atomic.AddInt64(&q.metrics.DroppedInputs, 1)
+				}
+				continue
+			}
+
+			select {
+			case <-ctx.Done():
+				break fill
+			case q.work <- input:
+				seq++
+			}
 		}
 	}
 }
 
 // adapter from github.com/kylelemons/iq
-func (q *QueueExecutor) drainQueue(out chan<- interface{}) {
+func (q *QueueExecutor) drainQueue(ctx context.Context, out chan<- interface{}) {
 	defer close(out)
 
-	// pending events (this is the "infinite" part)
+	// pending results and the results of an ordered queue waiting for earlier inputs, bounded by MaxPending
 	var pending []interface{}
+	var order *resultOrder
+	queued := func() int {
+		if order == nil {
+			return len(pending)
+		}
+		return len(pending) + order.count
+	}
+
+	// results which could not be sent on out are dropped
+	defer func() {
+		atomic.AddInt64(&q.metrics.DroppedResults, int64(queued()))
+		atomic.AddInt64(&q.metrics.Pending, -int64(queued()))
+	}()
+
+	results := q.Parser.GetResult().Get().(chan interface{})
+
+	unwrap := false
+	if resultQueue, ok := q.Parser.GetResult().(ResultQueue); ok && resultQueue.config.Ordered {
+		order = newResultOrder(resultQueue.state.window)
+		unwrap = !resultQueue.config.Envelope
+	}
+
+	queueResult := func(v interface{}) {
+		if order == nil {
+			q.queueResult(&pending, v)
+			return
+		}
+
+		// with QueueDrop the results of later inputs are dropped once MaxPending results are queued
+		if env, ok := v.(ResultEnvelope); ok && env.seq != order.next {
+			if queued() >= q.config.MaxPending && q.config.Policy == QueueDrop {
+				atomic.AddInt64(&q.metrics.DroppedResults, 1)
+				return
+			}
+		}
+
+		waiting := order.count
+		released := order.add(v)
+		atomic.AddInt64(&q.metrics.Pending, int64(order.count-waiting))
+		for _, released := range released {
+			if env, ok := released.(ResultEnvelope); ok && unwrap {
+				released = env.Value
+			}
+			q.queueResult(&pending, released)
+		}
+	}
 
 recv:
 	for {
 		// Ensure that pending always has values so the select can
 		// multiplex between the receiver and sender properly
 		if len(pending) == 0 {
-			v, ok := <-q.Parser.GetResult().Get().(chan interface{})
-			if !ok {
-				// in is closed, flush values
-				break recv
+			select {
+			case <-ctx.Done():
+				return
+			case v, ok := <-results:
+				if !ok {
+					// results are closed, flush values
+					break recv
+				}
+				queueResult(v)
 			}
+			continue
+		}
 
-			switch v.(type) {
-			case empty:
-				continue
-			}
-
-			pending = append(pending, v)
-
+		// stop receiving results when pending is full so that the rules putting results block
+		recvResults := results
+		if queued() >= q.config.MaxPending && q.config.Policy == QueueBlock {
+			recvResults = nil
 		}
 
 		select {
+		case <-ctx.Done():
+			return
 		// Queue incoming values
-		case v, ok := <-q.Parser.GetResult().Get().(chan interface{}):
+		case v, ok := <-recvResults:
 			if !ok {
-				// in is closed, flush values
+				// results are closed, flush values
 				break recv
 			}
-
-			switch v.(type) {
-			case empty:
-				continue
-			}
-
-			pending = append(pending, v)
+			queueResult(v)
 
 		// Send queued values
 		case out <- pending[0]:
 			pending = pending[1:]
+			atomic.AddInt64(&q.metrics.Pending, -1)
 		}
 	}
 
-	// After in is closed, we may still have events to send
-	for _, v := range pending {
-		out <- v
+	// After results are closed, we may still have values to send
+	for len(pending) > 0 {
+		select {
+		case <-ctx.Done():
+			return
+		case out <- pending[0]:
+			pending = pending[1:]
+			atomic.AddInt64(&q.metrics.Pending, -1)
+		}
 	}
 
 }
 
-// CloseResult closes the result channel
-func (q *QueueExecutor) CloseResult() {
-	q.Parser.GetResult().Get().(chan interface{}) <- quit{}
-	select {
-	case <-q.Parser.GetResult().Get().(chan interface{}):
-	case <-time.After(time.Millisecond * 10):
-		close(q.Parser.GetResult().Get().(chan interface{}))
-		break
+func (q *QueueExecutor) queueResult(pending *[]interface{}, v interface{}) {
+	// with QueueBlock results are not received while pending is full, but the results
+	// released together by an ordered queue may exceed MaxPending.
+	if len(*pending) >= q.config.MaxPending && q.config.Policy == QueueDrop {
+		atomic.AddInt64(&q.metrics.DroppedResults, 1)
+		return
+	}
+	*pending = append(*pending, v)
+	atomic.AddInt64(&q.metrics.Pending, 1)
+}
 
+// CloseResult closes the result queue without waiting for the pending results. Use Shutdown to stop gracefully.
+func (q *QueueExecutor) CloseResult() {
+	resultQueue, ok := q.Parser.GetResult().(ResultQueue)
+	if !ok {
+		return
 	}
 
-}	
+	resultQueue.stop()
+	atomic.AddInt64(&q.metrics.DroppedResults, resultQueue.abort())
+	resultQueue.closeGet()
+}
 
 // NewSimpleExecutor returns a new SimpleExecutor
 func NewSimpleExecutor(parser Parser) SimpleExecute {
@@ -137,6 +429,28 @@ func NewSimpleExecutor(parser Parser) SimpleExecute {
 }
 
 // NewQueueExecutor returns a new QueueExecutor
-func NewQueueExecutor(parser Parser) QueueExecute {
-	return &QueueExecutor{Parser: parser}
+func NewQueueExecutor(parser Parser, config ...QueueExecutorConfig) QueueExecute {
+	cfg := QueueExecutorConfig{}
+	if len(config) > 0 {
+		cfg = config[0]
+	}
+
+	return &QueueExecutor{Parser: parser, config: defaultQueueConfig(cfg)}
+}
+
+// defaultQueueConfig sets the default values of the config.
+func defaultQueueConfig(cfg QueueExecutorConfig) QueueExecutorConfig {
+	if cfg.Workers <= 0 {
+		cfg.Workers = defaultQueueWorkers
+	}
+
+	if cfg.InputBuffer <= 0 {
+		cfg.InputBuffer = cfg.Workers
+	}
+
+	if cfg.MaxPending <= 0 {
+		cfg.MaxPending = defaultQueueMaxPending
+	}
+
+	return cfg
 }
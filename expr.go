@@ -0,0 +1,1044 @@
+package roulette
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// the tokens of the expression syntax.
+const (
+	tokenEOF = iota
+	tokenIdent
+	tokenNumber
+	tokenString
+	tokenOp
+)
+
+type exprToken struct {
+	kind int
+	text string
+	pos  int
+}
+
+// exprOps are the operators and punctuation, longest first.
+var exprOps = []string{"||", "&&", "==", "!=", "<=", ">=", "..", "<", ">", "!", "+", "-", "*", "/", "%", "(", ")",
+	"[", "]", ",", ".", ";"}
+
+// lexExpr splits an expression into tokens.
+func lexExpr(src string) ([]exprToken, error) {
+	var tokens []exprToken
+	i := 0
+next:
+	for i < len(src) {
+		c := rune(src[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '_' || unicode.IsLetter(c):
+			start := i
+			for i < len(src) && (src[i] == '_' || unicode.IsLetter(rune(src[i])) || unicode.IsDigit(rune(src[i]))) {
+				i++
+			}
+			tokens = append(tokens, exprToken{kind: tokenIdent, text: src[start:i], pos: start})
+		case unicode.IsDigit(c):
+			start := i
+			for i < len(src) && unicode.IsDigit(rune(src[i])) {
+				i++
+			}
+			// a fraction, not a range
+			if i+1 < len(src) && src[i] == '.' && unicode.IsDigit(rune(src[i+1])) {
+				i++
+				for i < len(src) && unicode.IsDigit(rune(src[i])) {
+					i++
+				}
+			}
+			tokens = append(tokens, exprToken{kind: tokenNumber, text: src[start:i], pos: start})
+		case c == '"' || c == '\'' || c == '`':
+			start := i
+			i++
+			for i < len(src) && rune(src[i]) != c {
+				if src[i] == '\\' && c != '`' {
+					i++
+				}
+				i++
+			}
+			if i >= len(src) {
+				return nil, fmt.Errorf("col %d: string is not terminated", start+1)
+			}
+			i++
+
+			text := src[start:i]
+			if c == '\'' {
+				// single quoted strings are read like double quoted ones
+				text = `"` + strings.Replace(strings.Replace(text[1:len(text)-1], `\'`, "'", -1), `"`, `\"`, -1) + `"`
+			}
+			s, err := strconv.Unquote(text)
+			if err != nil {
+				return nil, fmt.Errorf("col %d: invalid string %s", start+1, src[start:i])
+			}
+			tokens = append(tokens, exprToken{kind: tokenString, text: s, pos: start})
+		default:
+			for _, op := range exprOps {
+				if strings.HasPrefix(src[i:], op) {
+					tokens = append(tokens, exprToken{kind: tokenOp, text: op, pos: i})
+					i += len(op)
+					continue next
+				}
+			}
+			return nil, fmt.Errorf("col %d: unexpected %q", i+1, c)
+		}
+	}
+	return append(tokens, exprToken{kind: tokenEOF, pos: len(src)}), nil
+}
+
+// exprScope resolves the names of an expression: the filter types of the ruleset, by package and type name or
+// by type name alone, the result key and the functions.
+type exprScope struct {
+	types     map[string]string // the filter types by name, empty if a type name is ambiguous
+	resultKey string
+	funcs     map[string]reflect.Value
+
+	// the names referred to by the parsed expressions
+	refTypes  map[string]bool
+	refResult bool
+}
+
+func newExprScope(filterTypes []string, resultKey string, funcs map[string]interface{}) *exprScope {
+	s := &exprScope{
+		types:     make(map[string]string),
+		resultKey: resultKey,
+		funcs:     make(map[string]reflect.Value, len(funcs)),
+		refTypes:  make(map[string]bool),
+	}
+	for _, typeName := range filterTypes {
+		s.types[typeName] = typeName
+	}
+	for _, typeName := range filterTypes {
+		short := typeName[strings.LastIndex(typeName, ".")+1:]
+		if short == typeName {
+			continue
+		}
+		if other, ok := s.types[short]; ok && other != typeName {
+			s.types[short] = ""
+			continue
+		}
+		s.types[short] = typeName
+	}
+	for name, fn := range funcs {
+		s.funcs[name] = reflect.ValueOf(fn)
+	}
+	return s
+}
+
+// expectTypes returns the sorted filter types referred to by the parsed expressions.
+func (s *exprScope) expectTypes() []string {
+	var types []string
+	for typeName := range s.refTypes {
+		types = append(types, typeName)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// exprParse is the state of parsing an expression.
+type exprParse struct {
+	tokens []exprToken
+	i      int
+	scope  *exprScope
+}
+
+// parseExpr parses an expression.
+func parseExpr(src string, scope *exprScope) (exprNode, error) {
+	tokens, err := lexExpr(src)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &exprParse{tokens: tokens, scope: scope}
+	node, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokenEOF {
+		return nil, p.unexpected(tok)
+	}
+	return node, nil
+}
+
+// parseExprList parses expressions separated by semicolons.
+func parseExprList(src string, scope *exprScope) ([]exprNode, error) {
+	tokens, err := lexExpr(src)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &exprParse{tokens: tokens, scope: scope}
+	var nodes []exprNode
+	for p.peek().kind != tokenEOF {
+		if p.accept(";") {
+			continue
+		}
+		node, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, node)
+		if tok := p.peek(); tok.kind != tokenEOF && !p.accept(";") {
+			return nil, p.unexpected(tok)
+		}
+	}
+	return nodes, nil
+}
+
+func (p *exprParse) peek() exprToken {
+	return p.tokens[p.i]
+}
+
+func (p *exprParse) next() exprToken {
+	tok := p.tokens[p.i]
+	if tok.kind != tokenEOF {
+		p.i++
+	}
+	return tok
+}
+
+// accept consumes the next token if it is the operator or keyword.
+func (p *exprParse) accept(text string) bool {
+	tok := p.peek()
+	if (tok.kind == tokenOp || tok.kind == tokenIdent) && tok.text == text {
+		p.i++
+		return true
+	}
+	return false
+}
+
+func (p *exprParse) expect(text string) error {
+	if !p.accept(text) {
+		return fmt.Errorf("col %d: expected %s, found %s", p.peek().pos+1, text, p.describe(p.peek()))
+	}
+	return nil
+}
+
+func (p *exprParse) unexpected(tok exprToken) error {
+	return fmt.Errorf("col %d: unexpected %s", tok.pos+1, p.describe(tok))
+}
+
+func (p *exprParse) describe(tok exprToken) string {
+	switch tok.kind {
+	case tokenEOF:
+		return "end of expression"
+	case tokenString:
+		return strconv.Quote(tok.text)
+	}
+	return fmt.Sprintf("%q", tok.text)
+}
+
+// or: and {("||" | "or") and}
+func (p *exprParse) or() (exprNode, error) {
+	x, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("||") || p.accept("or") {
+		y, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		x = &exprBinary{op: "||", x: x, y: y}
+	}
+	return x, nil
+}
+
+// and: comparison {("&&" | "and") comparison}
+func (p *exprParse) and() (exprNode, error) {
+	x, err := p.comparison()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("&&") || p.accept("and") {
+		y, err := p.comparison()
+		if err != nil {
+			return nil, err
+		}
+		x = &exprBinary{op: "&&", x: x, y: y}
+	}
+	return x, nil
+}
+
+// comparison: sum [("==" | "!=" | "<" | "<=" | ">" | ">=") sum | "in" (sum [".." sum])]
+func (p *exprParse) comparison() (exprNode, error) {
+	x, err := p.sum()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, op := range []string{"==", "!=", "<=", ">=", "<", ">"} {
+		if p.accept(op) {
+			y, err := p.sum()
+			if err != nil {
+				return nil, err
+			}
+			return &exprBinary{op: op, x: x, y: y}, nil
+		}
+	}
+
+	if p.accept("in") {
+		y, err := p.sum()
+		if err != nil {
+			return nil, err
+		}
+		if p.accept("..") {
+			high, err := p.sum()
+			if err != nil {
+				return nil, err
+			}
+			y = &exprRange{low: y, high: high}
+		}
+		return &exprBinary{op: "in", x: x, y: y}, nil
+	}
+
+	return x, nil
+}
+
+// sum: product {("+" | "-") product}
+func (p *exprParse) sum() (exprNode, error) {
+	x, err := p.product()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op := p.peek().text
+		if p.peek().kind != tokenOp || (op != "+" && op != "-") {
+			return x, nil
+		}
+		p.next()
+		y, err := p.product()
+		if err != nil {
+			return nil, err
+		}
+		x = &exprBinary{op: op, x: x, y: y}
+	}
+}
+
+// product: unary {("*" | "/" | "%") unary}
+func (p *exprParse) product() (exprNode, error) {
+	x, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op := p.peek().text
+		if p.peek().kind != tokenOp || (op != "*" && op != "/" && op != "%") {
+			return x, nil
+		}
+		p.next()
+		y, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		x = &exprBinary{op: op, x: x, y: y}
+	}
+}
+
+// unary: ("!" | "not" | "-") unary | primary
+func (p *exprParse) unary() (exprNode, error) {
+	if p.accept("!") || p.accept("not") {
+		x, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return &exprUnary{op: "!", x: x}, nil
+	}
+	if p.accept("-") {
+		x, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return &exprUnary{op: "-", x: x}, nil
+	}
+	return p.primary()
+}
+
+// primary: number | string | true | false | nil | "(" or ")" | "[" [or {"," or}] "]" | path [call] | func call
+func (p *exprParse) primary() (exprNode, error) {
+	tok := p.next()
+	switch tok.kind {
+	case tokenNumber:
+		if i, err := strconv.ParseInt(tok.text, 10, 64); err == nil {
+			return &exprLiteral{val: i}, nil
+		}
+		f, err := strconv.ParseFloat(tok.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("col %d: invalid number %s", tok.pos+1, tok.text)
+		}
+		return &exprLiteral{val: f}, nil
+	case tokenString:
+		return &exprLiteral{val: tok.text}, nil
+	case tokenOp:
+		switch tok.text {
+		case "(":
+			x, err := p.or()
+			if err != nil {
+				return nil, err
+			}
+			return x, p.expect(")")
+		case "[":
+			list := &exprList{}
+			for !p.accept("]") {
+				if len(list.elems) > 0 {
+					if err := p.expect(","); err != nil {
+						return nil, err
+					}
+				}
+				x, err := p.or()
+				if err != nil {
+					return nil, err
+				}
+				list.elems = append(list.elems, x)
+			}
+			return list, nil
+		}
+	case tokenIdent:
+		switch tok.text {
+		case "true":
+			return &exprLiteral{val: true}, nil
+		case "false":
+			return &exprLiteral{val: false}, nil
+		case "nil":
+			return &exprLiteral{val: nil}, nil
+		}
+		return p.path(tok)
+	}
+	return nil, p.unexpected(tok)
+}
+
+// path: ident {"." ident} ["(" [or {"," or}] ")"]
+func (p *exprParse) path(first exprToken) (exprNode, error) {
+	names := []string{first.text}
+	for p.accept(".") {
+		tok := p.next()
+		if tok.kind != tokenIdent {
+			return nil, fmt.Errorf("col %d: expected a field name, found %s", tok.pos+1, p.describe(tok))
+		}
+		names = append(names, tok.text)
+	}
+
+	var args []exprNode
+	call := p.accept("(")
+	if call {
+		for !p.accept(")") {
+			if len(args) > 0 {
+				if err := p.expect(","); err != nil {
+					return nil, err
+				}
+			}
+			x, err := p.or()
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, x)
+		}
+	}
+
+	text := strings.Join(names, ".")
+	if call && len(names) == 1 {
+		fn, ok := p.scope.funcs[names[0]]
+		if !ok {
+			return nil, fmt.Errorf("col %d: function %s is not defined", first.pos+1, names[0])
+		}
+		return &exprCall{name: text, fn: fn, args: args}, nil
+	}
+
+	path := &exprPath{text: text}
+	switch {
+	case names[0] == p.scope.resultKey:
+		path.result = true
+		path.fields = names[1:]
+		p.scope.refResult = true
+	case len(names) > 1 && p.scope.types[names[0]+"."+names[1]] == names[0]+"."+names[1]:
+		// the package and type name
+		path.typeName = names[0] + "." + names[1]
+		path.fields = names[2:]
+	default:
+		typeName, ok := p.scope.types[names[0]]
+		if !ok {
+			return nil, fmt.Errorf("col %d: %s is not a filter type or the result", first.pos+1, names[0])
+		}
+		if typeName == "" {
+			return nil, fmt.Errorf("col %d: type %s is ambiguous, name its package", first.pos+1, names[0])
+		}
+		path.typeName = typeName
+		path.fields = names[1:]
+	}
+	if path.typeName != "" {
+		p.scope.refTypes[path.typeName] = true
+	}
+
+	if !call {
+		return path, nil
+	}
+	if len(path.fields) == 0 {
+		return nil, fmt.Errorf("col %d: %s is not a method", first.pos+1, text)
+	}
+	method := path.fields[len(path.fields)-1]
+	path.fields = path.fields[:len(path.fields)-1]
+	return &exprCall{name: text, recv: path, method: method, args: args}, nil
+}
+
+// exprEnv are the values an expression is evaluated on: the input values by type name and the result.
+type exprEnv struct {
+	values map[string]reflect.Value
+	result Result
+}
+
+// newExprEnv returns the environment of the input values. Of the values of the same type the first is used.
+func newExprEnv(vals interface{}, result Result) *exprEnv {
+	env := &exprEnv{values: make(map[string]reflect.Value), result: result}
+	var add func(val interface{})
+	add = func(val interface{}) {
+		switch v := val.(type) {
+		case []interface{}:
+			for _, e := range v {
+				add(e)
+			}
+		case Facts:
+			for typeName, fact := range v {
+				if _, ok := env.values[typeName]; !ok {
+					env.values[typeName] = reflect.ValueOf(fact)
+				}
+			}
+		default:
+			rv := reflect.ValueOf(val)
+			if !rv.IsValid() {
+				return
+			}
+			typeName := rv.Type().String()
+			if rv.Kind() == reflect.Ptr {
+				typeName = rv.Type().Elem().String()
+			}
+			if _, ok := env.values[typeName]; !ok {
+				env.values[typeName] = rv
+			}
+		}
+	}
+	add(vals)
+	return env
+}
+
+// exprNode is a node of the syntax tree of an expression.
+type exprNode interface {
+	eval(env *exprEnv) (interface{}, error)
+}
+
+type exprLiteral struct {
+	val interface{}
+}
+
+func (n *exprLiteral) eval(env *exprEnv) (interface{}, error) {
+	return n.val, nil
+}
+
+type exprList struct {
+	elems []exprNode
+}
+
+func (n *exprList) eval(env *exprEnv) (interface{}, error) {
+	vals := make([]interface{}, len(n.elems))
+	for i, elem := range n.elems {
+		val, err := elem.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		vals[i] = val
+	}
+	return vals, nil
+}
+
+// exprRange is the bounds of an in operator, e.g. 15..30. The bounds are included.
+type exprRange struct {
+	low, high exprNode
+}
+
+func (n *exprRange) eval(env *exprEnv) (interface{}, error) {
+	return nil, fmt.Errorf("a range is only valid after in")
+}
+
+// exprPath is a field of an input value, e.g. Person.Age, or the result.
+type exprPath struct {
+	text     string
+	typeName string
+	result   bool
+	fields   []string
+}
+
+func (n *exprPath) value(env *exprEnv) (reflect.Value, error) {
+	var v reflect.Value
+	if n.result {
+		if env.result == nil {
+			return v, ErrNoResult
+		}
+		v = reflect.ValueOf(env.result)
+	} else {
+		var ok bool
+		if v, ok = env.values[n.typeName]; !ok {
+			return v, fmt.Errorf("%s: no value of type %s", n.text, n.typeName)
+		}
+	}
+
+	for _, name := range n.fields {
+		var err error
+		if v, err = exprField(v, name); err != nil {
+			return v, fmt.Errorf("%s: %v", n.text, err)
+		}
+	}
+	return v, nil
+}
+
+func (n *exprPath) eval(env *exprEnv) (interface{}, error) {
+	v, err := n.value(env)
+	if err != nil {
+		return nil, err
+	}
+	return exprValue(v), nil
+}
+
+// exprCall calls a function or a method of a value, e.g. Person.SetDiscount(0.2) or result.Put(Person).
+type exprCall struct {
+	name   string
+	fn     reflect.Value
+	recv   *exprPath
+	method string
+	args   []exprNode
+}
+
+func (n *exprCall) eval(env *exprEnv) (interface{}, error) {
+	fn := n.fn
+	if n.recv != nil {
+		recv, err := n.recv.value(env)
+		if err != nil {
+			return nil, err
+		}
+		var ok bool
+		if fn, ok = exprMethod(recv, n.method); !ok {
+			return nil, fmt.Errorf("%s: %s has no method %s", n.name, recv.Type(), n.method)
+		}
+	}
+
+	args := make([]interface{}, len(n.args))
+	for i, arg := range n.args {
+		val, err := arg.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = val
+	}
+
+	val, err := exprCallFunc(fn, args)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", n.name, err)
+	}
+	return val, nil
+}
+
+type exprUnary struct {
+	op string
+	x  exprNode
+}
+
+func (n *exprUnary) eval(env *exprEnv) (interface{}, error) {
+	x, err := n.x.eval(env)
+	if err != nil {
+		return nil, err
+	}
+
+	if n.op == "!" {
+		b, ok := x.(bool)
+		if !ok {
+			return nil, fmt.Errorf("operand of ! is %s, not a bool", exprType(x))
+		}
+		return !b, nil
+	}
+
+	switch v := x.(type) {
+	case int64:
+		return -v, nil
+	case float64:
+		return -v, nil
+	}
+	return nil, fmt.Errorf("operand of - is %s, not a number", exprType(x))
+}
+
+type exprBinary struct {
+	op   string
+	x, y exprNode
+}
+
+func (n *exprBinary) eval(env *exprEnv) (interface{}, error) {
+	x, err := n.x.eval(env)
+	if err != nil {
+		return nil, err
+	}
+
+	// && and || are short-circuit
+	if n.op == "&&" || n.op == "||" {
+		b, ok := x.(bool)
+		if !ok {
+			return nil, fmt.Errorf("operand of %s is %s, not a bool", n.op, exprType(x))
+		}
+		if b == (n.op == "||") {
+			return b, nil
+		}
+		y, err := n.y.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		if b, ok = y.(bool); !ok {
+			return nil, fmt.Errorf("operand of %s is %s, not a bool", n.op, exprType(y))
+		}
+		return b, nil
+	}
+
+	if r, ok := n.y.(*exprRange); ok && n.op == "in" {
+		low, err := r.low.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		high, err := r.high.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		c, err := exprCompare(x, low)
+		if err != nil || c < 0 {
+			return false, err
+		}
+		c, err = exprCompare(x, high)
+		return c <= 0, err
+	}
+
+	y, err := n.y.eval(env)
+	if err != nil {
+		return nil, err
+	}
+
+	switch n.op {
+	case "==":
+		return exprEqual(x, y), nil
+	case "!=":
+		return !exprEqual(x, y), nil
+	case "<", "<=", ">", ">=":
+		c, err := exprCompare(x, y)
+		if err != nil {
+			return nil, err
+		}
+		switch n.op {
+		case "<":
+			return c < 0, nil
+		case "<=":
+			return c <= 0, nil
+		case ">":
+			return c > 0, nil
+		}
+		return c >= 0, nil
+	case "in":
+		return exprIn(x, y)
+	}
+	return exprArith(n.op, x, y)
+}
+
+// exprValue returns the value of a field: integers as int64, floats as float64 and nil for a missing value.
+func exprValue(v reflect.Value) interface{} {
+	for v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+
+	switch v.Kind() {
+	case reflect.Invalid:
+		return nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return int64(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		return v.Float()
+	case reflect.Bool:
+		return v.Bool()
+	case reflect.String:
+		return v.String()
+	}
+	if !v.CanInterface() {
+		return nil
+	}
+	return v.Interface()
+}
+
+func exprType(val interface{}) string {
+	if val == nil {
+		return "nil"
+	}
+	return reflect.TypeOf(val).String()
+}
+
+// exprField returns the field of a struct or the value of a map key. A missing key is an invalid value.
+func exprField(v reflect.Value, name string) (reflect.Value, error) {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return v, fmt.Errorf("nil value has no field %s", name)
+		}
+		v = v.Elem()
+	}
+
+	switch v.Kind() {
+	case reflect.Struct:
+		f := v.FieldByName(name)
+		if !f.IsValid() {
+			return f, fmt.Errorf("%s has no field %s", v.Type(), name)
+		}
+		if !f.CanInterface() {
+			return f, fmt.Errorf("field %s of %s is not exported", name, v.Type())
+		}
+		return f, nil
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			break
+		}
+		return v.MapIndex(reflect.ValueOf(name).Convert(v.Type().Key())), nil
+	case reflect.Invalid:
+		return v, fmt.Errorf("missing value has no field %s", name)
+	}
+	return v, fmt.Errorf("%s has no field %s", v.Type(), name)
+}
+
+// exprMethod returns the method of a value, of the value pointed to or of the pointer to the value.
+func exprMethod(v reflect.Value, name string) (reflect.Value, bool) {
+	if !v.IsValid() {
+		return v, false
+	}
+	if m := v.MethodByName(name); m.IsValid() {
+		return m, true
+	}
+	if (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) && !v.IsNil() {
+		return exprMethod(v.Elem(), name)
+	}
+	if v.CanAddr() {
+		if m := v.Addr().MethodByName(name); m.IsValid() {
+			return m, true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+// exprCallFunc calls the function with the arguments converted to its parameters. A function can return a
+// value, and an error as its last result.
+func exprCallFunc(fn reflect.Value, args []interface{}) (val interface{}, err error) {
+	t := fn.Type()
+	n := t.NumIn()
+	if t.IsVariadic() && len(args) < n-1 || !t.IsVariadic() && len(args) != n {
+		return nil, fmt.Errorf("takes %d arguments, got %d", n, len(args))
+	}
+
+	in := make([]reflect.Value, len(args))
+	for i, arg := range args {
+		var paramType reflect.Type
+		if t.IsVariadic() && i >= n-1 {
+			paramType = t.In(n - 1).Elem()
+		} else {
+			paramType = t.In(i)
+		}
+		if in[i], err = exprArg(arg, paramType); err != nil {
+			return nil, fmt.Errorf("argument %d: %v", i+1, err)
+		}
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+
+	out := fn.Call(in)
+	if len(out) > 0 && t.Out(len(out)-1) == reflect.TypeOf((*error)(nil)).Elem() {
+		if !out[len(out)-1].IsNil() {
+			return nil, out[len(out)-1].Interface().(error)
+		}
+		out = out[:len(out)-1]
+	}
+	if len(out) == 0 {
+		return nil, nil
+	}
+	return exprValue(out[0]), nil
+}
+
+// exprArg converts an argument to a parameter's type. Numbers are converted to the parameter's number type.
+func exprArg(arg interface{}, t reflect.Type) (reflect.Value, error) {
+	if arg == nil {
+		switch t.Kind() {
+		case reflect.Ptr, reflect.Interface, reflect.Map, reflect.Slice, reflect.Func, reflect.Chan:
+			return reflect.Zero(t), nil
+		}
+		return reflect.Value{}, fmt.Errorf("nil is not a %s", t)
+	}
+
+	v := reflect.ValueOf(arg)
+	if v.Type().AssignableTo(t) {
+		return v, nil
+	}
+	if isNumberKind(v.Kind()) && isNumberKind(t.Kind()) || v.Kind() == t.Kind() && v.Type().ConvertibleTo(t) {
+		return v.Convert(t), nil
+	}
+	return reflect.Value{}, fmt.Errorf("%s is not a %s", v.Type(), t)
+}
+
+func isNumberKind(k reflect.Kind) bool {
+	return reflect.Int <= k && k <= reflect.Float64
+}
+
+// exprNumber returns a number as a float and whether it is an integer.
+func exprNumber(val interface{}) (i int64, f float64, isInt, ok bool) {
+	switch v := val.(type) {
+	case int64:
+		return v, float64(v), true, true
+	case float64:
+		return 0, v, false, true
+	}
+	return 0, 0, false, false
+}
+
+// exprEqual compares numbers by value and other values like ==, or deeply if == panics on values which are not
+// comparable, e.g. a struct with an interface field holding a slice.
+func exprEqual(x, y interface{}) (equal bool) {
+	xi, xf, xInt, xOk := exprNumber(x)
+	yi, yf, yInt, yOk := exprNumber(y)
+	if xOk && yOk {
+		if xInt && yInt {
+			return xi == yi
+		}
+		return xf == yf
+	}
+	if x == nil || y == nil {
+		return x == nil && y == nil
+	}
+	if !reflect.TypeOf(x).Comparable() || !reflect.TypeOf(y).Comparable() {
+		return reflect.DeepEqual(x, y)
+	}
+
+	defer func() {
+		if recover() != nil {
+			equal = reflect.DeepEqual(x, y)
+		}
+	}()
+	return x == y
+}
+
+// exprCompare orders two numbers or two strings.
+func exprCompare(x, y interface{}) (int, error) {
+	xi, xf, xInt, xOk := exprNumber(x)
+	yi, yf, yInt, yOk := exprNumber(y)
+	switch {
+	case xOk && yOk && xInt && yInt:
+		switch {
+		case xi < yi:
+			return -1, nil
+		case xi > yi:
+			return 1, nil
+		}
+		return 0, nil
+	case xOk && yOk:
+		switch {
+		case xf < yf:
+			return -1, nil
+		case xf > yf:
+			return 1, nil
+		}
+		return 0, nil
+	}
+
+	xs, xOk := x.(string)
+	ys, yOk := y.(string)
+	if xOk && yOk {
+		return strings.Compare(xs, ys), nil
+	}
+	return 0, fmt.Errorf("can't compare %s and %s", exprType(x), exprType(y))
+}
+
+// exprIn reports whether a list, slice or array has an element equal to the value, a map has the value as key
+// or a string contains the value.
+func exprIn(x, y interface{}) (bool, error) {
+	if s, ok := y.(string); ok {
+		sub, ok := x.(string)
+		if !ok {
+			return false, fmt.Errorf("can't find %s in a string", exprType(x))
+		}
+		return strings.Contains(s, sub), nil
+	}
+
+	v := reflect.ValueOf(y)
+	switch v.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if exprEqual(x, exprValue(v.Index(i))) {
+				return true, nil
+			}
+		}
+		return false, nil
+	case reflect.Map:
+		for _, k := range v.MapKeys() {
+			if exprEqual(x, exprValue(k)) {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+	return false, fmt.Errorf("can't find a value in %s", exprType(y))
+}
+
+// exprArith computes the arithmetic operators on numbers, and + on strings.
+func exprArith(op string, x, y interface{}) (interface{}, error) {
+	if xs, ok := x.(string); ok && op == "+" {
+		if ys, ok := y.(string); ok {
+			return xs + ys, nil
+		}
+	}
+
+	xi, xf, xInt, xOk := exprNumber(x)
+	yi, yf, yInt, yOk := exprNumber(y)
+	if !xOk || !yOk {
+		return nil, fmt.Errorf("operands of %s are %s and %s, not numbers", op, exprType(x), exprType(y))
+	}
+
+	if xInt && yInt {
+		switch op {
+		case "+":
+			return xi + yi, nil
+		case "-":
+			return xi - yi, nil
+		case "*":
+			return xi * yi, nil
+		}
+		if yi == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+		if op == "/" {
+			return xi / yi, nil
+		}
+		return xi % yi, nil
+	}
+
+	switch op {
+	case "+":
+		return xf + yf, nil
+	case "-":
+		return xf - yf, nil
+	case "*":
+		return xf * yf, nil
+	case "/":
+		return xf / yf, nil
+	}
+	return nil, fmt.Errorf("operands of %% are not integers")
+}
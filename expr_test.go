@@ -0,0 +1,111 @@
+package roulette
+
+import (
+	"log"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExprEval(t *testing.T) {
+	funcs := map[string]interface{}{
+		"double": func(a int) int { return a * 2 },
+	}
+
+	vals := []interface{}{&T2{A: 20, B: 3}, Facts{"types.Person": map[string]interface{}{"Age": int64(30), "Name": "ana"}}}
+
+	tests := []struct {
+		expr string
+		val  interface{}
+	}{
+		{"T2.A == 20", true},
+		{"roulette.T2.A != 20", false},
+		{"T2.A <= 5 && T2.B in 1..3", false},
+		{"T2.A > 5 && T2.B in 1..3", true},
+		{"T2.A < 5 || not (T2.B in [1, 2])", true},
+		{"!(T2.A >= 20)", false},
+		{"T2.A + T2.B * 2", int64(26)},
+		{"(T2.A + T2.B) % 4", int64(3)},
+		{"T2.A / 8.0", 2.5},
+		{"-T2.B", int64(-3)},
+		{"Person.Age in 15..30 && Person.Name == 'ana'", true},
+		{`Person.Name + "!"`, "ana!"},
+		{`Person.Name in "banana"`, true},
+		{"Person.Missing == nil", true},
+		{"double(T2.B) == 6", true},
+		{"false && Person.Missing.Field", false},
+		{"T2.A == 20.0", true},
+		{"T2.SetA(7) && T2.A == 7", true},
+	}
+
+	for _, test := range tests {
+		scope := newExprScope([]string{"roulette.T2", "types.Person"}, "result", funcs)
+		node, err := parseExpr(test.expr, scope)
+		if err != nil {
+			log.Fatalf("%s: %v", test.expr, err)
+		}
+
+		val, err := node.eval(newExprEnv(vals, nil))
+		if err != nil || !reflect.DeepEqual(val, test.val) {
+			log.Fatalf("Expected %s to be %v, got %v %v", test.expr, test.val, val, err)
+		}
+	}
+}
+
+func TestExprErrors(t *testing.T) {
+	tests := []struct {
+		expr string
+		err  string
+	}{
+		{"T2.A >", "col 7: unexpected end of expression"},
+		{"T2.A == 'a", "string is not terminated"},
+		{"T2.A # 1", `unexpected '#'`},
+		{"Other.A == 1", "Other is not a filter type"},
+		{"T2.A == 1 1", `unexpected "1"`},
+		{"missing(1)", "function missing is not defined"},
+		{"T2.C == 1", "roulette.T2 has no field C"},
+		{"T2.A && true", "operand of && is int64, not a bool"},
+		{"T2.A < 'a'", "can't compare int64 and string"},
+		{"T2.A / 0", "division by zero"},
+		{"T2.Missing(1)", "has no method Missing"},
+		{"T2.SetA('a')", "argument 1: string is not a int"},
+		{"result.Put(1)", ErrNoResult.Error()},
+	}
+
+	for _, test := range tests {
+		scope := newExprScope([]string{"roulette.T2"}, "result", nil)
+		node, err := parseExpr(test.expr, scope)
+		if err == nil {
+			_, err = node.eval(newExprEnv(&T2{A: 1}, nil))
+		}
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			log.Fatalf("Expected an error containing %q for %s, got %v", test.err, test.expr, err)
+		}
+	}
+}
+
+type exprP10 struct{ V interface{} }
+type exprP11 struct{ S exprP10 }
+
+func TestExprEqualUncomparable(t *testing.T) {
+	scope := newExprScope([]string{"roulette.exprP11"}, "result", nil)
+	node, err := parseExpr("exprP11.S == exprP11.S", scope)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	val, err := node.eval(newExprEnv(&exprP11{S: exprP10{V: []int{1}}}, nil))
+	if err != nil || val != true {
+		log.Fatalf("Expected the struct holding a slice to equal itself, got %v %v", val, err)
+	}
+}
+
+func TestExprAmbiguousType(t *testing.T) {
+	scope := newExprScope([]string{"a.Person", "b.Person"}, "result", nil)
+	if _, err := parseExpr("Person.Age > 1", scope); err == nil || !strings.Contains(err.Error(), "ambiguous") {
+		log.Fatalf("Expected an ambiguous type error, got %v", err)
+	}
+	if _, err := parseExpr("a.Person.Age > 1", scope); err != nil {
+		log.Fatal(err)
+	}
+}
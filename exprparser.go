@@ -0,0 +1,391 @@
+package roulette
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"sort"
+	"strings"
+
+	"github.com/myntra/roulette/log"
+)
+
+// the syntaxes of the rule expressions of a rule file, set by the syntax attribute of the roulette element.
+const (
+	// SyntaxTemplate rules are text templates executed by the TextTemplateParser. It is the default.
+	SyntaxTemplate = "template"
+	// SyntaxExpr rules are infix boolean expressions executed by the ExprParser.
+	SyntaxExpr = "expr"
+)
+
+// ErrUnsupportedSyntax is returned by the rule tests, lint, explain and flows for the rules of the expr syntax.
+var ErrUnsupportedSyntax = errors.New("unsupported for the expr syntax")
+
+// exprRule is a rule of the expression syntax compiled to syntax trees.
+type exprRule struct {
+	when       exprNode
+	then, els  []exprNode
+	compileErr error
+}
+
+// ExprParser executes rulesets whose rules are infix boolean expressions, e.g.
+// Person.Vacations <= 5 && Person.Age in 15..30, evaluated by reflection on the input values. A filter type
+// is referred to by its package and type name or by its type name alone, and the result by the resultKey, e.g.
+// result.Put(Person). The rulesets are filtered by type, workflow and priority like the TextTemplateParser's.
+type ExprParser struct {
+	xml       XMLData
+	config    TextTemplateParserConfig
+	workflows *workflowIndex
+}
+
+// Execute executes the parser's rulesets and reports the outcome of every ruleset and rule.
+func (p ExprParser) Execute(vals interface{}) ExecutionReport {
+	return p.ExecuteContext(context.Background(), vals)
+}
+
+// ExecuteContext is like Execute but stops once the context is done. The rulesets are selected by the workflow
+// of the context, see WithWorkflow, or else by the WorkflowPattern config.
+func (p ExprParser) ExecuteContext(ctx context.Context, vals interface{}) ExecutionReport {
+	var workflowMatches []bool
+	if selection, ok := ctx.Value(workflowKey{}).(workflowSelection); ok {
+		workflowMatches = p.workflows.get(selection, p.xml.Rulesets, p.config.WorkflowMatcher)
+	}
+
+	report := ExecutionReport{Rulesets: make([]RulesetReport, len(p.xml.Rulesets)), Cycles: 1}
+	for i := range p.xml.Rulesets {
+		if err := ctx.Err(); err != nil {
+			report.Err = err
+			report.Rulesets[i] = RulesetReport{Name: p.xml.Rulesets[i].Name, Skipped: true, Err: err}
+			continue
+		}
+
+		ruleset := p.xml.Rulesets[i]
+		if workflowMatches != nil {
+			ruleset.config.workflowMatch = workflowMatches[i]
+		}
+
+		report.Rulesets[i] = executeExprRuleset(ctx, ruleset, vals)
+		if err := report.Rulesets[i].Err; err == context.Canceled || err == context.DeadlineExceeded {
+			report.Err = err
+		}
+		if report.Rulesets[i].Skipped {
+			log.Debugf("skipping ruleset %s: %v", report.Rulesets[i].Name, report.Rulesets[i].Err)
+		}
+	}
+	return report
+}
+
+// GetResult returns the parser's result.
+func (p ExprParser) GetResult() Result {
+	return p.config.Result
+}
+
+// executeExprRuleset executes the ruleset's rules in order of priority.
+func executeExprRuleset(ctx context.Context, t TextTemplateRuleset, vals interface{}) RulesetReport {
+	report := RulesetReport{Name: t.Name}
+
+	if !t.config.workflowMatch {
+		report.Skipped = true
+		report.Err = ErrWorkflowMismatch
+		return report
+	}
+
+	if !t.isValid(vals) {
+		report.Skipped = true
+		report.Err = ErrFilterTypes
+		return report
+	}
+
+	var bound *boundResult
+	env := newExprEnv(vals, nil)
+	if t.config.result != nil {
+		bound = newBoundResult(ctx, t.config.result, t.Name, vals)
+		env.result = bound
+	}
+
+	report.Rules = make([]RuleReport, len(t.Rules))
+	successCount := 0
+	firedGroups := map[string]bool{}
+
+	for i := range t.Rules {
+		rule := t.Rules[i]
+		ruleReport := &report.Rules[i]
+		ruleReport.Name = rule.Name
+		ruleReport.Priority = rule.Priority
+
+		if bound != nil {
+			bound.setRule(rule)
+		}
+
+		if err := ctx.Err(); err != nil {
+			report.Err = err
+			ruleReport.Err = err
+			continue
+		}
+
+		// n high priority rules successful, skip the rest
+		if successCount == t.limit {
+			ruleReport.Err = ErrPrioritiesCount
+			continue
+		}
+
+		if firedGroups[rule.ActivationGroup] {
+			ruleReport.Err = ErrActivationGroup
+			continue
+		}
+
+		if rule.config.noResultFunc {
+			ruleReport.Err = ErrNoResult
+			continue
+		}
+
+		if err := rule.isValid(vals); err != nil {
+			ruleReport.Err = err
+			continue
+		}
+
+		result, err := rule.config.expr.execute(env)
+		switch {
+		case err != nil:
+			ruleReport.Status = RuleErrored
+			ruleReport.Err = err
+		case result:
+			ruleReport.Status = RuleFired
+			successCount++
+			if rule.ActivationGroup != "" {
+				firedGroups[rule.ActivationGroup] = true
+			}
+		default:
+			ruleReport.Status = RuleFalse
+		}
+	}
+
+	return report
+}
+
+// execute evaluates the condition of the rule and its then or else block.
+func (r *exprRule) execute(env *exprEnv) (bool, error) {
+	if r.compileErr != nil {
+		return false, r.compileErr
+	}
+
+	val, err := r.when.eval(env)
+	if err != nil {
+		return false, err
+	}
+	result, ok := val.(bool)
+	if !ok {
+		return false, fmt.Errorf("rule expression is %s, not a bool", exprType(val))
+	}
+
+	block, name := r.then, thenTemplate
+	if !result {
+		block, name = r.els, elseTemplate
+	}
+	for _, node := range block {
+		if _, err := node.eval(env); err != nil {
+			return false, fmt.Errorf("%s: %v", name, err)
+		}
+	}
+	return result, nil
+}
+
+// unescapeExpr returns an expression of an xml rule as text: the entities are unescaped and the CDATA sections
+// are read as is. The expressions of every format are unescaped.
+func unescapeExpr(expr string) string {
+	var buf strings.Builder
+	for {
+		start := strings.Index(expr, "<![CDATA[")
+		if start < 0 {
+			buf.WriteString(html.UnescapeString(expr))
+			return buf.String()
+		}
+		buf.WriteString(html.UnescapeString(expr[:start]))
+		expr = expr[start+len("<![CDATA["):]
+
+		end := strings.Index(expr, "]]>")
+		if end < 0 {
+			end = len(expr)
+		}
+		buf.WriteString(expr[:end])
+		expr = strings.TrimPrefix(expr[end:], "]]>")
+	}
+}
+
+// compile compiles the rules of the rulesets. With the Strict config every invalid ruleset and rule is returned
+// as CompileErrors, otherwise an invalid rule is reported as errored when executed. The attributes of the
+// TextTemplateParser which are not supported by the expression syntax fail the compilation.
+func (p *ExprParser) compile() error {
+	var errs CompileErrors
+
+	if len(p.xml.Flows) > 0 {
+		return fmt.Errorf("flows are not supported by the %s syntax", SyntaxExpr)
+	}
+	if p.config.ForwardChaining {
+		return fmt.Errorf("forward chaining is not supported by the %s syntax", SyntaxExpr)
+	}
+
+	for i := range p.xml.Rulesets {
+		ruleset := &p.xml.Rulesets[i]
+
+		// invalid returns the error unless it is collected in strict mode
+		invalid := func(err error) error {
+			if !p.config.Strict {
+				return err
+			}
+			errs = append(errs, RuleError{Pos: ruleset.pos.String(), Ruleset: ruleset.Name, Err: err})
+			return nil
+		}
+
+		if ruleset.FilterTypes == "" {
+			if err := invalid(fmt.Errorf("Missing required attribute filterTypes")); err != nil {
+				return err
+			}
+		}
+
+		var unsupported []string
+		if ruleset.Rollout != "" {
+			unsupported = append(unsupported, "rollout")
+		}
+		if ruleset.Strategy != "" && ruleset.Strategy != StrategyFirst {
+			unsupported = append(unsupported, "strategy "+ruleset.Strategy)
+		}
+		if ruleset.TieBreak != "" && ruleset.TieBreak != TieBreakDeclaration {
+			unsupported = append(unsupported, "tieBreak "+ruleset.TieBreak)
+		}
+		if len(ruleset.Variants) > 0 {
+			unsupported = append(unsupported, "variants")
+		}
+		if ruleset.table != nil {
+			unsupported = append(unsupported, "decision tables")
+		}
+		if len(unsupported) > 0 {
+			err := fmt.Errorf("%s not supported by the %s syntax", strings.Join(unsupported, ", "), SyntaxExpr)
+			if err := invalid(err); err != nil {
+				return err
+			}
+		}
+
+		limit, err := prioritiesLimit(ruleset)
+		if err != nil {
+			// collected in strict mode only, a bad value is still executed as all
+			invalid(err)
+		}
+		ruleset.limit = limit
+
+		if ruleset.ResultKey == "" {
+			ruleset.ResultKey = "result"
+		}
+
+		ruleset.config = textTemplateRulesetConfig{
+			result:         p.config.Result,
+			filterTypesArr: splitFilterTypes(ruleset.FilterTypes),
+		}
+		ruleset.config.workflowMatch = ruleset.matchesWorkflow(p.config.WorkflowPattern, p.config.WorkflowMatcher)
+
+		for j := range ruleset.Rules {
+			rule := &ruleset.Rules[j]
+			if rule.Rollout != "" || rule.NoLoop {
+				if err := invalid(fmt.Errorf("rule %s: rollout and noLoop are not supported by the %s syntax",
+					rule.Name, SyntaxExpr)); err != nil {
+					return err
+				}
+			}
+
+			scope := newExprScope(ruleset.config.filterTypesArr, ruleset.ResultKey, p.config.Userfuncs)
+			compiled, err := compileExprRule(*rule, scope)
+			compiled.compileErr = err
+
+			rule.config = ruleConfig{expr: compiled}
+			rule.config.expectTypes = scope.expectTypes()
+			rule.config.expectTypesErr = fmt.Errorf("rule expression expected types %s", rule.config.expectTypes)
+			rule.config.noResultFunc = scope.refResult && p.config.Result == nil
+
+			pos := rule.pos
+			if pos.line == 0 {
+				pos = ruleset.pos
+			}
+			if err != nil && p.config.Strict {
+				errs = append(errs, RuleError{Pos: pos.String(), Ruleset: ruleset.Name, Rule: rule.Name, Err: err})
+			}
+		}
+
+		// rules with the same priority stay in order of declaration
+		sort.Stable(p.xml.Rulesets[i])
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+
+	return nil
+}
+
+// compileExprRule parses the expression or the when, then and else blocks of a rule.
+func compileExprRule(rule Rule, scope *exprScope) (*exprRule, error) {
+	compiled := &exprRule{}
+
+	var err error
+	compiled.when, err = parseExpr(unescapeExpr(rule.condition()), scope)
+	if err != nil {
+		return compiled, err
+	}
+
+	if rule.When == "" {
+		return compiled, nil
+	}
+	if compiled.then, err = parseExprList(unescapeExpr(rule.Then), scope); err != nil {
+		return compiled, fmt.Errorf("%s: %v", thenTemplate, err)
+	}
+	if compiled.els, err = parseExprList(unescapeExpr(rule.Else), scope); err != nil {
+		return compiled, fmt.Errorf("%s: %v", elseTemplate, err)
+	}
+	return compiled, nil
+}
+
+func newExprParser(xmldata XMLData, config TextTemplateParserConfig) (Parser, error) {
+	parser := ExprParser{
+		xml:       xmldata,
+		config:    config,
+		workflows: newWorkflowIndex(),
+	}
+
+	err := parser.compile()
+	if err != nil {
+		return nil, err
+	}
+
+	log.Init(config.LogLevel, config.LogPath)
+
+	return parser, nil
+}
+
+// NewExprParser returns a parser of xml rules of the expression syntax, whether or not the roulette element
+// sets the syntax attribute. The Userfuncs of the config are the functions the expressions can call; the
+// delimiters, types, coverage and conflict resolvers are of the text template syntax.
+func NewExprParser(data []byte, config ...TextTemplateParserConfig) (Parser, error) {
+	cfg := TextTemplateParserConfig{}
+	if len(config) > 0 {
+		cfg = config[0]
+	}
+
+	cfg, err := defaultConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	loader := newDataLoader(cfg)
+	loader.syntax = SyntaxExpr
+	err = loader.loadData("", ".", FormatXML, data)
+	if err != nil {
+		return nil, err
+	}
+
+	xmldata, err := loader.result()
+	if err != nil {
+		return nil, err
+	}
+
+	return newExprParser(xmldata, cfg)
+}
@@ -0,0 +1,166 @@
+package roulette
+
+import (
+	"context"
+	"log"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestExprParser(t *testing.T) {
+	var results []interface{}
+	config := TextTemplateParserConfig{
+		Result: NewResultCallback(func(val interface{}) {
+			results = append(results, val)
+		}),
+	}
+
+	parser, err := NewParser(readFile("testrules/rules_expr.xml"), config)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if _, ok := parser.(ExprParser); !ok {
+		log.Fatalf("Expected an ExprParser for the expr syntax, got %T", parser)
+	}
+
+	tests := []struct {
+		input   T2
+		results []interface{}
+		a       int
+	}{
+		{T2{A: 70, B: 1}, []interface{}{"senior"}, 70},
+		{T2{A: 30, B: 1}, []interface{}{"adult"}, 18},
+		{T2{A: 10, B: 2}, []interface{}{"child"}, 10},
+		{T2{A: 10, B: 3}, []interface{}{"unknown"}, 10},
+	}
+
+	for _, test := range tests {
+		results = nil
+		input := test.input
+		report := parser.Execute(&input)
+		if !reflect.DeepEqual(results, test.results) || input.A != test.a {
+			log.Fatalf("Expected %v and A %d for %v, got %v and %d\n%v", test.results, test.a, test.input, results,
+				input.A, report)
+		}
+	}
+
+	// prioritiesCount is 1, the adult rule fired
+	results = nil
+	report := parser.Execute(&T2{A: 30})
+	ages, _ := report.Ruleset("ages")
+	if ages.Rules[1].Status != RuleFired || ages.Rules[2].Err != ErrPrioritiesCount {
+		log.Fatalf("Expected the child rule to be skipped after the adult rule fired, got %v", report)
+	}
+
+	// the pair rule refers to a value which is not an input
+	pairs, _ := report.Ruleset("pairs")
+	if pairs.Rules[0].Status != RuleErrored || !strings.Contains(pairs.Rules[0].Err.Error(), "no value of type roulette.T") {
+		log.Fatalf("Expected the pair rule to error without a roulette.T value, got %v", report)
+	}
+
+	results = nil
+	parser.Execute([]interface{}{&T2{A: 70, B: 4}, &T{B: 4}})
+	if !reflect.DeepEqual(results, []interface{}{"senior", "pair"}) {
+		log.Fatalf("Expected the senior and pair results, got %v", results)
+	}
+}
+
+func TestNewExprParser(t *testing.T) {
+	data := `<roulette>
+    <ruleset name="ages" filterTypes="roulette.T2">
+        <rule name="adult" priority="1">T2.A &gt;= 18 &amp;&amp; T2.SetA(1)</rule>
+        <rule name="invalid" priority="2">T2.A &gt;=</rule>
+    </ruleset>
+</roulette>`
+
+	parser, err := NewExprParser([]byte(data))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	input := T2{A: 20}
+	report := parser.Execute(&input)
+	ages, _ := report.Ruleset("ages")
+	if input.A != 1 || ages.Rules[0].Status != RuleFired || ages.Rules[1].Status != RuleErrored {
+		log.Fatalf("Expected the adult rule to fire and the invalid rule to error, got %v and A %d", report, input.A)
+	}
+
+	_, err = NewExprParser([]byte(data), TextTemplateParserConfig{Strict: true})
+	if errs, ok := err.(CompileErrors); !ok || len(errs) != 1 || errs[0].Rule != "invalid" {
+		log.Fatalf("Expected the invalid rule in the compile errors, got %v", err)
+	}
+
+	_, err = NewExprParser([]byte(`<roulette><ruleset name="ages" filterTypes="roulette.T2" rollout="10">
+        <rule name="adult" priority="1">T2.A &gt;= 18</rule></ruleset></roulette>`))
+	if err == nil || !strings.Contains(err.Error(), "rollout not supported by the expr syntax") {
+		log.Fatalf("Expected rollout to be unsupported, got %v", err)
+	}
+}
+
+func TestExprSyntaxPerFile(t *testing.T) {
+	fsys := fstest.MapFS{
+		"rules.xml":    {Data: []byte(`<roulette syntax="expr"><include src="ages.xml"/></roulette>`)},
+		"ages.xml":     {Data: readFile("testrules/rules_expr.xml")},
+		"template.xml": {Data: []byte(`<roulette><include src="ages.xml"/></roulette>`)},
+	}
+
+	parser, err := NewTextTemplateParserFS(fsys, []string{"rules.xml"}, TextTemplateParserConfig{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	if _, ok := parser.(ExprParser); !ok {
+		log.Fatalf("Expected an ExprParser, got %T", parser)
+	}
+
+	_, err = NewTextTemplateParserFS(fsys, []string{"template.xml"}, TextTemplateParserConfig{})
+	if err == nil || !strings.Contains(err.Error(), `syntax "expr" differs from the syntax "template" of template.xml`) {
+		log.Fatalf("Expected a syntax error, got %v", err)
+	}
+}
+
+func TestExprUnsupported(t *testing.T) {
+	parser, err := NewExprParser(readFile("testrules/rules_expr.xml"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if _, err := RunRuleTests(parser); err != ErrUnsupportedSyntax {
+		log.Fatalf("Expected rule tests to be unsupported, got %v", err)
+	}
+	if _, err := Lint(parser); err != ErrUnsupportedSyntax {
+		log.Fatalf("Expected lint to be unsupported, got %v", err)
+	}
+	if _, err := Explain(parser, &T2{}); err != ErrUnsupportedSyntax {
+		log.Fatalf("Expected explain to be unsupported, got %v", err)
+	}
+	if report := ExecuteFlow(context.Background(), parser, "flow", &T2{}); report.Err != ErrUnsupportedSyntax {
+		log.Fatalf("Expected flows to be unsupported, got %v", report.Err)
+	}
+}
+
+func TestConvertExprRules(t *testing.T) {
+	data, err := ConvertRules(readFile("testrules/rules_expr.xml"), FormatXML, FormatJSON)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	data, err = ConvertRules(data, FormatJSON, FormatXML)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var results []interface{}
+	parser, err := NewParser(data, TextTemplateParserConfig{Result: NewResultCallback(func(val interface{}) {
+		results = append(results, val)
+	})})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	parser.Execute(&T2{A: 70})
+	if !reflect.DeepEqual(results, []interface{}{"senior"}) {
+		log.Fatalf("Expected the senior result after converting the rules, got %v\n%s", results, data)
+	}
+}
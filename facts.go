@@ -0,0 +1,56 @@
+package roulette
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Facts are values without a go type, keyed by the type names used in filterTypes. A rule refers to them like
+// values of the type, e.g. the fact {"types.Person": {"Age": 30}} is .types.Person.Age. Facts can be executed
+// alone or with other values.
+type Facts map[string]interface{}
+
+// ParseFacts parses facts from a json object keyed by type name. Whole numbers are parsed as int64 so that
+// they can be compared with the numbers of the rules.
+func ParseFacts(data []byte) (Facts, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	facts := Facts{}
+	err := dec.Decode(&facts)
+	if err != nil {
+		return nil, err
+	}
+
+	for typeName, fact := range facts {
+		if !strings.Contains(typeName, ".") {
+			return nil, fmt.Errorf("fact %s is not keyed by package and type name", typeName)
+		}
+		facts[typeName] = factNumbers(fact)
+	}
+
+	return facts, nil
+}
+
+// factNumbers replaces the json numbers of the value.
+func factNumbers(val interface{}) interface{} {
+	switch v := val.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		f, _ := v.Float64()
+		return f
+	case map[string]interface{}:
+		for k, e := range v {
+			v[k] = factNumbers(e)
+		}
+	case []interface{}:
+		for i, e := range v {
+			v[i] = factNumbers(e)
+		}
+	}
+	return val
+}
@@ -0,0 +1,58 @@
+package roulette
+
+import (
+	"log"
+	"reflect"
+	"testing"
+)
+
+func TestParseFacts(t *testing.T) {
+	facts, err := ParseFacts([]byte(`{"types.Person": {"Name": "a", "Age": 30, "Score": 4.5, "Tags": [1, "b"]}}`))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	expected := Facts{"types.Person": map[string]interface{}{
+		"Name": "a", "Age": int64(30), "Score": 4.5, "Tags": []interface{}{int64(1), "b"},
+	}}
+	if !reflect.DeepEqual(facts, expected) {
+		log.Fatalf("Expected %v, got %v", expected, facts)
+	}
+
+	_, err = ParseFacts([]byte(`{"Person": {}}`))
+	if err == nil {
+		log.Fatal("Expected an error for a fact without a package")
+	}
+}
+
+func TestExecuteFacts(t *testing.T) {
+	var results []interface{}
+	parser, err := NewYAMLParser(readFile("testrules/rules_facts.yaml"), TextTemplateParserConfig{
+		Result: NewResultCallback(func(val interface{}) {
+			results = append(results, val)
+		}),
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	facts, err := ParseFacts([]byte(`{"types.Person": {"Name": "a", "Age": 65}}`))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// alone or with typed values
+	for _, vals := range []interface{}{facts, []interface{}{&T2{}, facts}} {
+		results = nil
+		report := parser.Execute(vals)
+		ruleset, _ := report.Ruleset("personRules")
+		if len(ruleset.Fired()) != 2 || !reflect.DeepEqual(results, []interface{}{"a"}) {
+			log.Fatalf("Expected both rules to fire and put a, got %v %v", report, results)
+		}
+	}
+
+	report := parser.Execute(Facts{"types.Company": map[string]interface{}{}})
+	if ruleset, _ := report.Ruleset("personRules"); !ruleset.Skipped {
+		log.Fatalf("Expected the ruleset to be skipped, got %v", report)
+	}
+}
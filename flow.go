@@ -0,0 +1,316 @@
+package roulette
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	// ErrFlow is reported by Execute for the rulesets executed by a flow.
+	ErrFlow = errors.New("ruleset is executed by a flow")
+	// ErrFlowNoEdge is reported when no edge of a flow node matched the outcome of its ruleset.
+	ErrFlowNoEdge = errors.New("no edge of the flow node matched")
+)
+
+// Flow is a <flow> element of a rule file, a directed acyclic graph of rulesets. A node executes its ruleset and
+// follows the first of its edges matching the outcome, until a node without edges returns its result. Start
+// defaults to the first node. The rulesets of the nodes are only executed by the flow, whatever their workflow.
+type Flow struct {
+	Name  string     `xml:"name,attr" json:"name" yaml:"name"`
+	Start string     `xml:"start,attr,omitempty" json:"start,omitempty" yaml:"start,omitempty"`
+	Nodes []FlowNode `xml:"node" json:"nodes" yaml:"nodes"`
+
+	pos sourcePos
+}
+
+// FlowNode executes its ruleset, if any, and follows its edges. A node without edges is terminal.
+type FlowNode struct {
+	Name    string     `xml:"name,attr" json:"name" yaml:"name"`
+	Ruleset string     `xml:"ruleset,attr,omitempty" json:"ruleset,omitempty" yaml:"ruleset,omitempty"`
+	Result  string     `xml:"result,attr,omitempty" json:"result,omitempty" yaml:"result,omitempty"`
+	Edges   []FlowEdge `xml:"edge" json:"edges,omitempty" yaml:"edges,omitempty"`
+}
+
+// FlowEdge leads to a node when all of its conditions hold: the rule named by fired fired, and the value set
+// with .R.Set under the value key is set or, with equals, prints as equals. An edge without conditions always
+// matches.
+type FlowEdge struct {
+	To     string `xml:"to,attr" json:"to" yaml:"to"`
+	Fired  string `xml:"fired,attr,omitempty" json:"fired,omitempty" yaml:"fired,omitempty"`
+	Value  string `xml:"value,attr,omitempty" json:"value,omitempty" yaml:"value,omitempty"`
+	Equals string `xml:"equals,attr,omitempty" json:"equals,omitempty" yaml:"equals,omitempty"`
+}
+
+// matches reports whether the edge is followed after the ruleset's outcome.
+func (e FlowEdge) matches(report RulesetReport) bool {
+	if e.Fired != "" {
+		fired := false
+		for _, name := range report.Fired() {
+			if name == e.Fired {
+				fired = true
+				break
+			}
+		}
+		if !fired {
+			return false
+		}
+	}
+
+	if e.Value != "" {
+		val, ok := report.Values[e.Value]
+		if !ok || val == nil {
+			return false
+		}
+		if e.Equals != "" && fmt.Sprint(val) != e.Equals {
+			return false
+		}
+	}
+
+	return true
+}
+
+// FlowReport is the outcome of executing a flow: the nodes visited in order and the reports of their rulesets.
+type FlowReport struct {
+	Name     string
+	Path     []string // the names of the visited nodes
+	Rulesets []RulesetReport
+	Result   string // the result of the terminal node
+	Err      error  // why the flow stopped before a terminal node
+}
+
+func (r FlowReport) String() string {
+	if r.Err != nil {
+		return fmt.Sprintf("flow %s: %s: %v", r.Name, strings.Join(r.Path, " -> "), r.Err)
+	}
+	return fmt.Sprintf("flow %s: %s: %s", r.Name, strings.Join(r.Path, " -> "), r.Result)
+}
+
+// compiledFlow indexes the rulesets of the nodes and the targets of the edges.
+type compiledFlow struct {
+	flow     *Flow
+	start    int
+	rulesets []int   // of the nodes, -1 without a ruleset
+	edges    [][]int // the nodes the edges of the nodes lead to
+}
+
+// compileFlows validates the flows and marks the rulesets of their nodes. With the Strict config it returns
+// CompileErrors listing every invalid flow.
+func (p *TextTemplateParser) compileFlows() error {
+	rulesets := make(map[string]int, len(p.xml.Rulesets))
+	for i, ruleset := range p.xml.Rulesets {
+		rulesets[ruleset.Name] = i
+	}
+
+	var errs CompileErrors
+	p.flows = make(map[string]*compiledFlow, len(p.xml.Flows))
+	for i := range p.xml.Flows {
+		flow := &p.xml.Flows[i]
+		name := flow.Name
+		if name == "" {
+			// unnamed flows are reported by their position in the file
+			name = fmt.Sprintf("#%d", i+1)
+		}
+
+		invalid := func(format string, args ...interface{}) error {
+			err := RuleError{Pos: flow.pos.String(), Flow: name, Err: fmt.Errorf(format, args...)}
+			if !p.config.Strict {
+				return err
+			}
+			errs = append(errs, err)
+			return nil
+		}
+
+		if flow.Name == "" {
+			if err := invalid("missing required attribute name"); err != nil {
+				return err
+			}
+		} else if _, ok := p.flows[flow.Name]; ok {
+			if err := invalid("duplicate flow name"); err != nil {
+				return err
+			}
+		}
+		if len(flow.Nodes) == 0 {
+			if err := invalid("has no nodes"); err != nil {
+				return err
+			}
+			continue
+		}
+
+		nodes := make(map[string]int, len(flow.Nodes))
+		for j, node := range flow.Nodes {
+			if node.Name == "" {
+				if err := invalid("node %d: missing required attribute name", j+1); err != nil {
+					return err
+				}
+				continue
+			}
+			if _, ok := nodes[node.Name]; ok {
+				if err := invalid("duplicate node name %s", node.Name); err != nil {
+					return err
+				}
+				continue
+			}
+			nodes[node.Name] = j
+		}
+
+		compiled := &compiledFlow{flow: flow, rulesets: make([]int, len(flow.Nodes)), edges: make([][]int, len(flow.Nodes))}
+		if flow.Start != "" {
+			start, ok := nodes[flow.Start]
+			if !ok {
+				if err := invalid("start node %s is not defined", flow.Start); err != nil {
+					return err
+				}
+			}
+			compiled.start = start
+		}
+
+		for j, node := range flow.Nodes {
+			compiled.rulesets[j] = -1
+			if node.Ruleset != "" {
+				ruleset, ok := rulesets[node.Ruleset]
+				if !ok {
+					if err := invalid("node %s: ruleset %s is not defined", node.Name, node.Ruleset); err != nil {
+						return err
+					}
+				} else {
+					compiled.rulesets[j] = ruleset
+					p.xml.Rulesets[ruleset].config.flow = true
+				}
+			}
+
+			for _, edge := range node.Edges {
+				to, ok := nodes[edge.To]
+				if !ok {
+					if err := invalid("node %s: edge to %q: node is not defined", node.Name, edge.To); err != nil {
+						return err
+					}
+					continue
+				}
+				if edge.Equals != "" && edge.Value == "" {
+					if err := invalid("node %s: edge to %s: equals needs a value", node.Name, edge.To); err != nil {
+						return err
+					}
+				}
+				compiled.edges[j] = append(compiled.edges[j], to)
+			}
+		}
+
+		if cycle := compiled.cycle(); cycle != nil {
+			if err := invalid("cycle %s", strings.Join(cycle, " -> ")); err != nil {
+				return err
+			}
+		}
+
+		if _, ok := p.flows[flow.Name]; !ok && flow.Name != "" {
+			p.flows[flow.Name] = compiled
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// cycle returns the names of the nodes of a cycle reachable from the start node, nil if there is none.
+func (f *compiledFlow) cycle() []string {
+	const (
+		visiting = iota + 1
+		visited
+	)
+
+	state := make([]int, len(f.flow.Nodes))
+	var path []string
+	var visit func(node int) []string
+	visit = func(node int) []string {
+		path = append(path, f.flow.Nodes[node].Name)
+		switch state[node] {
+		case visiting:
+			for i, name := range path[:len(path)-1] {
+				if name == f.flow.Nodes[node].Name {
+					return path[i:]
+				}
+			}
+		case visited:
+			path = path[:len(path)-1]
+			return nil
+		}
+
+		state[node] = visiting
+		for _, to := range f.edges[node] {
+			if cycle := visit(to); cycle != nil {
+				return cycle
+			}
+		}
+		state[node] = visited
+		path = path[:len(path)-1]
+		return nil
+	}
+	return visit(f.start)
+}
+
+// executeFlow executes the rulesets of the flow's nodes from the start node to a terminal node.
+func (p TextTemplateParser) executeFlow(ctx context.Context, name string, vals interface{}) FlowReport {
+	report := FlowReport{Name: name}
+	flow, ok := p.flows[name]
+	if !ok {
+		report.Err = fmt.Errorf("flow %s is not defined", name)
+		return report
+	}
+
+	node := flow.start
+	for {
+		report.Path = append(report.Path, flow.flow.Nodes[node].Name)
+		if err := ctx.Err(); err != nil {
+			report.Err = err
+			return report
+		}
+
+		var rulesetReport RulesetReport
+		if i := flow.rulesets[node]; i >= 0 {
+			ruleset := p.xml.Rulesets[i]
+			ruleset.config.workflowMatch = true
+
+			rulesetReport, _ = ruleset.execute(ctx, vals, false, nil)
+			if cov := ruleset.coverage; cov != nil {
+				cov.record(p.config.Coverage, rulesetReport)
+			}
+			report.Rulesets = append(report.Rulesets, rulesetReport)
+		}
+
+		if len(flow.edges[node]) == 0 {
+			report.Result = flow.flow.Nodes[node].Result
+			return report
+		}
+
+		next := -1
+		for j, edge := range flow.flow.Nodes[node].Edges {
+			if edge.matches(rulesetReport) {
+				next = flow.edges[node][j]
+				break
+			}
+		}
+		if next < 0 {
+			report.Err = ErrFlowNoEdge
+			return report
+		}
+		node = next
+	}
+}
+
+// ExecuteFlow executes the named flow of the parser's rules with the values. It is supported by the
+// TextTemplateParser and the parsers returned by the reloading constructors, the rules of the expr syntax
+// report ErrUnsupportedSyntax.
+func ExecuteFlow(ctx context.Context, parser Parser, name string, vals interface{}) FlowReport {
+	switch p := parser.(type) {
+	case TextTemplateParser:
+		return p.executeFlow(ctx, name, vals)
+	case *ReloadingParser:
+		return ExecuteFlow(ctx, p.Parser(), name, vals)
+	case ExprParser:
+		return FlowReport{Name: name, Err: ErrUnsupportedSyntax}
+	}
+	return FlowReport{Name: name, Err: fmt.Errorf("parser %T does not execute flows", parser)}
+}
@@ -0,0 +1,164 @@
+package roulette
+
+import (
+	"context"
+	"log"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFlow(t *testing.T) {
+	var results []interface{}
+	config := TextTemplateParserConfig{
+		Result: NewResultCallback(func(val interface{}) {
+			results = append(results, val)
+		}),
+	}
+
+	parser, err := NewTextTemplateParserFiles([]string{"testrules/rules_flow.xml"}, config)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	tests := []struct {
+		input   T2
+		path    []string
+		result  string
+		results []interface{}
+	}{
+		{T2{A: 150, B: 1}, []string{"classify", "express"}, "express", []interface{}{"express"}},
+		{T2{A: 150, B: 2}, []string{"classify", "bulk"}, "bulk", []interface{}{"bulk"}},
+		{T2{A: 10, B: 2}, []string{"classify", "standard"}, "standard", nil},
+	}
+
+	for _, test := range tests {
+		results = nil
+		input := test.input
+		report := ExecuteFlow(context.Background(), parser, "routing", &input)
+		if report.Err != nil {
+			log.Fatal(report)
+		}
+		if !reflect.DeepEqual(report.Path, test.path) || report.Result != test.result || !reflect.DeepEqual(results, test.results) {
+			log.Fatalf("Expected path %v, result %s and results %v for %v, got %v and %v", test.path, test.result,
+				test.results, test.input, report, results)
+		}
+	}
+
+	// the rulesets of the flow are skipped by Execute
+	results = nil
+	report := parser.Execute(&T2{A: 150, B: 1})
+	for _, name := range []string{"classifyOrder", "expressRouting", "bulkRouting"} {
+		if ruleset, _ := report.Ruleset(name); ruleset.Err != ErrFlow {
+			log.Fatalf("Expected ruleset %s to be skipped with %v, got %v", name, ErrFlow, ruleset.Err)
+		}
+	}
+	if !reflect.DeepEqual(results, []interface{}{"audit"}) {
+		log.Fatalf("Expected only the audit result, got %v", results)
+	}
+
+	if report := ExecuteFlow(context.Background(), parser, "unknown", &T2{}); report.Err == nil {
+		log.Fatal("Expected an error for an unknown flow")
+	}
+}
+
+func TestFlowValues(t *testing.T) {
+	parser, err := NewTextTemplateParserFiles([]string{"testrules/rules_flow.xml"}, TextTemplateParserConfig{})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	report := ExecuteFlow(context.Background(), parser, "routing", &T2{A: 150, B: 2})
+	if len(report.Rulesets) != 2 || report.Rulesets[0].Values["size"] != "large" {
+		log.Fatalf("Expected the size value in the classifyOrder report, got %v", report.Rulesets)
+	}
+}
+
+func TestFlowNoEdge(t *testing.T) {
+	data := `<roulette>
+    <ruleset name="classify" dataKey="TestData" filterTypes="roulette.T2">
+        <rule name="priority" priority="1"><r>with .TestData</r><r>eq .roulette.T2.B 1</r><r>end</r></rule>
+    </ruleset>
+    <flow name="routing">
+        <node name="classify" ruleset="classify"><edge to="express" fired="priority"/></node>
+        <node name="express" result="express"/>
+    </flow>
+</roulette>`
+
+	parser, err := NewParser([]byte(data))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	report := ExecuteFlow(context.Background(), parser, "routing", &T2{B: 2})
+	if report.Err != ErrFlowNoEdge || !reflect.DeepEqual(report.Path, []string{"classify"}) {
+		log.Fatalf("Expected %v at classify, got %v", ErrFlowNoEdge, report)
+	}
+}
+
+func TestFlowInvalid(t *testing.T) {
+	ruleset := `<ruleset name="classify" dataKey="TestData" filterTypes="roulette.T2">
+        <rule name="priority" priority="1"><r>true</r></rule>
+    </ruleset>`
+
+	tests := []struct {
+		flow string
+		err  string
+	}{
+		{`<flow name="f"><node name="a" ruleset="missing"/></flow>`, "ruleset missing is not defined"},
+		{`<flow name="f" start="b"><node name="a"/></flow>`, "start node b is not defined"},
+		{`<flow name="f"><node name="a"><edge to="b"/></node></flow>`, `edge to "b"`},
+		{`<flow name="f"><node name="a"><edge to="a" equals="x"/></node></flow>`, "equals needs a value"},
+		{`<flow name="f"><node name="a"><edge to="b"/></node><node name="b"><edge to="a"/></node></flow>`, "cycle a -> b -> a"},
+		{`<flow name="f"><node name="a"/></flow><flow name="f"><node name="a"/></flow>`, "duplicate flow name"},
+	}
+
+	for _, test := range tests {
+		_, err := NewParser([]byte("<roulette>" + ruleset + test.flow + "</roulette>"))
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			log.Fatalf("Expected an error containing %q for %s, got %v", test.err, test.flow, err)
+		}
+	}
+
+	data := []byte(`<roulette>
+    <ruleset name="invalid" filterTypes="roulette.T2"><rule name="r" priority="1"><r>true</r></rule></ruleset>
+    <flow name="f" start="b"><node name="a" ruleset="missing"/></flow>
+    <flow><node name="a"><edge to="a"/></node></flow>
+</roulette>`)
+
+	_, err := NewParser(data, TextTemplateParserConfig{Strict: true})
+	errs, ok := err.(CompileErrors)
+	if !ok {
+		log.Fatalf("Expected CompileErrors, got %v", err)
+	}
+
+	expected := []string{
+		"line 2: ruleset invalid: Missing required attribute dataKey",
+		"line 3: flow f: start node b is not defined",
+		"line 3: flow f: node a: ruleset missing is not defined",
+		"line 4: flow #2: missing required attribute name",
+		"line 4: flow #2: cycle a -> a",
+	}
+
+	if len(errs) != len(expected) {
+		log.Fatalf("Expected %d errors, got %v", len(expected), err)
+	}
+
+	for i := range expected {
+		if errs[i].Error() != expected[i] {
+			log.Fatalf("Expected error %q, got %q", expected[i], errs[i].Error())
+		}
+	}
+
+	// a flow error is listed with the type errors, which are collected without the Strict config
+	data = []byte(`<roulette>
+    <ruleset name="typed" dataKey="TestData" filterTypes="roulette.T3"><rule name="r" priority="1"><r>true</r></rule></ruleset>
+    <flow name="f"><node name="a" ruleset="missing"/></flow>
+</roulette>`)
+
+	_, err = NewParser(data, TextTemplateParserConfig{Types: NewTypeRegistry(T2{})})
+	errs, ok = err.(CompileErrors)
+	if !ok || len(errs) != 2 || errs[1].Error() != "line 3: flow f: node a: ruleset missing is not defined" {
+		log.Fatalf("Expected the type and flow errors, got %v", err)
+	}
+}
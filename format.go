@@ -0,0 +1,319 @@
+package roulette
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// RuleFormat is the encoding of a rule file.
+type RuleFormat string
+
+const (
+	// FormatXML is the roulette xml format.
+	FormatXML RuleFormat = "xml"
+	// FormatJSON is the json format. Its keys are the names of the xml attributes.
+	FormatJSON RuleFormat = "json"
+	// FormatYAML is the yaml format. Its keys are the names of the xml attributes.
+	FormatYAML RuleFormat = "yaml"
+)
+
+// ruleFileExts are the extensions of the rule files in each format.
+var ruleFileExts = map[string]RuleFormat{
+	".xml":  FormatXML,
+	".json": FormatJSON,
+	".yaml": FormatYAML,
+	".yml":  FormatYAML,
+}
+
+// formatOf returns the format of the rule file from its extension. The default is xml.
+func formatOf(name string) RuleFormat {
+	if format, ok := ruleFileExts[strings.ToLower(filepath.Ext(name))]; ok {
+		return format
+	}
+	return FormatXML
+}
+
+// decodeRules decodes a rule document.
+func decodeRules(data []byte, format RuleFormat) (XMLData, error) {
+	xmldata := XMLData{}
+
+	var err error
+	switch format {
+	case FormatXML:
+		err = xml.Unmarshal(data, &xmldata)
+		if err == nil {
+			err = decodeXMLBlocks(xmldata)
+		}
+	case FormatJSON:
+		err = json.Unmarshal(data, &xmldata)
+	case FormatYAML:
+		err = yaml.Unmarshal(data, &xmldata)
+	default:
+		err = fmt.Errorf("unknown rule format %q", format)
+	}
+
+	return xmldata, err
+}
+
+// xmlBlocks are the <when>, <then> and <else> elements of a structured rule.
+type xmlBlocks struct {
+	When  *xmlBlock  `xml:"when"`
+	Then  *xmlBlock  `xml:"then"`
+	Else  *xmlBlock  `xml:"else"`
+	Other []xml.Name `xml:",any"`
+	Text  string     `xml:",chardata"`
+}
+
+type xmlBlock struct {
+	Expr string `xml:",innerxml"`
+}
+
+// decodeXMLBlocks moves the when, then and else elements of the rules from their expressions to their blocks.
+func decodeXMLBlocks(xmldata XMLData) error {
+	for i := range xmldata.Rulesets {
+		ruleset := &xmldata.Rulesets[i]
+		rules := []*Rule{}
+		for j := range ruleset.Rules {
+			rules = append(rules, &ruleset.Rules[j])
+		}
+		for j := range ruleset.Variants {
+			for k := range ruleset.Variants[j].Rules {
+				rules = append(rules, &ruleset.Variants[j].Rules[k])
+			}
+		}
+
+		for _, rule := range rules {
+			if !strings.Contains(rule.Expr, "<when") && !strings.Contains(rule.Expr, "<then") &&
+				!strings.Contains(rule.Expr, "<else") {
+				continue
+			}
+
+			blocks := xmlBlocks{}
+			if err := xml.Unmarshal([]byte("<rule>"+rule.Expr+"</rule>"), &blocks); err != nil {
+				return err
+			}
+			if len(blocks.Other) > 0 || strings.TrimSpace(blocks.Text) != "" {
+				return fmt.Errorf("rule %s in ruleset %s mixes an expression with when, then or else blocks",
+					rule.Name, ruleset.Name)
+			}
+
+			rule.Expr = ""
+			if blocks.When != nil {
+				rule.When = blocks.When.Expr
+			}
+			if blocks.Then != nil {
+				rule.Then = blocks.Then.Expr
+			}
+			if blocks.Else != nil {
+				rule.Else = blocks.Else.Expr
+			}
+		}
+	}
+	return nil
+}
+
+// xmlRuleFile is the xml encoding of XMLData. Empty attributes are omitted.
+type xmlRuleFile struct {
+	XMLName  xml.Name        `xml:"roulette"`
+	Syntax   string          `xml:"syntax,attr,omitempty"`
+	Includes []Include       `xml:"include"`
+	Rulesets []xmlRuleset    `xml:"ruleset"`
+	Tables   []DecisionTable `xml:"decisiontable"`
+	Flows    []Flow          `xml:"flow"`
+}
+
+type xmlRuleset struct {
+	Name            string       `xml:"name,attr"`
+	FilterTypes     string       `xml:"filterTypes,attr"`
+	FilterStrict    bool         `xml:"filterStrict,attr,omitempty"`
+	DataKey         string       `xml:"dataKey,attr"`
+	ResultKey       string       `xml:"resultKey,attr,omitempty"`
+	PrioritiesCount string       `xml:"prioritiesCount,attr,omitempty"`
+	Workflow        string       `xml:"workflow,attr,omitempty"`
+	Rollout         string       `xml:"rollout,attr,omitempty"`
+	BucketKey       string       `xml:"bucketKey,attr,omitempty"`
+	Strategy        string       `xml:"strategy,attr,omitempty"`
+	Resolver        string       `xml:"resolver,attr,omitempty"`
+	TieBreak        string       `xml:"tieBreak,attr,omitempty"`
+	Rules           []xmlRule    `xml:"rule"`
+	Variants        []xmlVariant `xml:"variant"`
+	Tests           []RuleTest   `xml:"test"`
+}
+
+type xmlVariant struct {
+	Name   string    `xml:"name,attr"`
+	Weight float64   `xml:"weight,attr,omitempty"`
+	Rules  []xmlRule `xml:"rule"`
+}
+
+type xmlRule struct {
+	Name            string `xml:"name,attr"`
+	Priority        int    `xml:"priority,attr"`
+	ActivationGroup string `xml:"activationGroup,attr,omitempty"`
+	NoLoop          bool   `xml:"noLoop,attr,omitempty"`
+	Rollout         string `xml:"rollout,attr,omitempty"`
+	BucketKey       string `xml:"bucketKey,attr,omitempty"`
+	Expr            string `xml:",innerxml"`
+}
+
+// encodeRules encodes a rule document.
+func encodeRules(xmldata XMLData, format RuleFormat) ([]byte, error) {
+	switch format {
+	case FormatXML:
+		return encodeXMLRules(xmldata)
+	case FormatJSON:
+		data, err := json.MarshalIndent(xmldata, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		return append(data, '\n'), nil
+	case FormatYAML:
+		return yaml.Marshal(xmldata)
+	}
+
+	return nil, fmt.Errorf("unknown rule format %q", format)
+}
+
+func encodeXMLRules(xmldata XMLData) ([]byte, error) {
+	file := xmlRuleFile{Syntax: xmldata.Syntax, Includes: xmldata.Includes, Tables: xmldata.DecisionTables, Flows: xmldata.Flows}
+	for _, ruleset := range xmldata.Rulesets {
+		r := xmlRuleset{
+			Name:            ruleset.Name,
+			FilterTypes:     ruleset.FilterTypes,
+			FilterStrict:    ruleset.FilterStrict,
+			DataKey:         ruleset.DataKey,
+			ResultKey:       ruleset.ResultKey,
+			PrioritiesCount: ruleset.PrioritiesCount,
+			Workflow:        ruleset.Workflow,
+			Rollout:         ruleset.Rollout,
+			BucketKey:       ruleset.BucketKey,
+			Strategy:        ruleset.Strategy,
+			Resolver:        ruleset.Resolver,
+			TieBreak:        ruleset.TieBreak,
+			Tests:           ruleset.Tests,
+		}
+
+		var err error
+		r.Rules, err = encodeXMLRuleList(ruleset.Name, ruleset.Rules, xmldata.Syntax == SyntaxExpr)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, variant := range ruleset.Variants {
+			v := xmlVariant{Name: variant.Name, Weight: variant.Weight}
+			v.Rules, err = encodeXMLRuleList(ruleset.Name, variant.Rules, xmldata.Syntax == SyntaxExpr)
+			if err != nil {
+				return nil, err
+			}
+			r.Variants = append(r.Variants, v)
+		}
+
+		file.Rulesets = append(file.Rulesets, r)
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	enc := xml.NewEncoder(&buf)
+	enc.Indent("", "    ")
+	err := enc.Encode(file)
+	if err != nil {
+		return nil, err
+	}
+	buf.WriteByte('\n')
+
+	return buf.Bytes(), nil
+}
+
+// encodeXMLRuleList encodes the rules of a ruleset. The expressions of the expression syntax are escaped.
+func encodeXMLRuleList(ruleset string, rules []Rule, escape bool) ([]xmlRule, error) {
+	var xmlRules []xmlRule
+	for _, rule := range rules {
+		if escape {
+			rule.Expr, rule.When, rule.Then, rule.Else = escapeExpr(rule.Expr), escapeExpr(rule.When),
+				escapeExpr(rule.Then), escapeExpr(rule.Else)
+		}
+
+		expr := rule.Expr
+		if rule.When != "" || rule.Then != "" || rule.Else != "" {
+			expr += "<when>" + rule.When + "</when>"
+			if rule.Then != "" {
+				expr += "<then>" + rule.Then + "</then>"
+			}
+			if rule.Else != "" {
+				expr += "<else>" + rule.Else + "</else>"
+			}
+		}
+
+		// the expression is written as is, it must be valid xml content to be read back
+		err := xml.Unmarshal([]byte("<rule>"+expr+"</rule>"), new(struct{}))
+		if err != nil {
+			return nil, fmt.Errorf("expression of rule %s in ruleset %s is not valid xml: %v", rule.Name, ruleset, err)
+		}
+
+		xmlRules = append(xmlRules, xmlRule{Name: rule.Name, Priority: rule.Priority, NoLoop: rule.NoLoop,
+			ActivationGroup: rule.ActivationGroup, Rollout: rule.Rollout, BucketKey: rule.BucketKey, Expr: expr})
+	}
+	return xmlRules, nil
+}
+
+// escapeExpr escapes an expression of the expression syntax as xml text.
+func escapeExpr(expr string) string {
+	var buf bytes.Buffer
+	xml.EscapeText(&buf, []byte(unescapeExpr(expr)))
+	return buf.String()
+}
+
+// ConvertRules converts a rule document between the xml, json and yaml formats. Includes and decision tables are
+// kept as they are, their files are not converted.
+func ConvertRules(data []byte, from, to RuleFormat) ([]byte, error) {
+	xmldata, err := decodeRules(data, from)
+	if err != nil {
+		return nil, err
+	}
+
+	return encodeRules(xmldata, to)
+}
+
+// newFormatParser returns a parser of the rule document in the format.
+func newFormatParser(data []byte, format RuleFormat, config []TextTemplateParserConfig) (Parser, error) {
+	cfg := TextTemplateParserConfig{}
+	if len(config) > 0 {
+		cfg = config[0]
+	}
+
+	cfg, err := defaultConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	loader := newDataLoader(cfg)
+	err = loader.loadData("", ".", format, data)
+	if err != nil {
+		return nil, err
+	}
+
+	xmldata, err := loader.result()
+	if err != nil {
+		return nil, err
+	}
+
+	return newTextTemplateParser(xmldata, cfg)
+}
+
+// NewJSONParser returns a TextTemplateParser of json rules. The keys are the names of the xml attributes:
+//
+//	{"rulesets": [{"name": "...", "filterTypes": "...", "dataKey": "...", "rules": [{"name": "...", "priority": 1, "expr": "..."}]}]}
+func NewJSONParser(data []byte, config ...TextTemplateParserConfig) (Parser, error) {
+	return newFormatParser(data, FormatJSON, config)
+}
+
+// NewYAMLParser returns a TextTemplateParser of yaml rules. The keys are the names of the xml attributes.
+func NewYAMLParser(data []byte, config ...TextTemplateParserConfig) (Parser, error) {
+	return newFormatParser(data, FormatYAML, config)
+}
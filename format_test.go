@@ -0,0 +1,122 @@
+package roulette
+
+import (
+	"log"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestJSONYAMLParser(t *testing.T) {
+	for _, file := range []string{"testrules/rules_format.json", "testrules/rules_format.yaml"} {
+		var results []interface{}
+		config := TextTemplateParserConfig{
+			Result: NewResultCallback(func(val interface{}) {
+				results = append(results, val)
+			}),
+		}
+
+		newParser := NewJSONParser
+		if strings.HasSuffix(file, ".yaml") {
+			newParser = NewYAMLParser
+		}
+
+		parser, err := newParser(readFile(file), config)
+		if err != nil {
+			log.Fatal(file, err)
+		}
+
+		t2 := &T2{A: 1, B: 2}
+		report := parser.Execute(t2)
+
+		ruleset, ok := report.Ruleset("formatRules")
+		if !ok || !reflect.DeepEqual(ruleset.Fired(), []string{"setA"}) {
+			log.Fatalf("%s: expected only setA to fire, got %v", file, report)
+		}
+
+		if t2.A != 5 || len(results) != 1 {
+			log.Fatalf("%s: expected A=5 and 1 result, got A=%d results=%v", file, t2.A, results)
+		}
+	}
+}
+
+func TestParserFilesFormats(t *testing.T) {
+	// the format of a file is decided by its extension
+	_, err := NewTextTemplateParserFiles([]string{"testrules/rules_format.json", "testrules/rules_format.yaml"}, TextTemplateParserConfig{})
+	if err == nil || !strings.Contains(err.Error(), `duplicate ruleset name "formatRules"`) {
+		log.Fatalf("Expected a duplicate ruleset error, got %v", err)
+	}
+}
+
+// compiledRulesets returns the compiled rulesets of the parser without the pools and templates.
+func compiledRulesets(parser Parser) []TextTemplateRuleset {
+	var rulesets []TextTemplateRuleset
+	for _, ruleset := range parser.(TextTemplateParser).xml.Rulesets {
+		r := TextTemplateRuleset{
+			Name:            ruleset.Name,
+			FilterTypes:     ruleset.FilterTypes,
+			FilterStrict:    ruleset.FilterStrict,
+			DataKey:         ruleset.DataKey,
+			ResultKey:       ruleset.ResultKey,
+			PrioritiesCount: ruleset.PrioritiesCount,
+			Workflow:        ruleset.Workflow,
+			config:          ruleset.config,
+			limit:           ruleset.limit,
+		}
+		for _, rule := range ruleset.Rules {
+			r.Rules = append(r.Rules, Rule{Name: rule.Name, Priority: rule.Priority, Expr: rule.Expr})
+		}
+		rulesets = append(rulesets, r)
+	}
+	return rulesets
+}
+
+func TestConvertRules(t *testing.T) {
+	files := []string{
+		"testrules/rules_simple.xml",
+		"testrules/rules_priorities.xml",
+		"testrules/rules_workflows.xml",
+		"testrules/rules_callback.xml",
+	}
+
+	for _, file := range files {
+		data := readFile(file)
+		parser, err := NewParser(data)
+		if err != nil {
+			log.Fatal(file, err)
+		}
+		expected := compiledRulesets(parser)
+
+		for _, format := range []RuleFormat{FormatJSON, FormatYAML, FormatXML} {
+			converted, err := ConvertRules(data, FormatXML, format)
+			if err != nil {
+				log.Fatalf("%s to %s: %v", file, format, err)
+			}
+
+			// convert back through json to check the formats round trip
+			converted, err = ConvertRules(converted, format, FormatJSON)
+			if err != nil {
+				log.Fatalf("%s from %s: %v", file, format, err)
+			}
+
+			parser, err := NewJSONParser(converted)
+			if err != nil {
+				log.Fatalf("%s as %s: %v", file, format, err)
+			}
+
+			if got := compiledRulesets(parser); !reflect.DeepEqual(got, expected) {
+				log.Fatalf("%s as %s: expected compiled rulesets %+v, got %+v", file, format, expected, got)
+			}
+		}
+	}
+
+	_, err := ConvertRules([]byte(`{"rulesets": [{"name": "r", "rules": [{"name": "lt", "expr": "<r>1 < 2</r>"}]}]}`), FormatJSON, FormatXML)
+	if err == nil {
+		log.Fatal("Expected an error converting an expression which is not valid xml")
+	}
+
+	_, err = ConvertRules(readFile("testrules/rules_simple.xml"), FormatXML, "toml")
+	if err == nil {
+		log.Fatal("Expected an error for an unknown format")
+	}
+}
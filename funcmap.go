@@ -391,7 +391,7 @@ func validateFuncs(funcMap template.FuncMap) error {
 		}
 		v := reflect.ValueOf(fn)
 		if v.Kind() != reflect.Func {
-			return fmt.Errorf("value for " + name + " not a function")
+			return fmt.Errorf("value for %s not a function", name)
 		}
 		if !goodFunc(v.Type()) {
 			return fmt.Errorf("can't install method/function %q with %d results", name, v.Type().NumOut())
@@ -479,4 +479,6 @@ var defaultFuncMap = template.FuncMap{
 	"ceil":  ceil,
 	"floor": floor,
 	"round": round,
+	// Rollouts
+	"bucket": bucket,
 }
@@ -0,0 +1,157 @@
+package roulette
+
+import (
+	"fmt"
+	"strings"
+	"text/template/parse"
+)
+
+// LintWarning is a suspicious ruleset or rule found by Lint. The rules are valid but probably not what was meant.
+type LintWarning struct {
+	Pos     string // file:line of the ruleset or rule. lines are known for xml rules
+	Ruleset string
+	Rule    string // empty for a warning about the ruleset
+	Message string
+}
+
+func (w LintWarning) String() string {
+	return RuleError{Pos: w.Pos, Ruleset: w.Ruleset, Rule: w.Rule, Err: fmt.Errorf("%s", w.Message)}.Error()
+}
+
+// Lint returns warnings for the parser's rulesets which are never executed, rules which refer to none of the
+// filterTypes and rules which neither put a result, set a value with .R.Set nor call a setter. Rules which share a priority are executed
+// in order of declaration and are not warned about. Only a TextTemplateParser or a ReloadingParser can be linted, the rules of the
+// expr syntax return ErrUnsupportedSyntax.
+func Lint(parser Parser) ([]LintWarning, error) {
+	switch p := parser.(type) {
+	case TextTemplateParser:
+		return p.lint(), nil
+	case *ReloadingParser:
+		return Lint(p.Parser())
+	case ExprParser:
+		return nil, ErrUnsupportedSyntax
+	}
+	return nil, fmt.Errorf("parser %T cannot be linted", parser)
+}
+
+func (p TextTemplateParser) lint() []LintWarning {
+	var warnings []LintWarning
+
+	for _, ruleset := range p.xml.Rulesets {
+		warn := func(rule *Rule, format string, args ...interface{}) {
+			w := LintWarning{Pos: ruleset.pos.String(), Ruleset: ruleset.Name, Message: fmt.Sprintf(format, args...)}
+			if rule != nil {
+				w.Rule = rule.Name
+				if rule.pos.line > 0 {
+					w.Pos = rule.pos.String()
+				}
+			}
+			warnings = append(warnings, w)
+		}
+
+		if len(ruleset.Rules) == 0 {
+			warn(nil, "ruleset has no rules")
+		}
+
+		if !ruleset.config.workflowMatch {
+			warn(nil, "ruleset is never executed, workflow %s does not match the pattern %s", ruleset.Workflow, p.config.WorkflowPattern)
+		}
+
+		for i := range ruleset.Rules {
+			rule := &ruleset.Rules[i]
+			if len(rule.config.expectTypes) == 0 {
+				warn(rule, "rule refers to none of the filterTypes %s", ruleset.FilterTypes)
+			}
+
+			if rule.config.template != nil && !hasEffect(rule, ruleset.ResultKey) {
+				warn(rule, "rule neither calls .%s.Put, .R.Set nor a setter", ruleset.ResultKey)
+			}
+		}
+	}
+
+	return warnings
+}
+
+// hasEffect returns true if the rule's template puts a result, sets a value with .R.Set, e.g. for the edges of
+// flows, or calls a method named Set*.
+func hasEffect(rule *Rule, resultKey string) bool {
+	effect := false
+	for _, tmpl := range rule.config.template.Templates() {
+		if tmpl.Tree == nil {
+			continue
+		}
+
+		walkNodes(tmpl.Tree.Root, func(node parse.Node) {
+			var idents []string
+			switch n := node.(type) {
+			case *parse.FieldNode:
+				idents = n.Ident
+			case *parse.VariableNode:
+				idents = n.Ident
+			case *parse.ChainNode:
+				idents = n.Field
+			default:
+				return
+			}
+
+			last := len(idents) - 1
+			switch {
+			case last >= 1 && idents[last] == "Put" && idents[last-1] == resultKey:
+				effect = true
+			case last >= 0 && strings.HasPrefix(idents[last], "Set"):
+				effect = true
+			}
+		})
+	}
+	return effect
+}
+
+// walkNodes calls fn for every node of the tree.
+func walkNodes(node parse.Node, fn func(parse.Node)) {
+	if node == nil {
+		return
+	}
+
+	fn(node)
+
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, node := range n.Nodes {
+			walkNodes(node, fn)
+		}
+	case *parse.ActionNode:
+		walkNodes(n.Pipe, fn)
+	case *parse.PipeNode:
+		if n == nil {
+			return
+		}
+		for _, cmd := range n.Cmds {
+			walkNodes(cmd, fn)
+		}
+	case *parse.CommandNode:
+		for _, arg := range n.Args {
+			walkNodes(arg, fn)
+		}
+	case *parse.ChainNode:
+		walkNodes(n.Node, fn)
+	case *parse.IfNode:
+		walkBranch(&n.BranchNode, fn)
+	case *parse.WithNode:
+		walkBranch(&n.BranchNode, fn)
+	case *parse.RangeNode:
+		walkBranch(&n.BranchNode, fn)
+	case *parse.TemplateNode:
+		walkNodes(n.Pipe, fn)
+	}
+}
+
+func walkBranch(n *parse.BranchNode, fn func(parse.Node)) {
+	walkNodes(n.Pipe, fn)
+	walkNodes(n.List, fn)
+	if n.ElseList != nil {
+		walkNodes(n.ElseList, fn)
+	}
+}
@@ -0,0 +1,68 @@
+package roulette
+
+import (
+	"log"
+	"reflect"
+	"testing"
+)
+
+func TestLint(t *testing.T) {
+	parser, err := NewTextTemplateParserFiles([]string{"testrules/rules_facts.yaml"}, TextTemplateParserConfig{})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	lint, err := Lint(parser)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var warnings []string
+	for _, warning := range lint {
+		warnings = append(warnings, warning.String())
+	}
+
+	expected := []string{
+		"testrules/rules_facts.yaml: ruleset personRules rule senior: rule neither calls .result.Put, .R.Set nor a setter",
+		"testrules/rules_facts.yaml: ruleset empty: ruleset has no rules",
+	}
+	if !reflect.DeepEqual(warnings, expected) {
+		log.Fatalf("Expected warnings %q, got %q", expected, warnings)
+	}
+
+	parser, err = NewParser(readFile("testrules/rules_priorities.xml"), TextTemplateParserConfig{WorkflowPattern: "ruleset1"})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	lint, err = Lint(parser)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	warnings = nil
+	for _, warning := range lint {
+		warnings = append(warnings, warning.String())
+	}
+
+	expected = []string{"line 22: ruleset ruleset2: ruleset is never executed, workflow ruleset2 does not match the pattern ruleset1"}
+	if !reflect.DeepEqual(warnings, expected) {
+		log.Fatalf("Expected warnings %q, got %q", expected, warnings)
+	}
+
+	// the values set with .R.Set are read by the edges of flows
+	parser, err = NewTextTemplateParserFiles([]string{"testrules/rules_flow.xml"}, TextTemplateParserConfig{})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	lint, err = Lint(parser)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, warning := range lint {
+		if warning.Rule == "size" {
+			log.Fatalf("Expected no warning for a rule setting a value with .R.Set, got %v", warning)
+		}
+	}
+}
@@ -0,0 +1,432 @@
+package roulette
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Include is an <include src="..."/> element of a rule file. src is relative to the including file and must
+// stay within the directory of the file passed to the parser.
+type Include struct {
+	Src string `xml:"src,attr" json:"src" yaml:"src"`
+}
+
+// sourcePos is the position of a ruleset or a rule in its rule file. line is 0 when not known.
+type sourcePos struct {
+	file string
+	line int
+}
+
+func (p sourcePos) String() string {
+	switch {
+	case p.file != "" && p.line > 0:
+		return fmt.Sprintf("%s:%d", p.file, p.line)
+	case p.line > 0:
+		return fmt.Sprintf("line %d", p.line)
+	}
+	return p.file
+}
+
+// rulesetLines are the lines of a ruleset element and of its rule, test and variant elements.
+type rulesetLines struct {
+	line     int
+	rules    []int
+	tests    []int
+	variants []rulesetLines
+}
+
+// xmlLines returns the lines of every ruleset and flow element of the xml document.
+func xmlLines(data []byte) (lines []rulesetLines, flows []int) {
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	depth := 0
+	inRuleset := false
+	inVariant := false
+	for {
+		token, err := dec.Token()
+		if err != nil {
+			return lines, flows
+		}
+
+		switch el := token.(type) {
+		case xml.StartElement:
+			depth++
+			if depth < 2 || depth > 4 {
+				continue
+			}
+
+			// the start element ends at the offset, its line is the line of its '<'
+			offset := bytes.LastIndexByte(data[:dec.InputOffset()], '<')
+			line := bytes.Count(data[:offset], []byte("\n")) + 1
+
+			if depth == 2 {
+				inRuleset = el.Name.Local == "ruleset"
+				if inRuleset {
+					lines = append(lines, rulesetLines{line: line})
+				}
+				if el.Name.Local == "flow" {
+					flows = append(flows, line)
+				}
+				continue
+			}
+
+			if !inRuleset {
+				continue
+			}
+
+			ruleset := &lines[len(lines)-1]
+			if depth == 4 {
+				if inVariant && el.Name.Local == "rule" {
+					variant := &ruleset.variants[len(ruleset.variants)-1]
+					variant.rules = append(variant.rules, line)
+				}
+				continue
+			}
+
+			inVariant = el.Name.Local == "variant"
+			switch el.Name.Local {
+			case "variant":
+				ruleset.variants = append(ruleset.variants, rulesetLines{line: line})
+			case "rule":
+				ruleset.rules = append(ruleset.rules, line)
+			case "test":
+				ruleset.tests = append(ruleset.tests, line)
+			}
+		case xml.EndElement:
+			depth--
+		}
+	}
+}
+
+// ruleFiles reads rule files from a file system.
+type ruleFiles interface {
+	ReadFile(name string) ([]byte, error)
+	Glob(pattern string) ([]string, error)
+	Join(dir, name string) string
+	Dir(name string) string
+	Within(dir, name string) bool
+}
+
+// osFiles reads rule files from the operating system's file system.
+type osFiles struct{}
+
+func (osFiles) ReadFile(name string) ([]byte, error)  { return os.ReadFile(name) }
+func (osFiles) Glob(pattern string) ([]string, error) { return filepath.Glob(pattern) }
+func (osFiles) Dir(name string) string                { return filepath.Dir(name) }
+func (osFiles) Join(dir, name string) string          { return filepath.Join(dir, name) }
+func (osFiles) Within(dir, name string) bool {
+	rel, err := filepath.Rel(dir, name)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// fsFiles reads rule files from an fs.FS.
+type fsFiles struct {
+	fsys fs.FS
+}
+
+func (f fsFiles) ReadFile(name string) ([]byte, error)  { return fs.ReadFile(f.fsys, name) }
+func (f fsFiles) Glob(pattern string) ([]string, error) { return fs.Glob(f.fsys, pattern) }
+func (f fsFiles) Dir(name string) string                { return path.Dir(name) }
+func (f fsFiles) Join(dir, name string) string          { return path.Join(dir, name) }
+func (f fsFiles) Within(dir, name string) bool {
+	return fs.ValidPath(name) && (dir == "." || name == dir || strings.HasPrefix(name, dir+"/"))
+}
+
+// ruleLoader merges the rulesets of rule files and the files they include. Rule data is loaded without files
+// unless the Files config is set.
+type ruleLoader struct {
+	files   ruleFiles
+	base    string            // the directory of the loaded file, its includes stay within it
+	loaded  map[string]bool   // files already merged
+	loading map[string]bool   // files being merged, to detect include cycles
+	sources map[string]string // ruleset name to the file which defined it
+	order   []string          // merged files and decision table sources in order
+	data    XMLData
+	errs    []string
+	syntax  string // of the files without a syntax attribute
+	merged  string // the first merged file, its syntax is the syntax of the others
+}
+
+func newRuleLoader(files ruleFiles) *ruleLoader {
+	return &ruleLoader{
+		files:   files,
+		loaded:  make(map[string]bool),
+		loading: make(map[string]bool),
+		sources: make(map[string]string),
+	}
+}
+
+// loadGlob merges the files matching the patterns.
+func (l *ruleLoader) loadGlob(patterns ...string) error {
+	var names []string
+	for _, pattern := range patterns {
+		matches, err := l.files.Glob(pattern)
+		if err != nil {
+			return err
+		}
+
+		if len(matches) == 0 {
+			return fmt.Errorf("no rule files match %s", pattern)
+		}
+
+		sort.Strings(matches)
+		names = append(names, matches...)
+	}
+
+	for _, name := range names {
+		err := l.loadRoot(name)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// loadRoot merges a file passed to the parser, its includes are within its directory.
+func (l *ruleLoader) loadRoot(name string) error {
+	l.base = l.files.Dir(name)
+	return l.loadFile(name)
+}
+
+// loadFile merges the file and the files it includes. A file is merged once.
+func (l *ruleLoader) loadFile(name string) error {
+	if l.loading[name] {
+		return fmt.Errorf("include cycle at %s", name)
+	}
+
+	if l.loaded[name] {
+		return nil
+	}
+
+	data, err := l.files.ReadFile(name)
+	if err != nil {
+		return err
+	}
+
+	l.loading[name] = true
+	defer delete(l.loading, name)
+
+	err = l.loadData(name, l.files.Dir(name), formatOf(name), data)
+	if err != nil {
+		return err
+	}
+
+	l.loaded[name] = true
+	l.order = append(l.order, name)
+	return nil
+}
+
+// loadData merges the rule document in the format. Its includes are relative to dir.
+func (l *ruleLoader) loadData(name, dir string, format RuleFormat, data []byte) error {
+	xmldata, err := decodeRules(data, format)
+	if err != nil {
+		if name == "" {
+			return err
+		}
+		return fmt.Errorf("%s: %v", name, err)
+	}
+
+	source := name
+	if source == "" {
+		source = "rule data"
+	}
+
+	// the merged files have the same syntax
+	syntax := xmldata.Syntax
+	if syntax == "" {
+		syntax = l.syntax
+	}
+	if syntax == SyntaxTemplate {
+		syntax = ""
+	}
+	if l.merged == "" {
+		l.data.Syntax, l.merged = syntax, source
+	} else if syntax != l.data.Syntax {
+		return fmt.Errorf("%s: syntax %q differs from the syntax %q of %s", source, syntaxName(syntax),
+			syntaxName(l.data.Syntax), l.merged)
+	}
+
+	// line numbers are known for xml documents
+	var lines []rulesetLines
+	var flowLines []int
+	if format == FormatXML {
+		lines, flowLines = xmlLines(data)
+	}
+
+	for i, ruleset := range xmldata.Rulesets {
+		ruleset.pos = sourcePos{file: name}
+		for j := range ruleset.Rules {
+			ruleset.Rules[j].pos = sourcePos{file: name}
+		}
+		for j := range ruleset.Tests {
+			ruleset.Tests[j].pos = sourcePos{file: name}
+		}
+		for j := range ruleset.Variants {
+			ruleset.Variants[j].pos = sourcePos{file: name}
+			for k := range ruleset.Variants[j].Rules {
+				ruleset.Variants[j].Rules[k].pos = sourcePos{file: name}
+			}
+		}
+
+		if i < len(lines) {
+			ruleset.pos.line = lines[i].line
+			for j := 0; j < len(ruleset.Rules) && j < len(lines[i].rules); j++ {
+				ruleset.Rules[j].pos.line = lines[i].rules[j]
+			}
+			for j := 0; j < len(ruleset.Tests) && j < len(lines[i].tests); j++ {
+				ruleset.Tests[j].pos.line = lines[i].tests[j]
+			}
+			for j := 0; j < len(ruleset.Variants) && j < len(lines[i].variants); j++ {
+				variant := &ruleset.Variants[j]
+				variant.pos.line = lines[i].variants[j].line
+				for k := 0; k < len(variant.Rules) && k < len(lines[i].variants[j].rules); k++ {
+					variant.Rules[k].pos.line = lines[i].variants[j].rules[k]
+				}
+			}
+		}
+
+		if previous, ok := l.sources[ruleset.Name]; ok {
+			l.errs = append(l.errs, fmt.Sprintf("duplicate ruleset name %q in %s and %s", ruleset.Name, previous, source))
+			continue
+		}
+
+		l.sources[ruleset.Name] = source
+		l.data.Rulesets = append(l.data.Rulesets, ruleset)
+	}
+
+	for _, table := range xmldata.DecisionTables {
+		if table.Src == "" {
+			return fmt.Errorf("%s: missing required attribute src of decisiontable %s", source, table.Name)
+		}
+
+		src, err := l.resolve(source, dir, table.Src)
+		if err != nil {
+			return err
+		}
+		data, err := l.files.ReadFile(src)
+		if err != nil {
+			return err
+		}
+		l.order = append(l.order, src)
+
+		ruleset, err := table.ruleset(src, data)
+		if err != nil {
+			return fmt.Errorf("decisiontable %s: %v", table.Name, err)
+		}
+
+		if previous, ok := l.sources[ruleset.Name]; ok {
+			l.errs = append(l.errs, fmt.Sprintf("duplicate ruleset name %q in %s and %s", ruleset.Name, previous, source))
+			continue
+		}
+
+		l.sources[ruleset.Name] = source
+		l.data.Rulesets = append(l.data.Rulesets, ruleset)
+	}
+
+	for i, flow := range xmldata.Flows {
+		flow.pos = sourcePos{file: name}
+		if i < len(flowLines) {
+			flow.pos.line = flowLines[i]
+		}
+		l.data.Flows = append(l.data.Flows, flow)
+	}
+
+	for _, include := range xmldata.Includes {
+		if include.Src == "" {
+			return fmt.Errorf("%s: missing required attribute src of include", source)
+		}
+
+		src, err := l.resolve(source, dir, include.Src)
+		if err != nil {
+			return err
+		}
+		err = l.loadFile(src)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// resolve returns the file of an include or a decision table of the source. It is relative to dir and within
+// the base directory.
+func (l *ruleLoader) resolve(source, dir, src string) (string, error) {
+	if l.files == nil {
+		return "", fmt.Errorf("%s: cannot read %s, rule data reads files with the Files config only", source, src)
+	}
+	if path.IsAbs(src) || filepath.IsAbs(src) {
+		return "", fmt.Errorf("%s: %s is not a relative path", source, src)
+	}
+
+	name := l.files.Join(dir, src)
+	if !l.files.Within(l.base, name) {
+		return "", fmt.Errorf("%s: %s is outside of %s", source, src, l.base)
+	}
+	return name, nil
+}
+
+// newDataLoader returns a loader of rule data which reads the included files from the Files config.
+func newDataLoader(config TextTemplateParserConfig) *ruleLoader {
+	loader := newRuleLoader(nil)
+	if config.Files != nil {
+		loader.files = fsFiles{fsys: config.Files}
+	}
+	loader.base = "."
+	return loader
+}
+
+// syntaxName returns the name of a syntax, the default one if empty.
+func syntaxName(syntax string) string {
+	if syntax == "" {
+		return SyntaxTemplate
+	}
+	return syntax
+}
+
+// result returns the merged rulesets or an error listing the duplicate rulesets.
+func (l *ruleLoader) result() (XMLData, error) {
+	if len(l.errs) > 0 {
+		return XMLData{}, errors.New(strings.Join(l.errs, "\n"))
+	}
+	return l.data, nil
+}
+
+// NewTextTemplateParserFiles returns a parser of the rulesets merged from the files matching the glob patterns
+// and the files they include.
+func NewTextTemplateParserFiles(patterns []string, config TextTemplateParserConfig) (Parser, error) {
+	return newTextTemplateParserFiles(osFiles{}, patterns, config)
+}
+
+// NewTextTemplateParserFS is like NewTextTemplateParserFiles but reads the files from fsys.
+func NewTextTemplateParserFS(fsys fs.FS, patterns []string, config TextTemplateParserConfig) (Parser, error) {
+	return newTextTemplateParserFiles(fsFiles{fsys: fsys}, patterns, config)
+}
+
+func newTextTemplateParserFiles(files ruleFiles, patterns []string, config TextTemplateParserConfig) (Parser, error) {
+	config, err := defaultConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	loader := newRuleLoader(files)
+	err = loader.loadGlob(patterns...)
+	if err != nil {
+		return nil, err
+	}
+
+	xmldata, err := loader.result()
+	if err != nil {
+		return nil, err
+	}
+
+	return newTextTemplateParser(xmldata, config)
+}
@@ -0,0 +1,139 @@
+package roulette
+
+import (
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestParserFilesInclude(t *testing.T) {
+	var results []interface{}
+	config := TextTemplateParserConfig{
+		Result: NewResultCallback(func(val interface{}) {
+			results = append(results, val)
+		}),
+	}
+
+	parser, err := NewTextTemplateParserFiles([]string{"testrules/include/main.xml"}, config)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	t2 := &T2{A: 1, B: 2}
+	report := NewSimpleExecutor(parser).Execute(t2)
+
+	if len(report.Rulesets) != 3 {
+		log.Fatalf("Expected 3 rulesets, got %d", len(report.Rulesets))
+	}
+
+	for _, name := range []string{"mainRules", "pricingRules", "sharedRules"} {
+		if _, ok := report.Ruleset(name); !ok {
+			log.Fatalf("Expected ruleset %s to be merged", name)
+		}
+	}
+
+	if t2.A != 5 || len(results) != 2 {
+		log.Fatalf("Expected all the rulesets to execute, got A=%d results=%v", t2.A, results)
+	}
+}
+
+func TestParserFilesGlob(t *testing.T) {
+	// shared.xml is matched by the glob and included by pricing.xml, it is merged once.
+	parser, err := NewTextTemplateParserFiles([]string{"testrules/include/main.xml", "testrules/include/teams/shared.xml"}, TextTemplateParserConfig{})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	report := parser.Execute(&T2{A: 1, B: 2})
+	if len(report.Rulesets) != 3 {
+		log.Fatalf("Expected 3 rulesets, got %d", len(report.Rulesets))
+	}
+
+	_, err = NewTextTemplateParserFiles([]string{"testrules/include/teams/*.xml"}, TextTemplateParserConfig{})
+	if err == nil || !strings.Contains(err.Error(), `duplicate ruleset name "pricingRules"`) {
+		log.Fatalf("Expected a duplicate ruleset error, got %v", err)
+	}
+
+	_, err = NewTextTemplateParserFiles([]string{"testrules/include/missing*.xml"}, TextTemplateParserConfig{})
+	if err == nil {
+		log.Fatal("Expected an error when no files match")
+	}
+
+	_, err = NewTextTemplateParserFiles([]string{"testrules/include/cycle.xml"}, TextTemplateParserConfig{})
+	if err == nil || !strings.Contains(err.Error(), "include cycle") {
+		log.Fatalf("Expected an include cycle error, got %v", err)
+	}
+}
+
+func TestParserFS(t *testing.T) {
+	parser, err := NewTextTemplateParserFS(os.DirFS("testrules/include"), []string{"main.xml"}, TextTemplateParserConfig{})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	report := parser.Execute(&T2{A: 1, B: 2})
+	if len(report.Rulesets) != 3 {
+		log.Fatalf("Expected 3 rulesets, got %d", len(report.Rulesets))
+	}
+
+	fsys := fstest.MapFS{
+		"a.xml": {Data: readFile("testrules/rules_priorities.xml")},
+		"b.xml": {Data: readFile("testrules/rules_priorities.xml")},
+	}
+
+	_, err = NewTextTemplateParserFS(fsys, []string{"*.xml"}, TextTemplateParserConfig{})
+	if err == nil || !strings.Contains(err.Error(), `duplicate ruleset name "ruleset1" in a.xml and b.xml`) {
+		log.Fatalf("Expected a duplicate ruleset error, got %v", err)
+	}
+}
+
+func TestParserDataInclude(t *testing.T) {
+	// rule data reads its includes from the Files config only
+	data := []byte(`<roulette><include src="teams/shared.xml"/></roulette>`)
+	_, err := NewParser(data)
+	if err == nil || !strings.Contains(err.Error(), "rule data: cannot read teams/shared.xml") {
+		log.Fatalf("Expected an include error without the Files config, got %v", err)
+	}
+
+	parser, err := NewParser(data, TextTemplateParserConfig{Files: os.DirFS("testrules/include")})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if _, ok := parser.Execute(&T2{A: 1, B: 2}).Ruleset("sharedRules"); !ok {
+		log.Fatal("Expected ruleset sharedRules to be included")
+	}
+}
+
+func TestIncludeOutsideDir(t *testing.T) {
+	fsys := fstest.MapFS{
+		"rules/abs.xml":    {Data: []byte(`<roulette><include src="/rules/shared.xml"/></roulette>`)},
+		"rules/parent.xml": {Data: []byte(`<roulette><include src="../shared.xml"/></roulette>`)},
+		"rules/table.xml":  {Data: []byte(`<roulette><decisiontable name="t" src="../t.csv"/></roulette>`)},
+		"shared.xml":       {Data: readFile("testrules/include/teams/shared.xml")},
+	}
+
+	for name, expected := range map[string]string{
+		"rules/abs.xml":    "rules/abs.xml: /rules/shared.xml is not a relative path",
+		"rules/parent.xml": "rules/parent.xml: ../shared.xml is outside of rules",
+		"rules/table.xml":  "rules/table.xml: ../t.csv is outside of rules",
+	} {
+		_, err := NewTextTemplateParserFS(fsys, []string{name}, TextTemplateParserConfig{})
+		if err == nil || err.Error() != expected {
+			log.Fatalf("Expected %q, got %v", expected, err)
+		}
+	}
+
+	_, err := NewTextTemplateParserFiles([]string{"testrules/include/teams/pricing.xml"}, TextTemplateParserConfig{})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	data := []byte(`<roulette><include src="../rules_priorities.xml"/></roulette>`)
+	_, err = NewParser(data, TextTemplateParserConfig{Files: os.DirFS("testrules/include")})
+	if err == nil || !strings.Contains(err.Error(), "is outside of .") {
+		log.Fatalf("Expected an include outside of the Files config to fail, got %v", err)
+	}
+}
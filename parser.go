@@ -1,13 +1,16 @@
 package roulette
 
 import (
+	"context"
 	"encoding/xml"
 	"fmt"
+	"io/fs"
 	"regexp"
 	"sort"
 	"strconv"
 	"strings"
 	"text/template"
+	"time"
 	"unicode"
 
 	"github.com/Masterminds/sprig"
@@ -28,14 +31,22 @@ var defaultSameTypeIndex = map[int]string{
 
 // Parser interface provides methods for the executor to update the tree, execute the values and get result.
 type Parser interface {
-	Execute(vals interface{})
+	Execute(vals interface{}) ExecutionReport
+	ExecuteContext(ctx context.Context, vals interface{}) ExecutionReport
 	GetResult() Result
 }
 
 // XMLData contains the parsed roulette xml tree
 type XMLData struct {
-	Name     xml.Name              `xml:"roulette"`
-	Rulesets []TextTemplateRuleset `xml:"ruleset"`
+	Name     xml.Name              `xml:"roulette" json:"-" yaml:"-"`
+	Rulesets []TextTemplateRuleset `xml:"ruleset" json:"rulesets" yaml:"rulesets"`
+	Includes []Include             `xml:"include" json:"includes,omitempty" yaml:"includes,omitempty"`
+	// DecisionTables are loaded as rulesets after the rulesets of their file.
+	DecisionTables []DecisionTable `xml:"decisiontable" json:"decisionTables,omitempty" yaml:"decisionTables,omitempty"`
+	// Syntax is the syntax of the rule expressions, SyntaxTemplate by default or SyntaxExpr.
+	Syntax string `xml:"syntax,attr,omitempty" json:"syntax,omitempty" yaml:"syntax,omitempty"`
+	// Flows chain the rulesets, see ExecuteFlow.
+	Flows []Flow `xml:"flow" json:"flows,omitempty" yaml:"flows,omitempty"`
 }
 
 // TextTemplateParser holds the rules from a rule file
@@ -45,19 +56,80 @@ type TextTemplateParser struct {
 	defaultFuncs  template.FuncMap
 	bytesBuf      *bytesPool
 	mapBuf        *mapPool
-	sameTypeIndex map[int]string
+	sameTypeIndex *sameTypeIndex
+	workflows     *workflowIndex
+	flows         map[string]*compiledFlow
 }
 
-// Execute executes the parser's rulesets
-func (p TextTemplateParser) Execute(vals interface{}) {
-	var err error
+// Execute executes the parser's rulesets and reports the outcome of every ruleset and rule.
+func (p TextTemplateParser) Execute(vals interface{}) ExecutionReport {
+	return p.ExecuteContext(context.Background(), vals)
+}
+
+// ExecuteContext is like Execute but stops once the context is done. Rulesets which were not
+// executed are reported as skipped with the context's error. The rulesets are selected by the workflow of
+// the context, see WithWorkflow, or else by the WorkflowPattern config.
+func (p TextTemplateParser) ExecuteContext(ctx context.Context, vals interface{}) ExecutionReport {
+	workflowMatches := p.workflowMatches(ctx)
+
+	var report ExecutionReport
+	if p.config.ForwardChaining {
+		report = p.executeChaining(ctx, vals, workflowMatches)
+	} else {
+		report = p.executeRulesets(ctx, vals, workflowMatches, nil)
+	}
+
+	for i := range report.Rulesets {
+		if cov := p.xml.Rulesets[i].coverage; cov != nil {
+			cov.record(p.config.Coverage, report.Rulesets[i])
+		}
+		if report.Rulesets[i].Skipped {
+			log.Debugf("skipping ruleset %s: %v", report.Rulesets[i].Name, report.Rulesets[i].Err)
+		}
+	}
+	return report
+}
+
+// workflowMatches returns which rulesets match the workflow of the context, nil if it has none.
+func (p TextTemplateParser) workflowMatches(ctx context.Context) []bool {
+	if selection, ok := ctx.Value(workflowKey{}).(workflowSelection); ok {
+		return p.workflows.get(selection, p.xml.Rulesets, p.config.WorkflowMatcher)
+	}
+	return nil
+}
+
+// executeRulesets executes the rulesets once. With forward chaining only the rules on the agenda of the chain
+// are evaluated.
+func (p TextTemplateParser) executeRulesets(ctx context.Context, vals interface{}, workflowMatches []bool, chain *chaining) ExecutionReport {
+	report := ExecutionReport{Rulesets: make([]RulesetReport, len(p.xml.Rulesets)), Cycles: 1}
 	for i := range p.xml.Rulesets {
-		err = p.xml.Rulesets[i].Execute(vals)
-		if err != nil {
-			log.Warn(err)
+		if err := ctx.Err(); err != nil {
+			report.Err = err
+			report.Rulesets[i] = RulesetReport{Name: p.xml.Rulesets[i].Name, Skipped: true, Err: err}
+			continue
+		}
+
+		ruleset := p.xml.Rulesets[i]
+		if ruleset.config.flow {
+			report.Rulesets[i] = RulesetReport{Name: ruleset.Name, Skipped: true, Err: ErrFlow}
+			continue
 		}
 
+		if workflowMatches != nil {
+			ruleset.config.workflowMatch = workflowMatches[i]
+		}
+
+		var agenda ruleAgenda
+		if chain != nil {
+			agenda = chain.agenda(i)
+		}
+
+		report.Rulesets[i], _ = ruleset.execute(ctx, vals, false, agenda)
+		if err := report.Rulesets[i].Err; err == context.Canceled || err == context.DeadlineExceeded {
+			report.Err = err
+		}
 	}
+	return report
 }
 
 // GetResult returns the parser's result.
@@ -65,25 +137,62 @@ func (p TextTemplateParser) GetResult() Result {
 	return p.config.Result
 }
 
-// Compile compiles the parser's rulesets
+// prioritiesLimit returns the number of rules of the ruleset which can be successful, all of them if the
+// prioritiesCount is invalid or less than 1.
+func prioritiesLimit(ruleset *TextTemplateRuleset) (int, error) {
+	if ruleset.PrioritiesCount == "all" || ruleset.PrioritiesCount == "" {
+		return len(ruleset.Rules), nil
+	}
+
+	prioritiesCount, err := strconv.ParseInt(ruleset.PrioritiesCount, 10, 32)
+	if err != nil || prioritiesCount < 1 {
+		return len(ruleset.Rules), fmt.Errorf("prioritiesCount %q is not all or a positive number", ruleset.PrioritiesCount)
+	}
+	return int(prioritiesCount), nil
+}
+
+// splitFilterTypes returns the sorted filter types.
+func splitFilterTypes(filterTypes string) []string {
+	filterTypesArr := strings.Split(strings.NewReplacer(" ", "", "*", " ").Replace(filterTypes), ",")
+	sort.Strings(filterTypesArr)
+	return filterTypesArr
+}
+
+// Compile compiles the parser's rulesets. With the Strict config every invalid ruleset, rule and flow is returned
+// as CompileErrors, otherwise only invalid required attributes fail the compilation.
 func (p *TextTemplateParser) compile() error {
-	replacer := strings.NewReplacer(" ", "", "*", " ")
 	newLineReplacer := strings.NewReplacer("\n", "")
 	var _ Ruleset = TextTemplateRuleset{}
+	var errs CompileErrors
 
 	for i := range p.xml.Rulesets {
 
+		// invalid returns the error unless it is collected in strict mode
+		invalid := func(err error) error {
+			if !p.config.Strict {
+				return err
+			}
+			errs = append(errs, RuleError{Pos: p.xml.Rulesets[i].pos.String(), Ruleset: p.xml.Rulesets[i].Name, Err: err})
+			return nil
+		}
+
 		if p.xml.Rulesets[i].FilterTypes == "" {
-			return fmt.Errorf("Missing required attribute filterTypes")
+			if err := invalid(fmt.Errorf("Missing required attribute filterTypes")); err != nil {
+				return err
+			}
 		}
 
 		if p.xml.Rulesets[i].DataKey == "" {
-			return fmt.Errorf("Missing required attribute dataKey")
+			if err := invalid(fmt.Errorf("Missing required attribute dataKey")); err != nil {
+				return err
+			}
 		}
 
 		for i, rune := range p.xml.Rulesets[i].FilterTypes {
 			if !unicode.IsLetter(rune) && i == 0 {
-				return fmt.Errorf("First character of filterTypes is not a letter")
+				if err := invalid(fmt.Errorf("First character of filterTypes is not a letter")); err != nil {
+					return err
+				}
 			}
 
 			if i == 0 {
@@ -91,54 +200,94 @@ func (p *TextTemplateParser) compile() error {
 			}
 		}
 
+		// the rows of a decision table are compiled as rules
+		if p.xml.Rulesets[i].table != nil {
+			p.xml.Rulesets[i].Rules = p.xml.Rulesets[i].table.rules(&p.xml.Rulesets[i], p.config.DelimLeft, p.config.DelimRight)
+		}
+
+		// the rules of the variants are compiled and executed with the rules of the ruleset
+		if len(p.xml.Rulesets[i].Variants) > 0 {
+			p.xml.Rulesets[i].Rules = variantRules(&p.xml.Rulesets[i])
+		}
+
 		// assign buffer pool
 		p.xml.Rulesets[i].bytesBuf = p.bytesBuf
 		p.xml.Rulesets[i].mapBuf = p.mapBuf
 		p.xml.Rulesets[i].sameTypeIndex = p.sameTypeIndex
 
 		// limit
-		if p.xml.Rulesets[i].PrioritiesCount == "all" || p.xml.Rulesets[i].PrioritiesCount == "" {
-			p.xml.Rulesets[i].limit = len(p.xml.Rulesets[i].Rules)
-		} else {
-			prioritiesCount, err := strconv.ParseInt(p.xml.Rulesets[i].PrioritiesCount, 10, 32)
-			if err != nil {
-				p.xml.Rulesets[i].limit = len(p.xml.Rulesets[i].Rules)
-			} else {
-				p.xml.Rulesets[i].limit = int(prioritiesCount)
-			}
+		limit, err := prioritiesLimit(&p.xml.Rulesets[i])
+		if err != nil {
+			// collected in strict mode only, a bad value is still executed as all
+			invalid(err)
 		}
+		p.xml.Rulesets[i].limit = limit
 
-		// split filter types
-
-		typeName := replacer.Replace(p.xml.Rulesets[i].FilterTypes)
-		filterTypesArr := strings.Split(typeName, ",")
-		sort.Strings(filterTypesArr)
-
-		regex := regexp.MustCompile(p.xml.Rulesets[i].Workflow)
-		workflowMatch := true
+		filterTypesArr := splitFilterTypes(p.xml.Rulesets[i].FilterTypes)
 
-		if len(p.config.WorkflowPattern) > 0 && len(p.xml.Rulesets[i].Workflow) > 0 {
-			if p.config.IsWildcardWorkflowPattern {
-				if !wildcardMatcher(p.xml.Rulesets[i].Workflow, p.config.WorkflowPattern) {
-					workflowMatch = false
+		// the types of Facts inputs are registered with RegisterFacts
+		if p.config.Types != nil {
+			for _, typeName := range filterTypesArr {
+				if _, ok := p.config.Types[typeName]; !ok {
+					errs = append(errs, RuleError{Pos: p.xml.Rulesets[i].pos.String(), Ruleset: p.xml.Rulesets[i].Name,
+						Err: fmt.Errorf("filter type %s is not registered", typeName)})
 				}
+			}
+		}
 
-			} else {
-				// if a regex is a no match
-				if !regex.MatchString(p.config.WorkflowPattern) {
-					workflowMatch = false
+		if workflow := p.xml.Rulesets[i].Workflow; workflow != "" {
+			for _, name := range strings.Split(workflow, ",") {
+				if strings.TrimSpace(name) == "" {
+					// collected in strict mode only
+					invalid(fmt.Errorf("workflow %q has an empty name", workflow))
+					break
 				}
 			}
-
 		}
 
 		textTemplateRulesetConfig := textTemplateRulesetConfig{
 			result:         p.config.Result,
 			filterTypesArr: filterTypesArr,
-			workflowMatch:  workflowMatch,
+			ruleTimeout:    p.config.RuleTimeout,
+		}
+
+		if p.xml.Rulesets[i].Rollout != "" {
+			rollout, err := newRollout(p.xml.Rulesets[i].Name, p.xml.Rulesets[i].Rollout, p.xml.Rulesets[i].BucketKey, p.allFuncs())
+			if err != nil {
+				if err := invalid(err); err != nil {
+					return err
+				}
+			}
+			textTemplateRulesetConfig.rollout = rollout
+		}
+
+		if len(p.xml.Rulesets[i].Variants) > 0 {
+			variants, err := newVariants(&p.xml.Rulesets[i], p.allFuncs())
+			if err != nil {
+				if err := invalid(err); err != nil {
+					return err
+				}
+			}
+			textTemplateRulesetConfig.variants = variants
+		}
+
+		resolver, err := newResolver(&p.xml.Rulesets[i], p.config.Resolvers)
+		if err != nil {
+			if err := invalid(err); err != nil {
+				return err
+			}
+		}
+		textTemplateRulesetConfig.resolver = resolver
+
+		switch p.xml.Rulesets[i].TieBreak {
+		case "", TieBreakDeclaration, TieBreakSpecificity:
+		default:
+			// collected in strict mode only, a bad value is still ordered by declaration
+			invalid(fmt.Errorf("tieBreak %q is not %s or %s", p.xml.Rulesets[i].TieBreak, TieBreakDeclaration, TieBreakSpecificity))
 		}
 
 		p.xml.Rulesets[i].config = textTemplateRulesetConfig
+		p.xml.Rulesets[i].config.workflowMatch = p.xml.Rulesets[i].matchesWorkflow(p.config.WorkflowPattern, p.config.WorkflowMatcher)
 
 		if p.xml.Rulesets[i].ResultKey == "" {
 			p.xml.Rulesets[i].ResultKey = "result"
@@ -164,14 +313,17 @@ func (p *TextTemplateParser) compile() error {
 
 			// remove all new lines from the expression
 			p.xml.Rulesets[i].Rules[j].Expr = newLineReplacer.Replace(p.xml.Rulesets[i].Rules[j].Expr)
+			p.xml.Rulesets[i].Rules[j].When = newLineReplacer.Replace(p.xml.Rulesets[i].Rules[j].When)
+			p.xml.Rulesets[i].Rules[j].Then = newLineReplacer.Replace(p.xml.Rulesets[i].Rules[j].Then)
+			p.xml.Rulesets[i].Rules[j].Else = newLineReplacer.Replace(p.xml.Rulesets[i].Rules[j].Else)
 
-			if strings.Contains(p.xml.Rulesets[i].Rules[j].Expr, p.xml.Rulesets[i].ResultKey) && !resultAllowed {
+			if strings.Contains(p.xml.Rulesets[i].Rules[j].text(), p.xml.Rulesets[i].ResultKey) && !resultAllowed {
 				p.xml.Rulesets[i].Rules[j].config.noResultFunc = true
 			}
 
 			// set expcted types for a rule
 			for _, typeName := range p.xml.Rulesets[i].config.filterTypesArr {
-				if strings.Contains(p.xml.Rulesets[i].Rules[j].Expr, typeName) {
+				if strings.Contains(p.xml.Rulesets[i].Rules[j].text(), typeName) {
 					p.xml.Rulesets[i].Rules[j].config.expectTypes = append(p.xml.Rulesets[i].Rules[j].config.expectTypes, typeName)
 				}
 			}
@@ -179,41 +331,99 @@ func (p *TextTemplateParser) compile() error {
 			p.xml.Rulesets[i].Rules[j].config.expectTypesErr = fmt.Errorf("rule expression expected types %s",
 				p.xml.Rulesets[i].Rules[j].config.expectTypes)
 
-			p.xml.Rulesets[i].Rules[j].config.allfuncs = template.FuncMap{}
-
 			sort.Strings(p.xml.Rulesets[i].Rules[j].config.expectTypes)
 
-			for k, v := range p.xml.Rulesets[i].Rules[j].config.defaultfuncs {
-				p.xml.Rulesets[i].Rules[j].config.allfuncs[k] = v
-			}
-
-			// append Masterminds/sprig funcs
-
-			for k, v := range sprig.FuncMap() {
-				p.xml.Rulesets[i].Rules[j].config.allfuncs[k] = v
-			}
-
-			for k, v := range p.xml.Rulesets[i].Rules[j].config.userfuncs {
-				p.xml.Rulesets[i].Rules[j].config.allfuncs[k] = v
-			}
+			p.xml.Rulesets[i].Rules[j].config.allfuncs = p.allFuncs()
 
 			tmpl, err := template.
 				New(p.xml.Rulesets[i].Rules[j].Name).Delims(
 				p.xml.Rulesets[i].Rules[j].config.delimLeft, p.xml.Rulesets[i].Rules[j].config.delimRight).
 				Funcs(p.xml.Rulesets[i].Rules[j].config.allfuncs).
-				Parse(p.xml.Rulesets[i].Rules[j].Expr)
+				Parse(p.xml.Rulesets[i].Rules[j].condition())
+			if err == nil {
+				err = p.xml.Rulesets[i].Rules[j].parseBlocks(tmpl)
+			}
 
 			p.xml.Rulesets[i].Rules[j].config.template = tmpl
 			p.xml.Rulesets[i].Rules[j].config.templateErr = err
+			p.xml.Rulesets[i].Rules[j].config.specificity = specificity(tmpl)
+
+			pos := p.xml.Rulesets[i].Rules[j].pos
+			if pos.line == 0 {
+				pos = p.xml.Rulesets[i].pos
+			}
+
+			if err == nil && p.xml.Rulesets[i].Rules[j].Rollout != "" {
+				// an invalid rollout is reported like an invalid template
+				p.xml.Rulesets[i].Rules[j].config.rollout, err = newRollout(
+					p.xml.Rulesets[i].Name+"/"+p.xml.Rulesets[i].Rules[j].Name,
+					p.xml.Rulesets[i].Rules[j].Rollout, p.xml.Rulesets[i].Rules[j].BucketKey,
+					p.xml.Rulesets[i].Rules[j].config.allfuncs)
+				p.xml.Rulesets[i].Rules[j].config.templateErr = err
+			}
+
+			if err != nil && p.config.Strict {
+				errs = append(errs, RuleError{Pos: pos.String(), Ruleset: p.xml.Rulesets[i].Name,
+					Rule: p.xml.Rulesets[i].Rules[j].Name, Err: err})
+			}
+
+			// registered types are checked in strict mode or not
+			if err == nil && p.config.Types != nil {
+				for _, err := range typeCheck(p.config.Types, &p.xml.Rulesets[i], &p.xml.Rulesets[i].Rules[j]) {
+					errs = append(errs, RuleError{Pos: pos.String(), Ruleset: p.xml.Rulesets[i].Name,
+						Rule: p.xml.Rulesets[i].Rules[j].Name, Err: err})
+				}
+			}
 
 		}
 
-		sort.Sort(p.xml.Rulesets[i])
+		// rules with the same priority stay in order of declaration unless broken by specificity
+		sort.Stable(p.xml.Rulesets[i])
+
+		if p.config.Coverage != nil {
+			p.xml.Rulesets[i].coverage = p.config.Coverage.register(&p.xml.Rulesets[i])
+		}
+	}
+
+	// flows are validated along the rulesets, their errors are listed with the ruleset, registry and type errors
+	switch err := p.compileFlows().(type) {
+	case nil:
+	case CompileErrors:
+		errs = append(errs, err...)
+	case RuleError:
+		if len(errs) == 0 {
+			return err
+		}
+		errs = append(errs, err)
+	default:
+		return err
+	}
+
+	if len(errs) > 0 {
+		return errs
 	}
 
 	return nil
 }
 
+// allFuncs returns the default, sprig and user funcs of the rules.
+func (p *TextTemplateParser) allFuncs() template.FuncMap {
+	funcs := template.FuncMap{}
+	for k, v := range p.defaultFuncs {
+		funcs[k] = v
+	}
+
+	// append Masterminds/sprig funcs
+	for k, v := range sprig.FuncMap() {
+		funcs[k] = v
+	}
+
+	for k, v := range p.config.Userfuncs {
+		funcs[k] = v
+	}
+	return funcs
+}
+
 // TextTemplateParserConfig sets the optional config for the TextTemplateParser
 type TextTemplateParserConfig struct {
 	Userfuncs                 template.FuncMap
@@ -224,10 +434,36 @@ type TextTemplateParserConfig struct {
 	IsWildcardWorkflowPattern bool
 	LogLevel                  string //info, debug, warn, error, fatal. default is info
 	LogPath                   string //stdout, /path/to/file . default is stdout
+	// RuleTimeout abandons a rule's evaluation after the duration. default is no timeout. A template cannot be
+	// stopped: an abandoned rule keeps running in its goroutine until it returns on its own, the values it puts
+	// are dropped and the rest of its ruleset is skipped with ErrRuleAbandoned.
+	RuleTimeout time.Duration
+	Strict      bool         // fail with CompileErrors listing every invalid rule template, attribute, workflow regex and flow
+	Types       TypeRegistry // check the rule expressions and filter types against the types at compile time, strict or not
+	Coverage    *Coverage    // collect the coverage of the rulesets, rules and branches executed
+	// ForwardChaining re-evaluates the rules referring to the facts changed by a rule, in the order of the
+	// rulesets and priorities, until no fact changes or MaxCycles cycles were executed.
+	ForwardChaining bool
+	MaxCycles       int // the limit of the forward chaining cycles. default is 100
+	// WorkflowMatcher matches the WorkflowPattern against the workflows of the rulesets. The default matches
+	// a regex pattern, or a wildcard pattern with IsWildcardWorkflowPattern, against every workflow of a
+	// comma separated list.
+	WorkflowMatcher WorkflowMatcher
+	// Files is the file system of the files included by rule data, e.g. os.DirFS(dir). Without it the rules
+	// parsed from data cannot include files or decision tables.
+	Files fs.FS
+	// Resolvers are the conflict resolvers of the collect strategy by name, in addition to the builtin all,
+	// first, unique and mostSpecific.
+	Resolvers map[string]ConflictResolver
 }
 
 // NewTextTemplateParser returns a new roulette format xml parser.
 func NewTextTemplateParser(data []byte, config TextTemplateParserConfig) (Parser, error) {
+	return newFormatParser(data, FormatXML, []TextTemplateParserConfig{config})
+}
+
+// defaultConfig validates the config and sets the default values.
+func defaultConfig(config TextTemplateParserConfig) (TextTemplateParserConfig, error) {
 
 	if config.DelimLeft == "" {
 		config.DelimLeft = delimLeft
@@ -239,10 +475,26 @@ func NewTextTemplateParser(data []byte, config TextTemplateParserConfig) (Parser
 	} else {
 		err := validateFuncs(config.Userfuncs)
 		if err != nil {
-			return nil, err
+			return config, err
 		}
 	}
 
+	if config.WorkflowMatcher == nil {
+		config.WorkflowMatcher = defaultWorkflowMatcher
+		if config.IsWildcardWorkflowPattern {
+			config.WorkflowMatcher = wildcardWorkflowMatcher
+		} else if config.WorkflowPattern != "" {
+			_, err := regexp.Compile(config.WorkflowPattern)
+			if err != nil {
+				return config, fmt.Errorf("WorkflowPattern is not a valid regex: %v", err)
+			}
+		}
+	}
+
+	if config.ForwardChaining && config.MaxCycles <= 0 {
+		config.MaxCycles = 100
+	}
+
 	if config.LogLevel == "" {
 		config.LogLevel = "info"
 	}
@@ -251,11 +503,17 @@ func NewTextTemplateParser(data []byte, config TextTemplateParserConfig) (Parser
 		config.LogPath = "stdout"
 	}
 
-	xmldata := XMLData{}
+	return config, nil
+}
 
-	err := xml.Unmarshal(data, &xmldata)
-	if err != nil {
-		return nil, err
+// newTextTemplateParser compiles the parsed xml data. The config must be set by defaultConfig.
+func newTextTemplateParser(xmldata XMLData, config TextTemplateParserConfig) (Parser, error) {
+	switch xmldata.Syntax {
+	case "", SyntaxTemplate:
+	case SyntaxExpr:
+		return newExprParser(xmldata, config)
+	default:
+		return nil, fmt.Errorf("syntax %q is not %s or %s", xmldata.Syntax, SyntaxTemplate, SyntaxExpr)
 	}
 
 	parser := TextTemplateParser{
@@ -264,11 +522,12 @@ func NewTextTemplateParser(data []byte, config TextTemplateParserConfig) (Parser
 		xml:           xmldata,
 		bytesBuf:      newBytesPool(),
 		mapBuf:        newMapPool(),
-		sameTypeIndex: defaultSameTypeIndex,
+		sameTypeIndex: newSameTypeIndex(),
+		workflows:     newWorkflowIndex(),
 	}
 
 	// compile rulesets
-	err = parser.compile()
+	err := parser.compile()
 	if err != nil {
 		return nil, err
 	}
@@ -1,9 +1,11 @@
 package roulette
 
 import (
+	"context"
 	"errors"
 	"io/ioutil"
 	"log"
+	"strings"
 	"testing"
 	"text/template"
 	"time"
@@ -427,16 +429,19 @@ func TestQueueParserClose(t *testing.T) {
 	executor := NewQueueExecutor(parser)
 	executor.Execute(in, out)
 
+	written := make(chan struct{})
+
 	//writer
 	go func(in chan interface{}, values []interface{}) {
 
 		for _, v := range values {
 			in <- v
 		}
+		close(written)
 
 	}(in, testValuesQueue)
 
-	time.Sleep(time.Millisecond * 100)
+	<-written
 
 	close(in)
 	executor.CloseResult()
@@ -527,6 +532,21 @@ func TestBadPrioritiesCount(t *testing.T) {
 		log.Fatal(err)
 	}
 
+	// a count less than 1 executes all the rules
+	data := []byte(`<roulette><ruleset name="zero" dataKey="TestData" filterTypes="roulette.T2" prioritiesCount="0">
+        <rule name="a" priority="1"><r>with .TestData</r><r>eq .roulette.T2.A 1</r><r>end</r></rule>
+        <rule name="b" priority="2"><r>with .TestData</r><r>eq .roulette.T2.B 2</r><r>end</r></rule>
+    </ruleset></roulette>`)
+	parser, err := NewParser(data)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ruleset, _ := parser.Execute(&T2{A: 1, B: 2}).Ruleset("zero")
+	if len(ruleset.Fired()) != 2 {
+		log.Fatalf("Expected all the rules to fire, got %v", ruleset)
+	}
+
 }
 
 func TestNoValues(t *testing.T) {
@@ -558,3 +578,726 @@ func BenchmarkSimpleParser(b *testing.B) {
 		executor.Execute(t2)
 	}
 }
+
+func TestExecuteContextCanceled(t *testing.T) {
+	parser, err := NewParser(readFile("testrules/rules_priorities.xml"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	t2 := &T2{A: 1, B: 2}
+	report := NewSimpleExecutor(parser).ExecuteContext(ctx, t2)
+
+	if t2.A != 1 {
+		log.Fatalf("Expected value to be 1, got %d", t2.A)
+	}
+
+	if report.Err != context.Canceled {
+		log.Fatalf("Expected report error to be context.Canceled, got %v", report.Err)
+	}
+
+	for _, ruleset := range report.Rulesets {
+		if !ruleset.Skipped || ruleset.Err != context.Canceled {
+			log.Fatalf("Expected ruleset %s to be skipped", ruleset.Name)
+		}
+	}
+}
+
+func TestRuleTimeout(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+
+	config := TextTemplateParserConfig{
+		RuleTimeout: time.Millisecond * 50,
+		Userfuncs: template.FuncMap{
+			"hang": func() bool {
+				<-release
+				return true
+			},
+		},
+	}
+
+	parser, err := NewParser(readFile("testrules/rules_timeout.xml"), config)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	t2 := &T2{A: 1, B: 2}
+	report := NewSimpleExecutor(parser).Execute(t2)
+
+	ruleset, _ := report.Ruleset("timeoutRules")
+	if ruleset.Rules[0].Status != RuleErrored || ruleset.Rules[0].Err != context.DeadlineExceeded {
+		log.Fatalf("Expected rule hang to time out, got %s %v", ruleset.Rules[0].Status, ruleset.Rules[0].Err)
+	}
+
+	if ruleset.Rules[1].Err != ErrRuleAbandoned || t2.A != 1 {
+		log.Fatalf("Expected rule setA to be skipped after the timeout, got %s %v", ruleset.Rules[1].Status, ruleset.Rules[1].Err)
+	}
+
+	// the request deadline stops the remaining rules.
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*20)
+	defer cancel()
+
+	config.RuleTimeout = 0
+	parser, err = NewParser(readFile("testrules/rules_timeout.xml"), config)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	t2 = &T2{A: 1, B: 2}
+	report = NewSimpleExecutor(parser).ExecuteContext(ctx, t2)
+
+	ruleset, _ = report.Ruleset("timeoutRules")
+	if ruleset.Rules[1].Status != RuleSkipped || t2.A != 1 {
+		log.Fatalf("Expected rule setA to be skipped, got %s", ruleset.Rules[1].Status)
+	}
+
+	if report.Err != context.DeadlineExceeded {
+		log.Fatalf("Expected report error to be context.DeadlineExceeded, got %v", report.Err)
+	}
+}
+
+func TestRuleTimeoutResult(t *testing.T) {
+	release := make(chan struct{})
+	put := make(chan interface{}, 1)
+
+	config := TextTemplateParserConfig{
+		RuleTimeout: time.Millisecond * 20,
+		Result: NewResultCallback(func(val interface{}) {
+			put <- val
+		}),
+		Userfuncs: template.FuncMap{
+			"hang": func() bool {
+				<-release
+				return true
+			},
+		},
+	}
+
+	data := []byte(`<roulette><ruleset name="timeoutRules" dataKey="TestData" resultKey="result" filterTypes="roulette.T2">
+        <rule name="hang" priority="1"><r>with .TestData</r><r>hang | .result.Put .roulette.T2</r><r>end</r></rule>
+    </ruleset></roulette>`)
+	parser, err := NewParser(data, config)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	report := parser.Execute(&T2{A: 1, B: 2})
+	ruleset, _ := report.Ruleset("timeoutRules")
+	if ruleset.Rules[0].Err != context.DeadlineExceeded {
+		log.Fatalf("Expected rule hang to time out, got %v", ruleset.Rules[0].Err)
+	}
+
+	// the abandoned rule returns but its result is dropped
+	close(release)
+	select {
+	case val := <-put:
+		log.Fatalf("Expected the result of the abandoned rule to be dropped, got %v", val)
+	case <-time.After(time.Millisecond * 50):
+	}
+}
+
+func TestQueueExecuteContext(t *testing.T) {
+
+	in := make(chan interface{})
+	out := make(chan interface{})
+
+	config := TextTemplateParserConfig{
+		Result: NewResultQueue(),
+	}
+
+	parser, err := NewParser(readFile("testrules/rules_queue.xml"), config)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	executor := NewQueueExecutor(parser)
+	executor.ExecuteContext(ctx, in, out)
+
+	cancel()
+
+	select {
+	case _, ok := <-out:
+		if ok {
+			log.Fatal("Expected out to be closed")
+		}
+	case <-time.After(time.Second * 3):
+		log.Fatal("Expected out to be closed after cancel")
+	}
+}
+
+func TestQueueExecutorDefaults(t *testing.T) {
+	config := TextTemplateParserConfig{
+		Result: NewResultQueue(),
+	}
+
+	parser, err := NewParser(readFile("testrules/rules_queue.xml"), config)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	in := make(chan interface{})
+	out := make(chan interface{})
+
+	executor := &QueueExecutor{Parser: parser}
+	executor.Execute(in, out)
+
+	go func() {
+		for _, v := range testValuesQueue {
+			in <- v
+		}
+	}()
+
+	for i := range testValuesQueue {
+		select {
+		case <-out:
+		case <-time.After(time.Second * 3):
+			log.Fatalf("received %d less results", len(testValuesQueue)-i)
+		}
+	}
+}
+
+func TestQueueExecutorBackpressure(t *testing.T) {
+	started := make(chan struct{}, 10)
+	release := make(chan struct{})
+
+	config := TextTemplateParserConfig{
+		Result: NewResultQueue(),
+		Userfuncs: template.FuncMap{
+			"hang": func() bool {
+				started <- struct{}{}
+				<-release
+				return true
+			},
+		},
+	}
+
+	parser, err := NewParser(readFile("testrules/rules_queue_hang.xml"), config)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	in := make(chan interface{})
+	out := make(chan interface{})
+
+	executor := NewQueueExecutor(parser, QueueExecutorConfig{
+		Workers:     1,
+		InputBuffer: 1,
+		Policy:      QueueDrop,
+	})
+	executor.Execute(in, out)
+
+	in <- &T2{A: 1, B: 2}
+	<-started
+
+	// one input is buffered while the worker is busy, the rest are dropped.
+	for i := 0; i < 4; i++ {
+		in <- &T2{A: 1, B: 2}
+	}
+
+	deadline := time.After(time.Second * 3)
+	for executor.Metrics().DroppedInputs != 3 {
+		select {
+		case <-deadline:
+			log.Fatalf("Expected 3 dropped inputs, got %+v", executor.Metrics())
+		case <-time.After(time.Millisecond):
+		}
+	}
+
+	metrics := executor.Metrics()
+	if metrics.InFlight != 1 || metrics.Queued != 1 {
+		log.Fatalf("Expected 1 input in flight and 1 queued, got %+v", metrics)
+	}
+
+	close(release)
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-out:
+		case <-time.After(time.Second * 3):
+			log.Fatalf("received %d less results", 2-i)
+		}
+	}
+
+	deadline = time.After(time.Second * 3)
+	for executor.Metrics().Processed != 2 {
+		select {
+		case <-deadline:
+			log.Fatalf("Expected 2 processed inputs, got %+v", executor.Metrics())
+		case <-time.After(time.Millisecond):
+		}
+	}
+}
+
+func TestQueueExecutorBlock(t *testing.T) {
+	config := TextTemplateParserConfig{
+		Result: NewResultQueue(),
+	}
+
+	parser, err := NewParser(readFile("testrules/rules_queue.xml"), config)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	in := make(chan interface{})
+	out := make(chan interface{})
+
+	executor := NewQueueExecutor(parser, QueueExecutorConfig{
+		Workers:     1,
+		InputBuffer: 1,
+		MaxPending:  1,
+		Policy:      QueueBlock,
+	})
+	executor.Execute(in, out)
+
+	go func() {
+		for i := 0; i < 10; i++ {
+			in <- &T2{A: 1, B: 2}
+		}
+	}()
+
+	// the worker blocks on the second result while the first is pending
+	deadline := time.After(time.Second * 3)
+	for executor.Metrics().Pending != 1 {
+		select {
+		case <-deadline:
+			log.Fatalf("Expected 1 pending result, got %+v", executor.Metrics())
+		case <-time.After(time.Millisecond):
+		}
+	}
+	time.Sleep(time.Millisecond * 50)
+
+	metrics := executor.Metrics()
+	if metrics.Processed > 1 || metrics.Pending != 1 {
+		log.Fatalf("Expected the worker to block on the pending result, got %+v", metrics)
+	}
+
+	for i := 0; i < 10; i++ {
+		select {
+		case <-out:
+		case <-time.After(time.Second * 3):
+			log.Fatalf("received %d less results", 10-i)
+		}
+	}
+}
+
+func TestQueueExecutorShutdown(t *testing.T) {
+
+	in := make(chan interface{})
+	out := make(chan interface{})
+
+	config := TextTemplateParserConfig{
+		Result: NewResultQueue(),
+	}
+
+	parser, err := NewParser(readFile("testrules/rules_queue.xml"), config)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	executor := NewQueueExecutor(parser, QueueExecutorConfig{Workers: 2})
+	executor.Execute(in, out)
+
+	for _, v := range testValuesQueue {
+		in <- v
+	}
+
+	shutdown := make(chan error)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+		defer cancel()
+		shutdown <- executor.Shutdown(ctx)
+	}()
+
+	results := 0
+	for range out {
+		results++
+	}
+
+	if results != 3 {
+		log.Fatalf("Expected 3 results, got %d", results)
+	}
+
+	if err := <-shutdown; err != nil {
+		log.Fatal(err)
+	}
+
+	// shutdown is idempotent
+	if err := executor.Shutdown(context.Background()); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func TestQueueExecutorShutdownExpired(t *testing.T) {
+	started := make(chan struct{}, 10)
+	release := make(chan struct{})
+	defer close(release)
+
+	config := TextTemplateParserConfig{
+		Result: NewResultQueue(),
+		Userfuncs: template.FuncMap{
+			"hang": func() bool {
+				started <- struct{}{}
+				<-release
+				return true
+			},
+		},
+	}
+
+	parser, err := NewParser(readFile("testrules/rules_queue_hang.xml"), config)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	in := make(chan interface{})
+	out := make(chan interface{})
+
+	executor := NewQueueExecutor(parser, QueueExecutorConfig{Workers: 1})
+	executor.Execute(in, out)
+
+	in <- &T2{A: 1, B: 2}
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+
+	err = executor.Shutdown(ctx)
+	if _, ok := err.(*ShutdownError); !ok {
+		log.Fatalf("Expected a ShutdownError, got %v", err)
+	}
+
+	select {
+	case _, ok := <-out:
+		if ok {
+			log.Fatal("Expected out to be closed")
+		}
+	case <-time.After(time.Second * 3):
+		log.Fatal("Expected out to be closed after shutdown")
+	}
+}
+
+func TestOrderedResultQueue(t *testing.T) {
+
+	in := make(chan interface{})
+	out := make(chan interface{})
+
+	config := TextTemplateParserConfig{
+		Result: NewResultQueue(ResultQueueConfig{Ordered: true, Envelope: true}),
+		Userfuncs: template.FuncMap{
+			"delay": func(ms int) bool {
+				time.Sleep(time.Millisecond * time.Duration(ms))
+				return true
+			},
+		},
+	}
+
+	parser, err := NewParser(readFile("testrules/rules_queue_ordered.xml"), config)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	executor := NewQueueExecutor(parser, QueueExecutorConfig{Workers: 3})
+	executor.Execute(in, out)
+
+	// earlier inputs take longer to evaluate
+	inputs := []*T2{{A: 1, B: 30}, {A: 2, B: 20}, {A: 3, B: 10}}
+	go func() {
+		for _, v := range inputs {
+			in <- v
+		}
+	}()
+
+	for i := 0; i < len(inputs)*2; i++ {
+		select {
+		case v := <-out:
+			env, ok := v.(ResultEnvelope)
+			if !ok {
+				log.Fatalf("Expected a ResultEnvelope, got %T", v)
+			}
+
+			input := inputs[i/2]
+			if env.Input != input || env.Ruleset != "orderedRules" {
+				log.Fatalf("result %d: unexpected envelope %+v", i, env)
+			}
+
+			// rules are executed in order of priority
+			if i%2 == 0 && (env.Rule != "putB" || env.Priority != 1 || env.Value != input.B) {
+				log.Fatalf("result %d: unexpected envelope %+v", i, env)
+			}
+
+			if i%2 == 1 && (env.Rule != "putT2" || env.Priority != 2 || env.Value != input.A) {
+				log.Fatalf("result %d: unexpected envelope %+v", i, env)
+			}
+
+		case <-time.After(time.Second * 3):
+			log.Fatalf("received %d less results", len(inputs)*2-i)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+	if err := executor.Shutdown(ctx); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func TestOrderedResultQueueBlock(t *testing.T) {
+
+	in := make(chan interface{})
+	out := make(chan interface{})
+
+	config := TextTemplateParserConfig{
+		Result: NewResultQueue(ResultQueueConfig{Ordered: true}),
+		Userfuncs: template.FuncMap{
+			"delay": func(ms int) bool {
+				time.Sleep(time.Millisecond * time.Duration(ms))
+				return true
+			},
+		},
+	}
+
+	parser, err := NewParser(readFile("testrules/rules_queue_ordered.xml"), config)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	executor := NewQueueExecutor(parser, QueueExecutorConfig{Workers: 3, MaxPending: 1, Policy: QueueBlock})
+	executor.Execute(in, out)
+
+	// the first input is evaluated while the later ones wait
+	inputs := []*T2{{A: 1, B: 300}, {A: 2, B: 1}, {A: 3, B: 1}}
+	go func() {
+		for _, v := range inputs {
+			in <- v
+		}
+	}()
+
+	if v := <-out; v != 300 {
+		log.Fatalf("Expected the first result of the first input, got %v", v)
+	}
+	time.Sleep(time.Millisecond * 100)
+
+	// one result of the later inputs waits, their rules block on the others
+	metrics := executor.Metrics()
+	if metrics.Pending != 1 || metrics.Processed != 0 {
+		log.Fatalf("Expected 1 result waiting for the first input, got %+v", metrics)
+	}
+
+	for _, expected := range []interface{}{1, 1, 2, 1, 3} {
+		select {
+		case v := <-out:
+			if v != expected {
+				log.Fatalf("Expected %v, got %v", expected, v)
+			}
+		case <-time.After(time.Second * 3):
+			log.Fatal("Expected the results of the inputs in order")
+		}
+	}
+
+	// with QueueDrop the results put while the first one is pending are dropped, whatever their input
+	config.Result = NewResultQueue(ResultQueueConfig{Ordered: true})
+	parser, err = NewParser(readFile("testrules/rules_queue_ordered.xml"), config)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	in = make(chan interface{})
+	executor = NewQueueExecutor(parser, QueueExecutorConfig{Workers: 3, MaxPending: 1, Policy: QueueDrop})
+	executor.Execute(in, make(chan interface{}))
+	for _, v := range inputs {
+		in <- v
+	}
+
+	deadline := time.After(time.Second * 3)
+	for executor.Metrics().DroppedResults != 5 {
+		select {
+		case <-deadline:
+			log.Fatalf("Expected 5 dropped results, got %+v", executor.Metrics())
+		case <-time.After(time.Millisecond):
+		}
+	}
+}
+
+func TestResultEnvelope(t *testing.T) {
+	var envelopes []ResultEnvelope
+	result := NewResultQueue(ResultQueueConfig{Envelope: true})
+
+	config := TextTemplateParserConfig{
+		Result: result,
+	}
+
+	parser, err := NewParser(readFile("testrules/rules_callback.xml"), config)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	t2 := &T2{A: 1, B: 2}
+	NewSimpleExecutor(parser).Execute(t2)
+
+	for i := 0; i < 3; i++ {
+		select {
+		case v := <-result.Get().(chan interface{}):
+			envelopes = append(envelopes, v.(ResultEnvelope))
+		case <-time.After(time.Second * 3):
+			log.Fatalf("received %d less results", 3-i)
+		}
+	}
+
+	rules := map[string]bool{}
+	for _, env := range envelopes {
+		if env.Value != t2 {
+			log.Fatalf("Expected value to be the input T2, got %v", env.Value)
+		}
+		rules[env.Ruleset+"/"+env.Rule] = true
+	}
+
+	for _, name := range []string{"simpleParserTestRules/setA1", "simpleParserTestRules/setA2", "emptyResultKeySet/emptyResultKeyRule"} {
+		if !rules[name] {
+			log.Fatalf("Expected a result from %s, got %v", name, rules)
+		}
+	}
+}
+
+func TestStrictParser(t *testing.T) {
+	data := readFile("testrules/rules_strict.xml")
+
+	// not strict, the invalid rules are skipped when executing
+	_, err := NewParser(data, TextTemplateParserConfig{})
+	if err == nil || err.Error() != "Missing required attribute dataKey" {
+		log.Fatalf("Expected the missing dataKey error, got %v", err)
+	}
+
+	_, err = NewParser(data, TextTemplateParserConfig{Strict: true})
+	errs, ok := err.(CompileErrors)
+	if !ok {
+		log.Fatalf("Expected CompileErrors, got %v", err)
+	}
+
+	expected := []string{
+		"line 8: ruleset brokenRules: prioritiesCount \"bad\" is not all or a positive number",
+		"line 8: ruleset brokenRules: workflow \"ipl,,summer\" has an empty name",
+		"line 11: ruleset brokenRules rule unknownFunc: template: unknownFunc:1: function \"notAFunc\" not defined",
+		"line 15: ruleset brokenRules rule unclosed: template: unclosed:1: unexpected EOF",
+		"line 21: ruleset missingDataKey: Missing required attribute dataKey",
+	}
+
+	if len(errs) != len(expected) {
+		log.Fatalf("Expected %d errors, got %v", len(expected), err)
+	}
+
+	for i := range expected {
+		if !strings.HasPrefix(errs[i].Error(), expected[i]) {
+			log.Fatalf("Expected error %q, got %q", expected[i], errs[i].Error())
+		}
+	}
+
+	_, err = NewTextTemplateParserFiles([]string{"testrules/rules_strict.xml"}, TextTemplateParserConfig{Strict: true})
+	if err == nil || !strings.Contains(err.Error(), "testrules/rules_strict.xml:11: ruleset brokenRules rule unknownFunc") {
+		log.Fatalf("Expected the file name in the errors, got %v", err)
+	}
+
+	_, err = NewParser(readFile("testrules/rules_simple.xml"), TextTemplateParserConfig{Strict: true})
+	if err == nil || !strings.Contains(err.Error(), "rule malformedExpression") {
+		log.Fatalf("Expected the malformed expression error, got %v", err)
+	}
+}
+
+func TestStrictBadWorkflowRegex(t *testing.T) {
+	data := []byte(`<roulette><ruleset name="r" dataKey="d" filterTypes="roulette.T2" workflow="ipl("></ruleset></roulette>`)
+
+	// the workflow of a ruleset is a name, not a regex
+	_, err := NewParser(data, TextTemplateParserConfig{WorkflowPattern: "ipl"})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// the pattern is the regex, it is an error instead of a panic
+	_, err = NewParser(data, TextTemplateParserConfig{WorkflowPattern: "ipl("})
+	if err == nil || !strings.Contains(err.Error(), "WorkflowPattern is not a valid regex") {
+		log.Fatalf("Expected a regex error, got %v", err)
+	}
+}
+
+func TestTypeRegistry(t *testing.T) {
+	config := TextTemplateParserConfig{Types: NewTypeRegistry(T2{}, &T1{})}
+
+	for _, file := range []string{"testrules/rules_priorities.xml", "testrules/rules_callback.xml", "testrules/rules_workflows.xml"} {
+		_, err := NewParser(readFile(file), config)
+		if err != nil {
+			log.Fatalf("%s: %v", file, err)
+		}
+	}
+
+	expected := []string{
+		"line 2: ruleset typedRules: filter type roulette.T3 is not registered",
+		"line 12: ruleset typedRules rule unknownField: unknownField:1:48: roulette.T2 has no exported field or method C",
+		"line 16: ruleset typedRules rule unknownMethod: unknownMethod:1:45: *roulette.T2 has no exported method SetB",
+		"line 20: ruleset typedRules rule wrongArgs: wrongArgs:1:45: wrong number of args for *roulette.T2.SetA: want at least 1 got 0",
+		"line 24: ruleset typedRules rule unregisteredType: unregisteredType:1:45: type roulette.T4 is not registered",
+		"line 28: ruleset typedRules rule badVariable: badVariable:1:63: roulette.T2 has no exported field or method Missing",
+		"line 32: ruleset typedRules rule badDataKey: badDataKey:1:23: TestDta is not the dataKey TestData",
+	}
+
+	// the registered types are checked with the Strict config or without it
+	for _, strict := range []bool{true, false} {
+		config.Strict = strict
+		_, err := NewParser(readFile("testrules/rules_types.xml"), config)
+		errs, ok := err.(CompileErrors)
+		if !ok {
+			log.Fatalf("Expected CompileErrors, got %v", err)
+		}
+
+		if len(errs) != len(expected) {
+			log.Fatalf("Expected %d errors, got %v", len(expected), err)
+		}
+
+		for i := range expected {
+			if errs[i].Error() != expected[i] {
+				log.Fatalf("Expected error %q, got %q", expected[i], errs[i].Error())
+			}
+		}
+	}
+}
+
+type typeP9 struct {
+	A int
+}
+
+func (p *typeP9) Reset() {
+	p.A = 0
+}
+
+func TestTypeRegistryVoidMethod(t *testing.T) {
+	data := []byte(`<roulette><ruleset name="r" dataKey="TestData" filterTypes="roulette.typeP9">
+        <rule name="reset" priority="1"><r>with .TestData</r><r>.roulette.typeP9.Reset</r><r>end</r></rule>
+    </ruleset></roulette>`)
+
+	_, err := NewParser(data, TextTemplateParserConfig{Types: NewTypeRegistry(typeP9{})})
+	errs, ok := err.(CompileErrors)
+	if !ok || len(errs) != 1 || !strings.Contains(errs[0].Error(), "*roulette.typeP9.Reset returns no value") {
+		log.Fatalf("Expected a method without result error, got %v", err)
+	}
+}
+
+func TestTypeRegistryFacts(t *testing.T) {
+	data := []byte(`<roulette><ruleset name="r" dataKey="TestData" filterTypes="roulette.T2,types.Person">
+        <rule name="age" priority="1"><r>with .TestData</r><r>gt .types.Person.Age 18</r><r>end</r></rule>
+    </ruleset></roulette>`)
+
+	types := NewTypeRegistry(T2{})
+	_, err := NewParser(data, TextTemplateParserConfig{Types: types})
+	if err == nil || !strings.Contains(err.Error(), "filter type types.Person is not registered") {
+		log.Fatalf("Expected an unregistered filter type error, got %v", err)
+	}
+
+	types.RegisterFacts("types.Person")
+	_, err = NewParser(data, TextTemplateParserConfig{Types: types})
+	if err != nil {
+		log.Fatal(err)
+	}
+}
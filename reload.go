@@ -0,0 +1,234 @@
+package roulette
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/myntra/roulette/log"
+)
+
+// default interval at which a ReloadingParser checks its source for changes
+var defaultReloadInterval = time.Second
+
+// ReloadingParserConfig sets the optional config for the ReloadingParser
+type ReloadingParserConfig struct {
+	Parser   TextTemplateParserConfig // config of the compiled parsers
+	Interval time.Duration            // how often the source is checked for changes. default is 1s
+	OnReload func(err error)          // called after every reload. err is non nil if the rules were not swapped
+}
+
+// ReloadingParser implements the Parser interface over a rule file or a directory of xml, json and yaml rule files and
+// the files they include. It recompiles the rules when the source changes and swaps them only if compilation succeeds. Executions
+// started before a swap finish on the old rules.
+type ReloadingParser struct {
+	source  string
+	config  ReloadingParserConfig
+	current atomic.Value // Parser
+
+	mu        sync.Mutex // serializes reloads
+	signature string     // signature of the source files at the last reload attempt
+	err       error      // error of the last reload attempt
+	included  []string   // files merged at the last reload attempt
+	quit      chan struct{}
+	closeOnce sync.Once
+}
+
+// Execute executes the current rulesets
+func (r *ReloadingParser) Execute(vals interface{}) ExecutionReport {
+	return r.Parser().Execute(vals)
+}
+
+// ExecuteContext executes the current rulesets and stops once the context is done.
+func (r *ReloadingParser) ExecuteContext(ctx context.Context, vals interface{}) ExecutionReport {
+	return r.Parser().ExecuteContext(ctx, vals)
+}
+
+// GetResult returns the parser's result.
+func (r *ReloadingParser) GetResult() Result {
+	return r.config.Parser.Result
+}
+
+// Parser returns the currently compiled parser.
+func (r *ReloadingParser) Parser() Parser {
+	return r.current.Load().(Parser)
+}
+
+// Reload recompiles the source if it changed since the last reload. On error the current rules are kept.
+func (r *ReloadingParser) Reload() error {
+	_, err := r.reload()
+	return err
+}
+
+// reload returns true if the source changed since the last attempt.
+func (r *ReloadingParser) reload() (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	files, err := sourceFiles(r.source)
+	if err != nil {
+		return false, err
+	}
+
+	// included files are watched too
+	signature, err := filesSignature(files, r.included)
+	if err != nil {
+		return false, err
+	}
+
+	if signature == r.signature {
+		return false, r.err
+	}
+
+	r.signature = signature
+	r.err = nil
+
+	parser, loaded, err := compileFiles(files, r.config.Parser)
+	if loaded != nil {
+		r.included = loaded
+		r.signature, _ = filesSignature(files, r.included)
+	}
+
+	if err != nil {
+		r.err = err
+		return true, err
+	}
+
+	r.current.Store(parser)
+	return true, nil
+}
+
+// Close stops watching the source.
+func (r *ReloadingParser) Close() error {
+	r.closeOnce.Do(func() { close(r.quit) })
+	return nil
+}
+
+func (r *ReloadingParser) watch() {
+	ticker := time.NewTicker(r.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.quit:
+			return
+		case <-ticker.C:
+			changed, err := r.reload()
+			if err != nil && changed {
+				log.Warn(fmt.Sprintf("keeping previous rules, reloading %s: %v", r.source, err))
+			}
+
+			if changed && r.config.OnReload != nil {
+				r.config.OnReload(err)
+			}
+		}
+	}
+}
+
+// sourceFiles returns the file or the sorted rule files of the directory.
+func sourceFiles(source string) ([]string, error) {
+	info, err := os.Stat(source)
+	if err != nil {
+		return nil, err
+	}
+
+	if !info.IsDir() {
+		return []string{source}, nil
+	}
+
+	matches, err := filepath.Glob(filepath.Join(source, "*"))
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	for _, match := range matches {
+		if _, ok := ruleFileExts[strings.ToLower(filepath.Ext(match))]; ok {
+			files = append(files, match)
+		}
+	}
+
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no rule files in %s", source)
+	}
+
+	sort.Strings(files)
+	return files, nil
+}
+
+// filesSignature identifies the current version of the files and the files they included. An included file
+// which was removed changes the signature, the including file may not include it any more.
+func filesSignature(files, included []string) (string, error) {
+	signature := ""
+	for i, file := range append(files[:len(files):len(files)], included...) {
+		info, err := os.Stat(file)
+		if os.IsNotExist(err) && i >= len(files) {
+			signature += file + ":missing;"
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		signature += fmt.Sprintf("%s:%d:%d;", file, info.Size(), info.ModTime().UnixNano())
+	}
+	return signature, nil
+}
+
+// compileFiles merges the rulesets of the files and the files they include into one parser. It returns
+// all the merged files.
+func compileFiles(files []string, config TextTemplateParserConfig) (Parser, []string, error) {
+	config, err := defaultConfig(config)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	loader := newRuleLoader(osFiles{})
+	for _, file := range files {
+		err = loader.loadRoot(file)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	xmldata, err := loader.result()
+	if err != nil {
+		return nil, loader.order, err
+	}
+
+	parser, err := newTextTemplateParser(xmldata, config)
+	return parser, loader.order, err
+}
+
+// NewReloadingParser returns a parser which recompiles the rule file or directory of rule files
+// when it changes. Close stops watching the source.
+func NewReloadingParser(source string, config ...ReloadingParserConfig) (*ReloadingParser, error) {
+	cfg := ReloadingParserConfig{}
+	if len(config) > 0 {
+		cfg = config[0]
+	}
+
+	if cfg.Interval <= 0 {
+		cfg.Interval = defaultReloadInterval
+	}
+
+	r := &ReloadingParser{
+		source: source,
+		config: cfg,
+		quit:   make(chan struct{}),
+	}
+
+	err := r.Reload()
+	if err != nil {
+		return nil, err
+	}
+
+	go r.watch()
+
+	return r, nil
+}
@@ -0,0 +1,180 @@
+package roulette
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeRuleFile(path string, value string) {
+	rules := strings.Replace(string(readFile("testrules/rules_reload.xml")), "VALUE", value, 1)
+	err := ioutil.WriteFile(path, []byte(rules), 0644)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func TestReloadingParser(t *testing.T) {
+	dir, err := ioutil.TempDir("", "roulette")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "rules.xml")
+	writeRuleFile(path, "5")
+
+	reloaded := make(chan error, 10)
+	parser, err := NewReloadingParser(dir, ReloadingParserConfig{
+		Interval: time.Millisecond * 10,
+		OnReload: func(err error) {
+			reloaded <- err
+		},
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer parser.Close()
+
+	executor := NewSimpleExecutor(parser)
+
+	t2 := &T2{A: 1, B: 2}
+	executor.Execute(t2)
+	if t2.A != 5 {
+		log.Fatalf("Expected value to be 5, got %d", t2.A)
+	}
+
+	// the new rules are used by subsequent executions
+	writeRuleFile(path, "10")
+	select {
+	case err := <-reloaded:
+		if err != nil {
+			log.Fatal(err)
+		}
+	case <-time.After(time.Second * 3):
+		log.Fatal("Expected the rules to be reloaded")
+	}
+
+	executor.Execute(t2)
+	if t2.A != 10 {
+		log.Fatalf("Expected value to be 10, got %d", t2.A)
+	}
+
+	// broken rules are not swapped
+	err = ioutil.WriteFile(path, []byte("<roulette><ruleset"), 0644)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	select {
+	case err := <-reloaded:
+		if err == nil {
+			log.Fatal("Expected a reload error")
+		}
+	case <-time.After(time.Second * 3):
+		log.Fatal("Expected the rules to be reloaded")
+	}
+
+	t2.A = 1
+	executor.Execute(t2)
+	if t2.A != 10 {
+		log.Fatalf("Expected value to be 10, got %d", t2.A)
+	}
+}
+
+func TestReloadingParserRemovedInclude(t *testing.T) {
+	dir, err := ioutil.TempDir("", "roulette")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "rules.xml")
+	shared := filepath.Join(dir, "shared.xml")
+	writeRuleFile(shared, "5")
+	err = ioutil.WriteFile(path, []byte(`<roulette><include src="shared.xml"/></roulette>`), 0644)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	parser, err := NewReloadingParser(path, ReloadingParserConfig{Interval: time.Hour})
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer parser.Close()
+
+	err = os.Remove(shared)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err = parser.Reload(); err == nil {
+		log.Fatal("Expected an error for the removed include")
+	}
+
+	writeRuleFile(path, "10")
+	if err = parser.Reload(); err != nil {
+		log.Fatalf("Expected the rules without the include to be reloaded, got %v", err)
+	}
+
+	t2 := &T2{A: 1, B: 2}
+	parser.Execute(t2)
+	if t2.A != 10 {
+		log.Fatalf("Expected value to be 10, got %d", t2.A)
+	}
+}
+
+func TestReloadingParserDecisionTable(t *testing.T) {
+	dir, err := ioutil.TempDir("", "roulette")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "rules.xml")
+	table := filepath.Join(dir, "table.csv")
+	err = ioutil.WriteFile(path, []byte(`<roulette><decisiontable name="t" src="table.csv" dataKey="TestData"/></roulette>`), 0644)
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = ioutil.WriteFile(table, []byte("roulette.T2.A,roulette.T2.SetA\n1,5\n"), 0644)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	parser, err := NewReloadingParser(path, ReloadingParserConfig{Interval: time.Hour})
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer parser.Close()
+
+	// the table is watched like the rule file
+	err = ioutil.WriteFile(table, []byte("roulette.T2.A,roulette.T2.SetA\n1,10\n"), 0644)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err = parser.Reload(); err != nil {
+		log.Fatal(err)
+	}
+
+	t2 := &T2{A: 1, B: 2}
+	parser.Execute(t2)
+	if t2.A != 10 {
+		log.Fatalf("Expected the rows of the edited table to set 10, got %d", t2.A)
+	}
+}
+
+func TestReloadingParserBadSource(t *testing.T) {
+	_, err := NewReloadingParser("testrules/missing.xml")
+	if err == nil {
+		log.Fatal("Expected an error for a missing source")
+	}
+
+	_, err = NewReloadingParser("testrules/rules_badxml.xml")
+	if err == nil {
+		log.Fatal("Expected an error for a bad rule file")
+	}
+}
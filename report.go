@@ -0,0 +1,176 @@
+package roulette
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrWorkflowMismatch is reported when a ruleset's workflow does not match the parser's workflow pattern.
+	ErrWorkflowMismatch = errors.New("ruleset workflow does not match the workflow pattern")
+	// ErrFilterTypes is reported when the input values do not satisfy a ruleset's filterTypes.
+	ErrFilterTypes = errors.New("input types do not match filterTypes")
+	// ErrNoResult is reported when a rule refers to the result key but the parser has no Result set.
+	ErrNoResult = errors.New("rule expression contains result func but no Result was set")
+	// ErrPrioritiesCount is reported for rules skipped after prioritiesCount rules were successful.
+	ErrPrioritiesCount = errors.New("prioritiesCount limit reached")
+	// ErrActivationGroup is reported for the rules skipped after another rule of their activation group fired.
+	ErrActivationGroup = errors.New("another rule of the activation group fired")
+	// ErrRuleAbandoned is reported for the rules skipped after a rule of their ruleset was abandoned, see RuleTimeout.
+	ErrRuleAbandoned = errors.New("a previous rule of the ruleset was abandoned")
+	// ErrNotChosen is reported for the matches of the collect strategy the conflict resolver did not choose.
+	ErrNotChosen = errors.New("rule matched but was not chosen by the conflict resolver")
+)
+
+// RuleStatus is the outcome of a single rule.
+type RuleStatus int
+
+const (
+	// RuleSkipped the rule was not evaluated.
+	RuleSkipped RuleStatus = iota
+	// RuleErrored the rule failed to compile, execute or did not return a bool.
+	RuleErrored
+	// RuleFalse the rule was evaluated to false.
+	RuleFalse
+	// RuleFired the rule was evaluated to true.
+	RuleFired
+)
+
+func (s RuleStatus) String() string {
+	switch s {
+	case RuleSkipped:
+		return "skipped"
+	case RuleErrored:
+		return "errored"
+	case RuleFalse:
+		return "false"
+	case RuleFired:
+		return "fired"
+	}
+	return "unknown"
+}
+
+// RuleReport is the outcome of a rule.
+type RuleReport struct {
+	Name     string
+	Priority int
+	Status   RuleStatus
+	Err      error // why the rule was skipped or errored
+}
+
+// RulesetReport is the outcome of a ruleset and its rules, in order of priority.
+type RulesetReport struct {
+	Name    string
+	Variant string // the variant assigned to the input, empty without variants
+	Skipped bool
+	Err     error // why the ruleset was skipped or stopped
+	Rules   []RuleReport
+	Values  map[string]interface{} // the values the rules set with .R.Set
+}
+
+// Fired returns the names of the rules which evaluated to true.
+func (r RulesetReport) Fired() []string {
+	var fired []string
+	for _, rule := range r.Rules {
+		if rule.Status == RuleFired {
+			fired = append(fired, rule.Name)
+		}
+	}
+	return fired
+}
+
+// ExecutionReport is the outcome of executing the parser's rulesets on a set of values.
+type ExecutionReport struct {
+	Rulesets []RulesetReport
+	Err      error // the context's error if the execution was stopped, or ErrCycleLimit
+	Cycles   int   // the forward chaining cycles executed, 1 without ForwardChaining
+}
+
+// Ruleset returns the report of the named ruleset.
+func (e ExecutionReport) Ruleset(name string) (RulesetReport, bool) {
+	for _, r := range e.Rulesets {
+		if r.Name == name {
+			return r, true
+		}
+	}
+	return RulesetReport{}, false
+}
+
+// Errors returns the errors of all the rules which errored.
+func (e ExecutionReport) Errors() []error {
+	var errs []error
+	for _, ruleset := range e.Rulesets {
+		for _, rule := range ruleset.Rules {
+			if rule.Status == RuleErrored {
+				errs = append(errs, fmt.Errorf("%s/%s: %v", ruleset.Name, rule.Name, rule.Err))
+			}
+		}
+	}
+	return errs
+}
+
+func (e ExecutionReport) String() string {
+	var buf bytes.Buffer
+	for _, ruleset := range e.Rulesets {
+		if ruleset.Skipped {
+			fmt.Fprintf(&buf, "ruleset %s: skipped: %v\n", ruleset.Name, ruleset.Err)
+			continue
+		}
+		fmt.Fprintf(&buf, "ruleset %s:\n", rulesetName(ruleset.Name, ruleset.Variant))
+		for _, rule := range ruleset.Rules {
+			if rule.Err != nil {
+				fmt.Fprintf(&buf, "  rule %s (priority %d): %s: %v\n", rule.Name, rule.Priority, rule.Status, rule.Err)
+			} else {
+				fmt.Fprintf(&buf, "  rule %s (priority %d): %s\n", rule.Name, rule.Priority, rule.Status)
+			}
+		}
+	}
+	return buf.String()
+}
+
+// rulesetName returns the name of the ruleset followed by its assigned variant.
+func rulesetName(name, variant string) string {
+	if variant == "" {
+		return name
+	}
+	return fmt.Sprintf("%s (variant %s)", name, variant)
+}
+
+// RuleError is an invalid ruleset or rule found while compiling the rules.
+type RuleError struct {
+	Pos     string // file:line of the ruleset or rule. lines are known for xml rules
+	Ruleset string
+	Rule    string // empty if the ruleset is invalid
+	Flow    string // set instead of the ruleset if a flow is invalid
+	Err     error
+}
+
+func (e RuleError) Error() string {
+	var buf bytes.Buffer
+	if e.Pos != "" {
+		fmt.Fprintf(&buf, "%s: ", e.Pos)
+	}
+	if e.Flow != "" {
+		fmt.Fprintf(&buf, "flow %s", e.Flow)
+	} else {
+		fmt.Fprintf(&buf, "ruleset %s", e.Ruleset)
+	}
+	if e.Rule != "" {
+		fmt.Fprintf(&buf, " rule %s", e.Rule)
+	}
+	fmt.Fprintf(&buf, ": %v", e.Err)
+	return buf.String()
+}
+
+// CompileErrors lists every invalid ruleset, rule and flow. It is returned by the parser constructors with the Strict config.
+type CompileErrors []RuleError
+
+func (e CompileErrors) Error() string {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%d invalid rules:", len(e))
+	for _, err := range e {
+		fmt.Fprintf(&buf, "\n%v", err)
+	}
+	return buf.String()
+}
@@ -0,0 +1,82 @@
+package roulette
+
+import (
+	"log"
+	"testing"
+)
+
+func TestExecutionReport(t *testing.T) {
+	config := TextTemplateParserConfig{
+		WorkflowPattern:           "iplsale",
+		IsWildcardWorkflowPattern: true,
+	}
+
+	parser, err := NewParser(readFile("testrules/rules_report.xml"), config)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	t2 := &T2{A: 1, B: 2}
+	report := NewSimpleExecutor(parser).Execute(t2)
+
+	if t2.A != 5 {
+		t.Fatalf("Expected value to be 5, got %d", t2.A)
+	}
+
+	if len(report.Rulesets) != 4 {
+		t.Fatalf("Expected 4 ruleset reports, got %d", len(report.Rulesets))
+	}
+
+	ruleset, ok := report.Ruleset("reportRules")
+	if !ok || ruleset.Skipped {
+		t.Fatalf("Expected reportRules to be executed: %v", report)
+	}
+
+	expected := []struct {
+		name   string
+		status RuleStatus
+		err    error
+	}{
+		{"fired", RuleFired, nil},
+		{"false", RuleFalse, nil},
+		{"notBool", RuleErrored, nil},
+		{"badField", RuleErrored, nil},
+		{"noResult", RuleSkipped, ErrNoResult},
+	}
+
+	for i, e := range expected {
+		rule := ruleset.Rules[i]
+		if rule.Name != e.name || rule.Status != e.status {
+			t.Errorf("rule %d: expected %s %s, got %s %s", i, e.name, e.status, rule.Name, rule.Status)
+		}
+		if e.status == RuleErrored && rule.Err == nil {
+			t.Errorf("rule %s: expected an error", rule.Name)
+		}
+		if e.err != nil && rule.Err != e.err {
+			t.Errorf("rule %s: expected error %v, got %v", rule.Name, e.err, rule.Err)
+		}
+	}
+
+	if len(report.Errors()) != 2 {
+		t.Errorf("Expected 2 errors, got %v", report.Errors())
+	}
+
+	if fired := ruleset.Fired(); len(fired) != 1 || fired[0] != "fired" {
+		t.Errorf("Expected only rule fired to fire, got %v", fired)
+	}
+
+	limit, _ := report.Ruleset("limitRules")
+	if limit.Rules[0].Status != RuleFired || limit.Rules[1].Status != RuleSkipped || limit.Rules[1].Err != ErrPrioritiesCount {
+		t.Errorf("Expected second rule to be skipped by prioritiesCount: %v", limit)
+	}
+
+	filtered, _ := report.Ruleset("filteredRules")
+	if !filtered.Skipped || filtered.Err != ErrFilterTypes {
+		t.Errorf("Expected filteredRules to be skipped by filterTypes: %v", filtered)
+	}
+
+	workflow, _ := report.Ruleset("workflowRules")
+	if !workflow.Skipped || workflow.Err != ErrWorkflowMismatch {
+		t.Errorf("Expected workflowRules to be skipped by workflow: %v", workflow)
+	}
+}
@@ -1,11 +1,101 @@
 package roulette
 
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+)
+
 // Result interface provides methods to get/put an interface{} value from a rule.
 type Result interface {
 	Put(val interface{}, prevVal ...bool) bool
 	Get() interface{}
 }
 
+// ResultEnvelope wraps a value put by a rule with the input and the rule which produced it.
+type ResultEnvelope struct {
+	Input    interface{} // the values the rulesets were executed on
+	Ruleset  string
+	Rule     string
+	Priority int
+	Variant  string // the variant of the ruleset assigned to the input, empty without variants
+	Value    interface{}
+
+	seq      uint64 // input sequence assigned by the QueueExecutor
+	reserved bool   // counted by the orderWindow while it waits for the earlier inputs
+}
+
+// EnvelopeResult is implemented by a Result which wants to receive values wrapped in a ResultEnvelope.
+type EnvelopeResult interface {
+	Result
+	PutEnvelope(env ResultEnvelope, prevVal ...bool) bool
+}
+
+// boundResult binds the parser's Result to an execution of a ruleset, so that values
+// put by a rule can be wrapped in a ResultEnvelope.
+type boundResult struct {
+	sync.Mutex
+	result  Result
+	input   interface{}
+	seq     uint64
+	ruleset string
+	variant string
+	rule    Rule
+	// the rule was abandoned after a timeout, the values it puts are dropped
+	abandoned bool
+}
+
+func newBoundResult(ctx context.Context, result Result, ruleset string, input interface{}) *boundResult {
+	seq, _ := ctx.Value(inputSeqKey{}).(uint64)
+	return &boundResult{result: result, input: input, seq: seq, ruleset: ruleset}
+}
+
+func (b *boundResult) setRule(rule Rule) {
+	b.Lock()
+	b.rule = rule
+	b.Unlock()
+}
+
+func (b *boundResult) abandon() {
+	b.Lock()
+	b.abandoned = true
+	b.Unlock()
+}
+
+// Put receives a value from a rule and puts it on the parser's Result
+func (b *boundResult) Put(val interface{}, prevVal ...bool) bool {
+	b.Lock()
+	if b.abandoned {
+		b.Unlock()
+		return false
+	}
+
+	envelopeResult, ok := b.result.(EnvelopeResult)
+	if !ok {
+		b.Unlock()
+		return b.result.Put(val, prevVal...)
+	}
+
+	env := ResultEnvelope{
+		Input:    b.input,
+		Ruleset:  b.ruleset,
+		Rule:     b.rule.Name,
+		Priority: b.rule.Priority,
+		Variant:  b.variant,
+		Value:    val,
+		seq:      b.seq,
+	}
+	b.Unlock()
+
+	return envelopeResult.PutEnvelope(env, prevVal...)
+}
+
+// Get returns the parser's Result value
+func (b *boundResult) Get() interface{} {
+	return b.result.Get()
+}
+
 // ResultCallback holds the out channel
 type ResultCallback struct {
 	fn func(result interface{})
@@ -33,58 +123,311 @@ func NewResultCallback(fn func(interface{})) *ResultCallback {
 	return &ResultCallback{fn: fn}
 }
 
+// ResultQueueConfig sets the optional config for the ResultQueue
+type ResultQueueConfig struct {
+	// Ordered sends the results of the QueueExecutor in the order of the inputs which produced them.
+	// Values put by a rule are sent on the Get channel as a ResultEnvelope and the rule blocks until
+	// the value is received.
+	Ordered bool
+	// Envelope sends a ResultEnvelope instead of the value put by a rule.
+	Envelope bool
+}
+
 // ResultQueue holds the out channel
 type ResultQueue struct {
-	get chan interface{}
+	get    chan interface{}
+	config ResultQueueConfig
+	state  *resultQueueState
+}
+
+// resultQueueState tracks the values which were put but not yet received.
+type resultQueueState struct {
+	aborted int64 // first for 64-bit atomic alignment
+
+	sync.Mutex
+	stopped   bool
+	bounded   bool         // a put blocks until the value is received, the QueueExecutor bounds the pending values
+	window    *orderWindow // bounds the values of an ordered queue waiting for the earlier inputs
+	puts      sync.WaitGroup
+	done      chan struct{} // closed to abort undelivered values
+	abortOnce sync.Once
+	closeOnce sync.Once
+}
+
+// inputDone marks the end of the results of an input in an ordered ResultQueue
+type inputDone struct {
+	seq uint64
 }
 
 // Put receives a value and put's it on the parser's out channel
 func (q ResultQueue) Put(val interface{}, prevVal ...bool) bool {
-	//fmt.Println(val, prevVal)
 	if len(prevVal) > 0 {
 		if !prevVal[0] {
 			return false
 		}
 	}
 
-	go func(val interface{}) {
-		q.get <- val
-	}(val)
+	if q.config.Ordered || q.config.Envelope {
+		return q.put(ResultEnvelope{Value: val})
+	}
+
+	return q.put(val)
+}
+
+// PutEnvelope receives a value with the rule which produced it and put's it on the parser's out channel
+func (q ResultQueue) PutEnvelope(env ResultEnvelope, prevVal ...bool) bool {
+	if len(prevVal) > 0 {
+		if !prevVal[0] {
+			return false
+		}
+	}
+
+	if q.config.Ordered || q.config.Envelope {
+		return q.put(env)
+	}
+
+	return q.put(env.Value)
+}
+
+func (q ResultQueue) put(val interface{}) bool {
+	q.state.Lock()
+	if q.state.stopped {
+		q.state.Unlock()
+		return false
+	}
+	q.state.puts.Add(1)
+	bounded, window := q.state.bounded, q.state.window
+	q.state.Unlock()
+
+	if env, ok := val.(ResultEnvelope); ok && window != nil {
+		if !window.reserve(&env) {
+			atomic.AddInt64(&q.state.aborted, 1)
+			q.state.puts.Done()
+			return false
+		}
+		val = env
+	}
+
+	send := func(val interface{}) {
+		defer q.state.puts.Done()
+		select {
+		case q.get <- val:
+		case <-q.state.done:
+			atomic.AddInt64(&q.state.aborted, 1)
+		}
+	}
+
+	// an ordered queue must receive the values of an input before the input is done, and a bounded queue
+	// blocks the rule while the pending values are full
+	if q.config.Ordered || bounded {
+		send(val)
+	} else {
+		go send(val)
+	}
 
 	return true
 }
 
-type empty struct{}
-type quit struct{}
+// markInputDone marks the end of the results of the input seq.
+func (q ResultQueue) markInputDone(ctx context.Context, seq uint64) {
+	select {
+	case q.get <- inputDone{seq: seq}:
+	case <-q.state.done:
+	case <-ctx.Done():
+	}
+}
 
 // Get ...
 func (q ResultQueue) Get() interface{} {
 	return q.get
 }
 
-func (q ResultQueue) block() {
-	// nil channel blocks forever
-	//	blocks := make(chan interface{})
-block:
-	for {
-		select {
-		case v := <-q.get:
-			switch v.(type) {
-			case quit:
-				break block
-			default:
-				// is not quit, put it back
-				q.get <- v
-			}
+// bound makes the puts block until their value is received. The rules of an ordered queue putting the values
+// of later inputs block while maxWaiting values wait for the earlier inputs, unless maxWaiting is 0.
+func (q ResultQueue) bound(maxWaiting int) {
+	q.state.Lock()
+	q.state.bounded = true
+	if q.config.Ordered && maxWaiting > 0 && q.state.window == nil {
+		q.state.window = newOrderWindow(maxWaiting)
+	}
+	q.state.Unlock()
+}
+
+// stop rejects values put after it.
+func (q ResultQueue) stop() {
+	q.state.Lock()
+	q.state.stopped = true
+	q.state.Unlock()
+}
+
+// flush waits until the values put before stop are received.
+func (q ResultQueue) flush(ctx context.Context, receiverDone <-chan struct{}) error {
+	q.stop()
+
+	received := make(chan struct{})
+	go func() {
+		q.state.puts.Wait()
+		close(received)
+	}()
 
-		case q.get <- empty{}:
+	select {
+	case <-received:
+		return nil
+	case <-receiverDone:
+		select {
+		case <-received:
+			return nil
+		default:
+			return errors.New("result receiver stopped before all the results were received")
 		}
+	case <-ctx.Done():
+		return ctx.Err()
 	}
 }
 
+// abort drops the values which were not received and returns their count.
+func (q ResultQueue) abort() int64 {
+	q.stop()
+	q.state.abortOnce.Do(func() { close(q.state.done) })
+	q.state.Lock()
+	window := q.state.window
+	q.state.Unlock()
+	window.close()
+	q.state.puts.Wait()
+	return atomic.SwapInt64(&q.state.aborted, 0)
+}
+
+// closeGet closes the channel returned by Get. Must be called after abort.
+func (q ResultQueue) closeGet() {
+	q.state.closeOnce.Do(func() { close(q.get) })
+}
+
 // NewResultQueue returns a new ResultQueue
-func NewResultQueue() ResultQueue {
-	q := ResultQueue{get: make(chan interface{})}
-	go q.block()
-	return q
+func NewResultQueue(config ...ResultQueueConfig) ResultQueue {
+	cfg := ResultQueueConfig{}
+	if len(config) > 0 {
+		cfg = config[0]
+	}
+
+	return ResultQueue{
+		get:    make(chan interface{}),
+		config: cfg,
+		state:  &resultQueueState{done: make(chan struct{})},
+	}
+}
+
+// orderWindow bounds the values of the later inputs waiting in an ordered ResultQueue: the rules putting them
+// block while max values wait, the values of the next input are always received.
+type orderWindow struct {
+	sync.Mutex
+	cond    *sync.Cond
+	next    uint64
+	waiting int
+	max     int
+	closed  bool
+}
+
+func newOrderWindow(max int) *orderWindow {
+	w := &orderWindow{max: max}
+	w.cond = sync.NewCond(w)
+	return w
+}
+
+// reserve waits until the value of the envelope can be received and counts it if it waits for earlier inputs.
+// It returns false if the window was closed.
+func (w *orderWindow) reserve(env *ResultEnvelope) bool {
+	w.Lock()
+	defer w.Unlock()
+	for env.seq != w.next && w.waiting >= w.max && !w.closed {
+		w.cond.Wait()
+	}
+	if w.closed {
+		return false
+	}
+	if env.seq != w.next {
+		w.waiting++
+		env.reserved = true
+	}
+	return true
+}
+
+// release uncounts a released value.
+func (w *orderWindow) release(env ResultEnvelope) {
+	if w == nil || !env.reserved {
+		return
+	}
+	w.Lock()
+	w.waiting--
+	w.cond.Broadcast()
+	w.Unlock()
+}
+
+// advance receives the values of the next input.
+func (w *orderWindow) advance(next uint64) {
+	if w == nil {
+		return
+	}
+	w.Lock()
+	w.next = next
+	w.cond.Broadcast()
+	w.Unlock()
+}
+
+// close wakes the rules waiting to put their values.
+func (w *orderWindow) close() {
+	if w == nil {
+		return
+	}
+	w.Lock()
+	w.closed = true
+	w.cond.Broadcast()
+	w.Unlock()
+}
+
+// resultOrder releases the results of an ordered ResultQueue in the order of the inputs which produced them.
+type resultOrder struct {
+	next    uint64
+	waiting map[uint64][]interface{}
+	count   int // of the waiting results
+	done    map[uint64]bool
+	window  *orderWindow
+}
+
+func newResultOrder(window *orderWindow) *resultOrder {
+	return &resultOrder{
+		waiting: make(map[uint64][]interface{}),
+		done:    make(map[uint64]bool),
+		window:  window,
+	}
+}
+
+// add receives a ResultEnvelope or an inputDone and returns the results which can be sent.
+func (o *resultOrder) add(v interface{}) []interface{} {
+	switch tv := v.(type) {
+	case inputDone:
+		o.done[tv.seq] = true
+		var released []interface{}
+		for o.done[o.next] {
+			delete(o.done, o.next)
+			o.next++
+			for _, env := range o.waiting[o.next] {
+				o.window.release(env.(ResultEnvelope))
+			}
+			o.count -= len(o.waiting[o.next])
+			released = append(released, o.waiting[o.next]...)
+			delete(o.waiting, o.next)
+		}
+		o.window.advance(o.next)
+		return released
+	case ResultEnvelope:
+		if tv.seq == o.next {
+			o.window.release(tv)
+			return []interface{}{tv}
+		}
+		o.waiting[tv.seq] = append(o.waiting[tv.seq], tv)
+		o.count++
+		return nil
+	}
+
+	return []interface{}{v}
 }
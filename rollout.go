@@ -0,0 +1,88 @@
+package roulette
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"reflect"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// ErrRollout is reported for rulesets and rules skipped because the input is not in their rollout.
+var ErrRollout = errors.New("input is not in the rollout")
+
+// rolloutBuckets is the resolution of a rollout, a hundredth of a percent.
+const rolloutBuckets = 10000
+
+// bucket hashes the value to a bucket from 0 to n-1. A value is always in the same bucket, e.g.
+// lt (bucket .types.User.ID 100) 10 is true for 10% of the users.
+func bucket(val interface{}, n int) (int, error) {
+	if n < 1 {
+		return 0, fmt.Errorf("bucket count %d is not positive", n)
+	}
+	return hashBucket("", val, n), nil
+}
+
+func hashBucket(salt string, val interface{}, n int) int {
+	h := fnv.New32a()
+	h.Write([]byte(salt))
+	if v := indirectInterface(reflect.Indirect(reflect.ValueOf(val))); v.IsValid() {
+		fmt.Fprint(h, v.Interface())
+	}
+	return int(h.Sum32() % uint32(n))
+}
+
+// rollout includes a percentage of the inputs by the bucket of their bucketKey. The bucket is salted by the
+// name of the ruleset or rule, so that the inputs of the rollouts of different rulesets are independent.
+type rollout struct {
+	salt    string
+	buckets int // the buckets included
+	key     *template.Template
+}
+
+// newRollout parses the rollout percentage, e.g. 10% or 0.5%, and the bucketKey expression.
+func newRollout(salt, percentage, bucketKey string, funcs template.FuncMap) (*rollout, error) {
+	percent, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(percentage), "%"), 64)
+	if err != nil || percent < 0 || percent > 100 {
+		return nil, fmt.Errorf("rollout %q is not a percentage from 0%% to 100%%", percentage)
+	}
+
+	if strings.TrimSpace(bucketKey) == "" {
+		return nil, fmt.Errorf("rollout %s has no bucketKey", percentage)
+	}
+
+	key, err := parseBucketKey(bucketKey, funcs)
+	if err != nil {
+		return nil, err
+	}
+
+	return &rollout{salt: salt, buckets: int(percent * rolloutBuckets / 100), key: key}, nil
+}
+
+// includes evaluates the bucketKey on the values of the dataKey and returns true if its bucket is in the rollout.
+func (r *rollout) includes(valsData map[string]interface{}) (bool, error) {
+	b, err := keyBucket(r.key, r.salt, valsData)
+	return b < r.buckets, err
+}
+
+// parseBucketKey parses the bucketKey expression.
+func parseBucketKey(bucketKey string, funcs template.FuncMap) (*template.Template, error) {
+	key, err := template.New("bucketKey").Funcs(funcs).Parse("{{" + bucketKey + "}}")
+	if err != nil {
+		return nil, fmt.Errorf("bucketKey: %v", err)
+	}
+	return key, nil
+}
+
+// keyBucket evaluates the bucketKey on the values of the dataKey and returns its bucket out of rolloutBuckets.
+func keyBucket(key *template.Template, salt string, valsData map[string]interface{}) (int, error) {
+	var buf bytes.Buffer
+	err := key.Execute(&buf, valsData)
+	if err != nil {
+		return 0, err
+	}
+	return hashBucket(salt, buf.String(), rolloutBuckets), nil
+}
@@ -0,0 +1,80 @@
+package roulette
+
+import (
+	"log"
+	"strings"
+	"testing"
+)
+
+func TestBucket(t *testing.T) {
+	b, err := bucket("user-1", 100)
+	if err != nil || b < 0 || b >= 100 {
+		log.Fatalf("Expected a bucket from 0 to 99, got %d %v", b, err)
+	}
+
+	id := 42
+	if b1, _ := bucket(&id, 100); b1 != hashBucket("", 42, 100) {
+		log.Fatal("Expected a pointer to be bucketed by its value")
+	}
+
+	if _, err := bucket("user-1", 0); err == nil {
+		log.Fatal("Expected an error for no buckets")
+	}
+}
+
+func TestRollout(t *testing.T) {
+	parser, err := NewTextTemplateParserFiles([]string{"testrules/rules_rollout.xml"}, TextTemplateParserConfig{})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	included, tenPercent := 0, 0
+	for id := 0; id < 1000; id++ {
+		report := parser.Execute(&T2{B: id})
+
+		half, _ := report.Ruleset("halfRollout")
+		if !half.Skipped {
+			included++
+		} else if half.Err != ErrRollout {
+			log.Fatalf("Expected the ruleset to be skipped by its rollout, got %v", half.Err)
+		}
+
+		// the same input is always in the same bucket
+		if again, _ := parser.Execute(&T2{B: id}).Ruleset("halfRollout"); again.Skipped != half.Skipped {
+			log.Fatalf("Expected the rollout of %d to be deterministic", id)
+		}
+
+		rules, _ := report.Ruleset("ruleRollouts")
+		if rules.Rules[0].Status != RuleFired || rules.Rules[1].Err != ErrRollout {
+			log.Fatalf("Expected the rule rollouts of 100%% and 0%%, got %v", report)
+		}
+		if rules.Rules[2].Status == RuleFired {
+			tenPercent++
+		}
+	}
+
+	if included < 450 || included > 550 {
+		log.Fatalf("Expected about half of the inputs in the rollout, got %d", included)
+	}
+	if tenPercent < 70 || tenPercent > 130 {
+		log.Fatalf("Expected about 10%% of the inputs in bucket 0 to 9, got %d", tenPercent)
+	}
+}
+
+func TestBadRollout(t *testing.T) {
+	for _, attrs := range []string{`rollout="150%" bucketKey=".roulette.T2.B"`, `rollout="10%"`, `rollout="10%" bucketKey="(.roulette.T2.B"`} {
+		data := `<roulette><ruleset name="r" dataKey="TestData" filterTypes="roulette.T2" ` + attrs + `>
+			<rule name="rule" priority="1"><r>true</r></rule></ruleset></roulette>`
+		_, err := NewParser([]byte(data))
+		if err == nil || !strings.Contains(err.Error(), "rollout") && !strings.Contains(err.Error(), "bucketKey") {
+			log.Fatalf("Expected an invalid rollout for %s, got %v", attrs, err)
+		}
+	}
+
+	data := `<roulette><ruleset name="r" dataKey="TestData" filterTypes="roulette.T2">
+		<rule name="rule" priority="1" rollout="ten"><r>true</r></rule></ruleset></roulette>`
+	_, err := NewParser([]byte(data), TextTemplateParserConfig{Strict: true})
+	if err == nil || !strings.Contains(err.Error(), `ruleset r rule rule: rollout "ten" is not a percentage`) {
+		log.Fatalf("Expected the invalid rule rollout, got %v", err)
+	}
+}
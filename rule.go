@@ -1,6 +1,9 @@
 package roulette
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"reflect"
 	"sort"
@@ -8,11 +11,13 @@ import (
 	"strings"
 	"sync"
 	"text/template"
+	"time"
 )
 
-// Ruleset ...
+// Ruleset interface provides a method to execute the rules of the ruleset on the values.
 type Ruleset interface {
-	Execute(vals interface{}) error
+	Execute(vals interface{}) RulesetReport
+	ExecuteContext(ctx context.Context, vals interface{}) RulesetReport
 }
 
 type ruleConfig struct {
@@ -28,14 +33,80 @@ type ruleConfig struct {
 
 	template    *template.Template
 	templateErr error
+	specificity int
+
+	rollout *rollout
+
+	expr *exprRule // of the expression syntax
 }
 
 // Rule is a single rule expression. A rule expression is a valid go text/template
 type Rule struct {
-	Name     string `xml:"name,attr"`
-	Priority int    `xml:"priority,attr"`
-	Expr     string `xml:",innerxml"`
-	config   ruleConfig
+	Name     string `xml:"name,attr" json:"name" yaml:"name"`
+	Priority int    `xml:"priority,attr" json:"priority" yaml:"priority"`
+	Expr     string `xml:",innerxml" json:"expr,omitempty" yaml:"expr,omitempty"`
+	// When, Then and Else are the blocks of a structured rule, the <when>, <then> and <else> elements in xml.
+	// When must evaluate to a bool, Then is executed if it is true and Else if it is false. A structured rule
+	// has no Expr.
+	When string `xml:"-" json:"when,omitempty" yaml:"when,omitempty"`
+	Then string `xml:"-" json:"then,omitempty" yaml:"then,omitempty"`
+	Else string `xml:"-" json:"else,omitempty" yaml:"else,omitempty"`
+	// ActivationGroup names a group of rules of the ruleset of which only the first firing rule is executed.
+	ActivationGroup string `xml:"activationGroup,attr" json:"activationGroup,omitempty" yaml:"activationGroup,omitempty"`
+	// NoLoop keeps the changes of the rule from scheduling the rule again in the ForwardChaining mode.
+	NoLoop bool `xml:"noLoop,attr" json:"noLoop,omitempty" yaml:"noLoop,omitempty"`
+	// Rollout is the percentage of the inputs, bucketed by the hash of the BucketKey, the rule is evaluated for.
+	Rollout   string `xml:"rollout,attr" json:"rollout,omitempty" yaml:"rollout,omitempty"`
+	BucketKey string `xml:"bucketKey,attr" json:"bucketKey,omitempty" yaml:"bucketKey,omitempty"`
+
+	pos     sourcePos
+	variant string // the variant the rule belongs to, empty for the rules of the ruleset
+	config  ruleConfig
+}
+
+// the names of the templates of the then and else blocks, associated to the rule's template.
+const (
+	thenTemplate = "then"
+	elseTemplate = "else"
+)
+
+// text returns the expression and the blocks of the rule.
+func (r Rule) text() string {
+	return r.Expr + r.When + r.Then + r.Else
+}
+
+// condition returns the expression which must evaluate to a bool.
+func (r Rule) condition() string {
+	if r.When != "" {
+		return r.When
+	}
+	return r.Expr
+}
+
+// parseBlocks parses the then and else blocks as templates associated to the rule's template.
+func (r Rule) parseBlocks(tmpl *template.Template) error {
+	if r.When == "" {
+		if r.Then != "" || r.Else != "" {
+			return errors.New("rule has a then or else block but no when block")
+		}
+		return nil
+	}
+
+	if strings.TrimSpace(r.Expr) != "" {
+		return errors.New("rule has both an expression and a when block")
+	}
+
+	if r.Then != "" {
+		if _, err := tmpl.New(thenTemplate).Parse(r.Then); err != nil {
+			return err
+		}
+	}
+	if r.Else != "" {
+		if _, err := tmpl.New(elseTemplate).Parse(r.Else); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func (r Rule) hasType(typeName string) bool {
@@ -63,12 +134,28 @@ func (r Rule) isValid(vals interface{}) error {
 			return nil
 		}
 
+		// every fact counts as a value
+		for _, v := range typedVals {
+			if facts, ok := v.(Facts); ok {
+				size += len(facts) - 1
+			}
+		}
+
 		if size < len(r.config.expectTypes) {
 			return r.config.expectTypesErr
 		}
 
 		foundCount := 0
 		for _, v := range typedVals {
+			if facts, ok := v.(Facts); ok {
+				for typeName := range facts {
+					if r.hasType(typeName) {
+						foundCount++
+					}
+				}
+				continue
+			}
+
 			if reflect.ValueOf(v).Kind() == reflect.Ptr || reflect.ValueOf(v).Kind() == reflect.Interface {
 				typeName = reflect.TypeOf(v).Elem().String()
 			} else {
@@ -85,10 +172,13 @@ func (r Rule) isValid(vals interface{}) error {
 
 		}
 
-		if foundCount != len(r.config.expectTypes) {
+		if foundCount < len(r.config.expectTypes) {
 			return r.config.expectTypesErr
 		}
 
+	case Facts:
+		return r.isValid([]interface{}{vals})
+
 	default:
 		typeName := reflect.TypeOf(vals).Elem().String()
 		hasType := r.hasType(typeName)
@@ -104,24 +194,43 @@ type textTemplateRulesetConfig struct {
 	result         Result
 	filterTypesArr []string
 	workflowMatch  bool
+	flow           bool // executed by a flow only
+	ruleTimeout    time.Duration
+	rollout        *rollout
+	variants       *variants
+	resolver       ConflictResolver // of the collect strategy
 }
 
 // TextTemplateRuleset is a collection of rules for a valid go type
 type TextTemplateRuleset struct {
-	Name            string `xml:"name,attr"`
-	FilterTypes     string `xml:"filterTypes,attr"`
-	FilterStrict    bool   `xml:"filterStrict,attr"`
-	DataKey         string `xml:"dataKey,attr"`
-	ResultKey       string `xml:"resultKey,attr"`
-	Rules           []Rule `xml:"rule"`
-	PrioritiesCount string `xml:"prioritiesCount,attr"`
-	Workflow        string `xml:"workflow,attr"`
-
+	Name            string     `xml:"name,attr" json:"name" yaml:"name"`
+	FilterTypes     string     `xml:"filterTypes,attr" json:"filterTypes" yaml:"filterTypes"`
+	FilterStrict    bool       `xml:"filterStrict,attr" json:"filterStrict,omitempty" yaml:"filterStrict,omitempty"`
+	DataKey         string     `xml:"dataKey,attr" json:"dataKey" yaml:"dataKey"`
+	ResultKey       string     `xml:"resultKey,attr" json:"resultKey,omitempty" yaml:"resultKey,omitempty"`
+	Rules           []Rule     `xml:"rule" json:"rules" yaml:"rules"`
+	PrioritiesCount string     `xml:"prioritiesCount,attr" json:"prioritiesCount,omitempty" yaml:"prioritiesCount,omitempty"`
+	Workflow        string     `xml:"workflow,attr" json:"workflow,omitempty" yaml:"workflow,omitempty"`
+	Tests           []RuleTest `xml:"test" json:"tests,omitempty" yaml:"tests,omitempty"`
+	// Rollout is the percentage of the inputs, bucketed by the hash of the BucketKey, the ruleset is executed for.
+	// The BucketKey is an expression on the values of the dataKey, e.g. .types.User.ID
+	Rollout   string    `xml:"rollout,attr" json:"rollout,omitempty" yaml:"rollout,omitempty"`
+	BucketKey string    `xml:"bucketKey,attr" json:"bucketKey,omitempty" yaml:"bucketKey,omitempty"`
+	Variants  []Variant `xml:"variant" json:"variants,omitempty" yaml:"variants,omitempty"`
+	// Strategy is the conflict resolution of the rules, first or collect, Resolver names the resolver of the
+	// collect strategy and TieBreak orders the rules with the same priority, by declaration or specificity.
+	Strategy string `xml:"strategy,attr" json:"strategy,omitempty" yaml:"strategy,omitempty"`
+	Resolver string `xml:"resolver,attr" json:"resolver,omitempty" yaml:"resolver,omitempty"`
+	TieBreak string `xml:"tieBreak,attr" json:"tieBreak,omitempty" yaml:"tieBreak,omitempty"`
+
+	pos           sourcePos
 	config        textTemplateRulesetConfig
 	bytesBuf      *bytesPool
 	mapBuf        *mapPool
-	sameTypeIndex map[int]string
+	sameTypeIndex *sameTypeIndex
 	limit         int
+	coverage      *rulesetCoverage
+	table         *decisionTable // the rows of a decision table ruleset
 }
 
 // sort rules by priority
@@ -132,7 +241,10 @@ func (t TextTemplateRuleset) Swap(i, j int) {
 	t.Rules[i], t.Rules[j] = t.Rules[j], t.Rules[i]
 }
 func (t TextTemplateRuleset) Less(i, j int) bool {
-	return t.Rules[i].Priority < t.Rules[j].Priority
+	if t.Rules[i].Priority != t.Rules[j].Priority || t.TieBreak != TieBreakSpecificity {
+		return t.Rules[i].Priority < t.Rules[j].Priority
+	}
+	return t.Rules[i].config.specificity > t.Rules[j].config.specificity
 }
 
 func (t TextTemplateRuleset) isValid(vals interface{}) bool {
@@ -147,6 +259,13 @@ func (t TextTemplateRuleset) isValid(vals interface{}) bool {
 			return false
 		}
 
+		// every fact counts as a value
+		for _, v := range typedVals {
+			if facts, ok := v.(Facts); ok {
+				size += len(facts) - 1
+			}
+		}
+
 		if t.FilterStrict {
 			if size < len(t.config.filterTypesArr) {
 				return false
@@ -154,6 +273,22 @@ func (t TextTemplateRuleset) isValid(vals interface{}) bool {
 		}
 
 		for _, v := range vals.([]interface{}) {
+			if facts, ok := v.(Facts); ok {
+				matched := false
+				for typeName := range facts {
+					hasType := t.hasType(typeName)
+					if t.FilterStrict && !hasType {
+						return false
+					}
+					matched = matched || hasType
+				}
+
+				if matched {
+					return true
+				}
+				continue
+			}
+
 			if reflect.ValueOf(v).Kind() == reflect.Ptr || reflect.ValueOf(v).Kind() == reflect.Interface {
 				typeName = reflect.TypeOf(v).Elem().String()
 			} else {
@@ -174,6 +309,9 @@ func (t TextTemplateRuleset) isValid(vals interface{}) bool {
 
 		break
 
+	case Facts:
+		return t.isValid([]interface{}{vals})
+
 	default:
 
 		typeName := reflect.TypeOf(vals).Elem().String()
@@ -214,25 +352,86 @@ func (td *templateData) Set(key string, val interface{}) bool {
 	return true
 }
 
-func (t TextTemplateRuleset) getTemplateData(tmplData, userTmplData map[string]interface{}, vals interface{}) {
+// values returns a copy of the values set by the rules, nil if none was set.
+func (td *templateData) values() map[string]interface{} {
+	td.RLock()
+	defer td.RUnlock()
+	if len(td.data) == 0 {
+		return nil
+	}
+	values := make(map[string]interface{}, len(td.data))
+	for k, v := range td.data {
+		values[k] = v
+	}
+	return values
+}
+
+// sameTypeIndex caches the string form of the index used to refer to values of the same type.
+type sameTypeIndex struct {
+	sync.RWMutex
+	index map[int]string
+}
+
+func newSameTypeIndex() *sameTypeIndex {
+	index := make(map[int]string, len(defaultSameTypeIndex))
+	for k, v := range defaultSameTypeIndex {
+		index[k] = v
+	}
+	return &sameTypeIndex{index: index}
+}
+
+func (s *sameTypeIndex) get(i int) string {
+	s.RLock()
+	v, ok := s.index[i]
+	s.RUnlock()
+	if ok {
+		return v
+	}
+
+	v = strconv.Itoa(i)
+	s.Lock()
+	s.index[i] = v
+	s.Unlock()
+	return v
+}
+
+// getTemplateData fills tmplData with vals. valsData, nestedMap and userTmplData are owned by the caller and
+// must not be shared with other executions.
+func (t TextTemplateRuleset) getTemplateData(tmplData, valsData, nestedMap, userTmplData map[string]interface{}, vals interface{}, result Result) {
 
-	//fmt.Println("getTemplateData", reflect.TypeOf(vals))
 	// flatten multiple types in template map so that they can be referred by
 	// dataKey
 
-	valsData := t.mapBuf.get()
-	defer t.mapBuf.put(valsData)
 	// index array of same types
 	typeArrayIndex := make(map[string]int)
 
 	switch vals.(type) {
 	case []interface{}:
-		nestedMap := t.mapBuf.get()
-		defer t.mapBuf.put(nestedMap)
-
 		indexPkgTypeName := t.bytesBuf.get()
 		defer t.bytesBuf.put(indexPkgTypeName)
 
+		// addVal adds a value which can be referred by its package and type name
+		addVal := func(pkgPath, typeName string, val interface{}) {
+			indexPkgTypeName.WriteString(typeName)
+
+			_, ok := typeArrayIndex[typeName]
+			if !ok {
+				typeArrayIndex[typeName] = 0
+				nestedMap[typeName] = val
+
+			} else {
+				typeArrayIndex[typeName]++
+			}
+
+			indexPkgTypeName.WriteString(t.sameTypeIndex.get(typeArrayIndex[typeName]))
+			key := indexPkgTypeName.String()
+
+			nestedMap[key] = val
+			valsData[pkgPath] = nestedMap
+
+			indexPkgTypeName.Reset()
+		}
+
 		for i, val := range vals.([]interface{}) {
 
 			switch val.(type) {
@@ -250,8 +449,18 @@ func (t TextTemplateRuleset) getTemplateData(tmplData, userTmplData map[string]i
 				typeName = strings.Trim(typ, "]")
 				valsData[typeName+strconv.Itoa(i)] = val
 				break
+			case Facts:
+				for pkgTypeName, fact := range val.(Facts) {
+					periodIndex := strings.Index(pkgTypeName, ".")
+					addVal(pkgTypeName[:periodIndex], pkgTypeName[periodIndex+1:], fact)
+				}
+				break
+
 			case map[string]interface{}:
-				valsData = val.(map[string]interface{})
+				// copied so that the caller's map is not modified
+				for k, v := range val.(map[string]interface{}) {
+					valsData[k] = v
+				}
 				break
 
 			case bool, int, int32, int64, float32, float64:
@@ -274,136 +483,416 @@ func (t TextTemplateRuleset) getTemplateData(tmplData, userTmplData map[string]i
 					typeName = reflect.TypeOf(val).String()
 				}
 
-				indexPkgTypeName.WriteString(typeName)
-
-				_, ok := typeArrayIndex[typeName]
-				if !ok {
-					typeArrayIndex[typeName] = 0
-					nestedMap[typeName] = val
-
-				} else {
-					typeArrayIndex[typeName]++
-				}
-
-				nextIndex, ok := t.sameTypeIndex[typeArrayIndex[typeName]]
-				if !ok {
-					nextIndex = strconv.Itoa(typeArrayIndex[typeName])
-					t.sameTypeIndex[typeArrayIndex[typeName]] = nextIndex
-				}
-
-				indexPkgTypeName.WriteString(nextIndex)
-				key := indexPkgTypeName.String()
-
-				nestedMap[key] = val
-				valsData[pkgPath] = nestedMap
-
-				indexPkgTypeName.Reset()
+				addVal(pkgPath, typeName, val)
 			}
 
 		}
 
 		break
+	case Facts:
+		t.getTemplateData(tmplData, valsData, nestedMap, userTmplData, []interface{}{vals}, result)
+		return
 	default:
 		pkgTypeName := reflect.TypeOf(vals).Elem().String()
 		periodIndex := strings.Index(pkgTypeName, ".")
 		typeName := pkgTypeName[periodIndex+1:]
 		pkgPath := pkgTypeName[:periodIndex]
 
-		//fmt.Println("default", pkgPaths)
-		valsData[pkgPath] = map[string]interface{}{
-			typeName: vals,
-		}
+		nestedMap[typeName] = vals
+		valsData[pkgPath] = nestedMap
 	}
 
-	valsData[t.ResultKey] = t.config.result
+	valsData[t.ResultKey] = result
 
 	templateData := &templateData{data: userTmplData}
 	valsData["R"] = templateData
 	tmplData[t.DataKey] = valsData
 
-	//fmt.Println("map", tmplData)
+}
+
+// Execute executes the ruleset's rules in order of priority and reports the outcome of each rule.
+func (t TextTemplateRuleset) Execute(vals interface{}) RulesetReport {
+	return t.ExecuteContext(context.Background(), vals)
+}
+
+// ExecuteContext is like Execute but stops evaluating rules once the context is done. The remaining
+// rules are reported as skipped with the context's error.
+func (t TextTemplateRuleset) ExecuteContext(ctx context.Context, vals interface{}) RulesetReport {
+	report, _ := t.execute(ctx, vals, false, nil)
+	return report
+}
+
+// firedRules are the rules of a ruleset which fired, by index, and the rules which fired for their activation
+// groups.
+type firedRules struct {
+	rules  map[int]bool
+	groups map[string]int
+}
+
+func newFiredRules() *firedRules {
+	return &firedRules{rules: map[int]bool{}, groups: map[string]int{}}
+}
 
+func (f *firedRules) add(rule Rule, i int) {
+	f.rules[i] = true
+	if rule.ActivationGroup != "" {
+		f.groups[rule.ActivationGroup] = i
+	}
 }
 
-var mutex = &sync.RWMutex{}
+// skip returns why the rule is skipped after the rules which fired: prioritiesCount rules fired or another rule
+// of its activation group fired. A rule which fired before is evaluated again by forward chaining.
+func (f *firedRules) skip(t TextTemplateRuleset, i int) error {
+	if f.rules[i] {
+		return nil
+	}
+	if len(f.rules) >= t.limit {
+		return ErrPrioritiesCount
+	}
+	if rule, ok := f.groups[t.Rules[i].ActivationGroup]; ok && rule != i {
+		return ErrActivationGroup
+	}
+	return nil
+}
 
-// Execute ...
-func (t TextTemplateRuleset) Execute(vals interface{}) error {
+// execute executes the rules on the agenda, every rule without an agenda, and, when explaining, returns the
+// trace recorders of the executed rules.
+func (t TextTemplateRuleset) execute(ctx context.Context, vals interface{}, explain bool, agenda ruleAgenda) (RulesetReport, []*traceRecorder) {
+
+	report := RulesetReport{Name: t.Name}
 
 	if !t.config.workflowMatch {
-		//log.Infof("ruleset %s is not valid for the current parser %s %s", t.Name, t.Workflow)
-		return fmt.Errorf("ruleset %s is not valid for the current parser %s", t.Name, t.Workflow)
+		report.Skipped = true
+		report.Err = ErrWorkflowMismatch
+		return report, nil
 	}
 
 	if !t.isValid(vals) {
-		//	//log.Infof("invalid types %s skipping ruleset %s", types, t.Name)
-		return fmt.Errorf("invalid types %s skipping ruleset", t.Name)
+		report.Skipped = true
+		report.Err = ErrFilterTypes
+		return report, nil
 	}
 
-	mutex.Lock()
-	defer mutex.Unlock()
-	//	fmt.Println("types:", types)
+	// template data is per call so that rulesets can be executed concurrently. It is not returned
+	// to the pool if an abandoned rule might still be using it.
+	abandoned := false
 	tmplData := t.mapBuf.get()
+	valsData := t.mapBuf.get()
+	nestedMap := t.mapBuf.get()
 	userTmplData := t.mapBuf.get()
-	defer t.mapBuf.put(tmplData)
-	defer t.mapBuf.put(userTmplData)
-	t.getTemplateData(tmplData, userTmplData, vals)
+	defer func() {
+		if !abandoned {
+			t.mapBuf.putReset(tmplData)
+			t.mapBuf.putReset(valsData)
+			t.mapBuf.putReset(nestedMap)
+			t.mapBuf.putReset(userTmplData)
+		}
+	}()
+
+	var result Result
+	var bound *boundResult
+	if t.config.result != nil {
+		bound = newBoundResult(ctx, t.config.result, t.Name, vals)
+		result = bound
+	}
+
+	t.getTemplateData(tmplData, valsData, nestedMap, userTmplData, vals, result)
 
-	successCount := 0
+	// the values set by the previous forward chaining cycles
+	if agenda != nil {
+		for k, v := range agenda.values() {
+			userTmplData[k] = v
+		}
+	}
+
+	// abandon stops the results of an abandoned rule, which keeps running, and skips the rest of the ruleset
+	abandon := func() {
+		abandoned = true
+		if bound != nil {
+			bound.abandon()
+		}
+	}
+
+	if t.config.rollout != nil {
+		included, err := t.config.rollout.includes(valsData)
+		if err != nil || !included {
+			report.Skipped = true
+			report.Err = ErrRollout
+			if err != nil {
+				report.Err = fmt.Errorf("bucketKey: %v", err)
+			}
+			return report, nil
+		}
+	}
+
+	if t.config.variants != nil {
+		variant, err := t.config.variants.assign(valsData)
+		if err != nil {
+			report.Skipped = true
+			report.Err = fmt.Errorf("bucketKey: %v", err)
+			return report, nil
+		}
+
+		report.Variant = variant
+		if bound != nil {
+			bound.variant = variant
+		}
+	}
+
+	report.Rules = make([]RuleReport, len(t.Rules))
+	var matches []Match
+
+	// the rules fired by the previous forward chaining cycles count for prioritiesCount and activation groups
+	firing := newFiredRules()
+	if agenda != nil {
+		firing = agenda.fired()
+	}
+
+	var recorders []*traceRecorder
+	if explain {
+		recorders = make([]*traceRecorder, len(t.Rules))
+	}
+
+	// executeBlock executes the then or else block of a structured rule, if it has one
+	executeBlock := func(tmpl *template.Template, block string) error {
+		if tmpl = tmpl.Lookup(block); tmpl == nil {
+			return nil
+		}
+		_, err := t.executeRule(ctx, tmpl, tmplData)
+		if err == context.Canceled || err == context.DeadlineExceeded {
+			abandon()
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %v", block, err)
+		}
+		return nil
+	}
+
+	// fired counts a fired rule for prioritiesCount and its activation group
+	fired := func(i int, ruleReport *RuleReport) {
+		ruleReport.Status = RuleFired
+		firing.add(t.Rules[i], i)
+	}
 
 	for i := range t.Rules {
 
 		rule := t.Rules[i]
+		ruleReport := &report.Rules[i]
+		ruleReport.Name = rule.Name
+		ruleReport.Priority = rule.Priority
+
+		if bound != nil {
+			bound.setRule(rule)
+		}
+
+		if err := ctx.Err(); err != nil {
+			report.Err = err
+			ruleReport.Err = err
+			continue
+		}
+
+		if abandoned {
+			ruleReport.Err = ErrRuleAbandoned
+			continue
+		}
+
+		if agenda != nil && !agenda.scheduled(i) {
+			ruleReport.Err = errNotScheduled
+			continue
+		}
+
+		if rule.variant != "" && rule.variant != report.Variant {
+			ruleReport.Err = ErrVariant
+			continue
+		}
+
+		// n high priority rules successful, skip the rest
+		if err := firing.skip(t, i); err != nil {
+			ruleReport.Err = err
+			continue
+		}
 
-		////log.Infof("rule  %s", rule.Name)
 		if rule.config.noResultFunc {
-			//log.Infof("rule expression contains result func but no type Result interface was set %s", rule.Name)
+			ruleReport.Err = ErrNoResult
 			continue
 		}
 
 		// validate if one of the types exist in the expression.
 		err := rule.isValid(vals)
 		if err != nil {
-			//log.Infof("invalid rule %s, error: %v", rule.Name, err)
+			ruleReport.Err = err
 			continue
 		}
 
 		if rule.config.templateErr != nil {
-			//log.Infof("invalid rule %s, error: %v", rule.Name, rule.config.templateErr)
+			ruleReport.Status = RuleErrored
+			ruleReport.Err = rule.config.templateErr
 			continue
 		}
 
-		buf := t.bytesBuf.get()
-		defer t.bytesBuf.put(buf)
+		if rule.config.rollout != nil {
+			included, err := rule.config.rollout.includes(valsData)
+			if err != nil {
+				ruleReport.Status = RuleErrored
+				ruleReport.Err = fmt.Errorf("bucketKey: %v", err)
+				continue
+			}
+			if !included {
+				ruleReport.Err = ErrRollout
+				continue
+			}
+		}
 
-		err = rule.config.template.Execute(buf, tmplData)
-		if err != nil {
-			//log.Infof("invalid rule %s, error: %v", rule.Name, err)
+		if explain {
+			rule.config.template, recorders[i] = traceTemplate(rule.config.template, t.DataKey)
+		}
+
+		if agenda != nil {
+			agenda.evaluating(i)
+		}
+
+		res, err := t.executeRule(ctx, rule.config.template, tmplData)
+		if err == context.Canceled || err == context.DeadlineExceeded {
+			abandon()
+		}
+
+		var result, collected bool
+		if err == nil {
+			result, err = strconv.ParseBool(res)
+			if err != nil {
+				err = fmt.Errorf("rule output %q is not a bool", res)
+			}
+		}
+
+		if err == nil && rule.When != "" {
+			if result && t.config.resolver != nil {
+				collected = true
+			} else if result {
+				err = executeBlock(rule.config.template, thenTemplate)
+			} else {
+				err = executeBlock(rule.config.template, elseTemplate)
+			}
+		}
+
+		if agenda != nil {
+			agenda.evaluated(i)
+		}
+
+		switch {
+		case err != nil:
+			ruleReport.Status = RuleErrored
+			ruleReport.Err = err
+		case collected:
+			matches = append(matches, Match{Rule: rule.Name, Priority: rule.Priority, ActivationGroup: rule.ActivationGroup,
+				Specificity: rule.config.specificity, index: i, tmpl: rule.config.template})
+		case result:
+			fired(i, ruleReport)
+		default:
+			ruleReport.Status = RuleFalse
+		}
+
+	}
+
+	if len(matches) == 0 {
+		report.Values = valsData["R"].(*templateData).values()
+		return report, recorders
+	}
+
+	// the then blocks of the matches chosen by the resolver of the collect strategy
+	chosen := make([]bool, len(matches))
+	for _, m := range t.config.resolver.Resolve(matches) {
+		if m < 0 || m >= len(matches) || chosen[m] {
+			continue
+		}
+		chosen[m] = true
+
+		i := matches[m].index
+		rule := t.Rules[i]
+		ruleReport := &report.Rules[i]
+
+		if bound != nil {
+			bound.setRule(rule)
+		}
+
+		if err := ctx.Err(); err != nil {
+			report.Err = err
+			ruleReport.Err = err
 			continue
 		}
 
-		var result bool
+		if abandoned {
+			ruleReport.Err = ErrRuleAbandoned
+			continue
+		}
 
-		res := strings.TrimSpace(buf.String())
+		if err := firing.skip(t, i); err != nil {
+			ruleReport.Err = err
+			continue
+		}
+
+		if agenda != nil {
+			agenda.evaluating(i)
+		}
+
+		err := executeBlock(matches[m].tmpl, thenTemplate)
+
+		if agenda != nil {
+			agenda.evaluated(i)
+		}
 
-		result, err = strconv.ParseBool(res)
 		if err != nil {
-			////log.Infof("parse result error", err)
+			ruleReport.Status = RuleErrored
+			ruleReport.Err = err
 			continue
 		}
+		fired(i, ruleReport)
+	}
 
-		// n high priority rules successful, break
-		if result {
-			//log.Infof("rule passed %s", rule.Name)
-			successCount++
-			if successCount == t.limit {
-				break
-			}
-		} else {
-			//log.Infof("rule failed %s", rule.Name)
+	for m, match := range matches {
+		if !chosen[m] {
+			report.Rules[match.index].Err = ErrNotChosen
 		}
+	}
+
+	report.Values = valsData["R"].(*templateData).values()
+	return report, recorders
+}
 
+// executeRule executes a template of the rule and returns its trimmed output. If the context can be done or a rule
+// timeout is set, the template is executed in a separate goroutine which is abandoned once the context is done.
+// An abandoned template keeps running until it returns on its own.
+func (t TextTemplateRuleset) executeRule(ctx context.Context, tmpl *template.Template, tmplData map[string]interface{}) (string, error) {
+
+	if ctx.Done() == nil && t.config.ruleTimeout <= 0 {
+		buf := t.bytesBuf.get()
+		defer t.bytesBuf.put(buf)
+		err := tmpl.Execute(buf, tmplData)
+		return strings.TrimSpace(buf.String()), err
 	}
 
-	return nil
+	if t.config.ruleTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, t.config.ruleTimeout)
+		defer cancel()
+	}
+
+	type output struct {
+		res string
+		err error
+	}
+
+	done := make(chan output, 1)
+	go func() {
+		// not pooled, the buffer outlives an abandoned rule.
+		buf := new(bytes.Buffer)
+		err := tmpl.Execute(buf, tmplData)
+		done <- output{res: strings.TrimSpace(buf.String()), err: err}
+	}()
+
+	select {
+	case out := <-done:
+		return out.res, out.err
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
 }
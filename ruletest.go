@@ -0,0 +1,375 @@
+package roulette
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// TestValue is a json value of a rule test. In xml it is the json text of the element or attribute.
+type TestValue json.RawMessage
+
+// UnmarshalXML reads the json text of the element.
+func (v *TestValue) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	var text string
+	err := d.DecodeElement(&text, &start)
+	if err != nil {
+		return err
+	}
+	return v.set([]byte(strings.TrimSpace(text)))
+}
+
+// MarshalXML writes the json text of the element.
+func (v TestValue) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	return e.EncodeElement(string(v), start)
+}
+
+// UnmarshalXMLAttr reads the json text of the attribute.
+func (v *TestValue) UnmarshalXMLAttr(attr xml.Attr) error {
+	return v.set([]byte(strings.TrimSpace(attr.Value)))
+}
+
+// MarshalXMLAttr writes the json text of the attribute.
+func (v TestValue) MarshalXMLAttr(name xml.Name) (xml.Attr, error) {
+	return xml.Attr{Name: name, Value: string(v)}, nil
+}
+
+// UnmarshalJSON keeps the json value.
+func (v *TestValue) UnmarshalJSON(data []byte) error {
+	return v.set(data)
+}
+
+// MarshalJSON returns the json value.
+func (v TestValue) MarshalJSON() ([]byte, error) {
+	if len(v) == 0 {
+		return []byte("null"), nil
+	}
+	return v, nil
+}
+
+// UnmarshalYAML converts the yaml value to json.
+func (v *TestValue) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var val interface{}
+	err := unmarshal(&val)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(yamlToJSON(val))
+	if err != nil {
+		return err
+	}
+	return v.set(data)
+}
+
+// MarshalYAML returns the decoded json value.
+func (v TestValue) MarshalYAML() (interface{}, error) {
+	if len(v) == 0 {
+		return nil, nil
+	}
+
+	var val interface{}
+	err := json.Unmarshal(v, &val)
+	return val, err
+}
+
+func (v *TestValue) set(data []byte) error {
+	if len(data) > 0 && !json.Valid(data) {
+		return fmt.Errorf("test value %s is not valid json", data)
+	}
+	*v = append((*v)[:0], data...)
+	return nil
+}
+
+// yamlToJSON converts the maps decoded by yaml to maps which can be encoded to json.
+func yamlToJSON(val interface{}) interface{} {
+	switch v := val.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			m[fmt.Sprint(k)] = yamlToJSON(e)
+		}
+		return m
+	case []interface{}:
+		for i, e := range v {
+			v[i] = yamlToJSON(e)
+		}
+	}
+	return val
+}
+
+// TestExpect is the expected value of a field of an input after the rules are executed, e.g. the field
+// types.Person.Age.
+type TestExpect struct {
+	Field string    `xml:"field,attr" json:"field" yaml:"field"`
+	Value TestValue `xml:"value,attr" json:"value" yaml:"value"`
+}
+
+// RuleTest is a <test> of a ruleset. Its facts are executed by the ruleset alone and the outcome is compared
+// with the rules which are expected to fire, the values expected to be put to the result in order and the
+// expected field values. Only the expectations which are set are compared, an empty <fired/> or
+// <result/> expects none. A null result is the same as an empty one.
+//
+//	<test name="adult">
+//	    <facts>{"types.Person": {"Name": "a", "Age": 30}}</facts>
+//	    <fired>adult</fired>
+//	    <result>"a"</result>
+//	    <expect field="types.Person.Age" value="30"/>
+//	</test>
+//
+// The facts of registered types are decoded to the type, so that the rules can call its methods.
+type RuleTest struct {
+	Name    string       `xml:"name,attr" json:"name" yaml:"name"`
+	Facts   TestValue    `xml:"facts" json:"facts" yaml:"facts"`
+	Fired   []string     `xml:"fired" json:"fired,omitempty" yaml:"fired,omitempty"`
+	Results []TestValue  `xml:"result" json:"results,omitempty" yaml:"results,omitempty"`
+	Expect  []TestExpect `xml:"expect" json:"expect,omitempty" yaml:"expect,omitempty"`
+
+	pos sourcePos
+}
+
+// RuleTestResult is the outcome of a rule test.
+type RuleTestResult struct {
+	Pos      string // file:line of the test. lines are known for xml rules
+	Ruleset  string
+	Test     string
+	Report   RulesetReport
+	Failures []string
+}
+
+// Passed returns true if the outcome of the test was as expected.
+func (r RuleTestResult) Passed() bool {
+	return len(r.Failures) == 0
+}
+
+func (r RuleTestResult) String() string {
+	status := "ok"
+	if !r.Passed() {
+		status = "FAIL"
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%s %s/%s", status, r.Ruleset, r.Test)
+	if r.Pos != "" {
+		fmt.Fprintf(&buf, " (%s)", r.Pos)
+	}
+	for _, failure := range r.Failures {
+		fmt.Fprintf(&buf, "\n    %s", failure)
+	}
+	return buf.String()
+}
+
+// RunRuleTests runs the tests of the parser's rulesets. The rulesets are tested whatever their workflow and
+// the puts of the rules go to the test instead of the parser's Result. Only a TextTemplateParser or a
+// ReloadingParser can be tested, the rules of the expr syntax return ErrUnsupportedSyntax.
+func RunRuleTests(parser Parser) ([]RuleTestResult, error) {
+	switch p := parser.(type) {
+	case TextTemplateParser:
+		return p.runTests(), nil
+	case *ReloadingParser:
+		return RunRuleTests(p.Parser())
+	case ExprParser:
+		return nil, ErrUnsupportedSyntax
+	}
+	return nil, fmt.Errorf("parser %T cannot run rule tests", parser)
+}
+
+func (p TextTemplateParser) runTests() []RuleTestResult {
+	var results []RuleTestResult
+	for _, ruleset := range p.xml.Rulesets {
+		for _, test := range ruleset.Tests {
+			results = append(results, p.runTest(ruleset, test))
+		}
+	}
+	return results
+}
+
+func (p TextTemplateParser) runTest(ruleset TextTemplateRuleset, test RuleTest) RuleTestResult {
+	result := RuleTestResult{Pos: test.pos.String(), Ruleset: ruleset.Name, Test: test.Name}
+	failf := func(format string, args ...interface{}) {
+		result.Failures = append(result.Failures, fmt.Sprintf(format, args...))
+	}
+
+	vals, inputs, err := p.testVals(test.Facts)
+	if err != nil {
+		failf("facts: %v", err)
+		return result
+	}
+
+	var puts []interface{}
+	ruleset.config.workflowMatch = true
+	ruleset.config.result = NewResultCallback(func(val interface{}) {
+		puts = append(puts, val)
+	})
+
+	// the rules may have been compiled without a result
+	ruleset.Rules = append([]Rule(nil), ruleset.Rules...)
+	for i := range ruleset.Rules {
+		ruleset.Rules[i].config.noResultFunc = false
+	}
+
+	result.Report = ruleset.Execute(vals)
+	if result.Report.Skipped {
+		failf("ruleset skipped: %v", result.Report.Err)
+		return result
+	}
+
+	for _, rule := range result.Report.Rules {
+		if rule.Status == RuleErrored {
+			failf("rule %s errored: %v", rule.Name, rule.Err)
+		}
+	}
+
+	if test.Fired != nil {
+		var expected []string
+		for _, name := range test.Fired {
+			if name = strings.TrimSpace(name); name != "" {
+				expected = append(expected, name)
+			}
+		}
+
+		fired := result.Report.Fired()
+		sort.Strings(expected)
+		sort.Strings(fired)
+		if strings.Join(fired, ",") != strings.Join(expected, ",") {
+			failf("fired %v, expected %v", fired, expected)
+		}
+	}
+
+	if test.Results != nil {
+		var expected []interface{}
+		for _, v := range test.Results {
+			if len(v) > 0 && string(v) != "null" {
+				expected = append(expected, v)
+			}
+		}
+
+		if !jsonEqual(puts, expected) {
+			failf("put %s, expected %s", jsonString(puts), jsonString(expected))
+		}
+	}
+
+	for _, expect := range test.Expect {
+		actual, err := fieldValue(inputs, expect.Field)
+		if err != nil {
+			failf("%s: %v", expect.Field, err)
+			continue
+		}
+
+		if !jsonEqual(actual, expect.Value) {
+			failf("%s is %s, expected %s", expect.Field, jsonString(actual), expect.Value)
+		}
+	}
+
+	return result
+}
+
+// testVals returns the values to execute for the facts, sorted by type name, and the values by type name.
+// The facts of registered types are decoded to a pointer to the type.
+func (p TextTemplateParser) testVals(data TestValue) ([]interface{}, map[string]interface{}, error) {
+	var raw map[string]json.RawMessage
+	err := json.Unmarshal(data, &raw)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	facts, err := ParseFacts(data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	typeNames := make([]string, 0, len(raw))
+	for typeName := range raw {
+		typeNames = append(typeNames, typeName)
+	}
+	sort.Strings(typeNames)
+
+	var vals []interface{}
+	inputs := make(map[string]interface{}, len(raw))
+	for _, typeName := range typeNames {
+		typ, ok := p.config.Types[typeName]
+		if !ok || typ == nil {
+			inputs[typeName] = facts[typeName]
+			continue
+		}
+
+		val := reflect.New(typ.Elem()).Interface()
+		err := json.Unmarshal(raw[typeName], val)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %v", typeName, err)
+		}
+
+		delete(facts, typeName)
+		inputs[typeName] = val
+		vals = append(vals, val)
+	}
+
+	if len(facts) > 0 {
+		vals = append(vals, facts)
+	}
+
+	return vals, inputs, nil
+}
+
+// fieldValue returns the value of the field path, e.g. types.Person.Address.City, of the inputs.
+func fieldValue(inputs map[string]interface{}, field string) (interface{}, error) {
+	parts := strings.Split(field, ".")
+	if len(parts) < 2 {
+		return nil, fmt.Errorf("field is not a package and type name followed by fields")
+	}
+
+	val, ok := inputs[parts[0]+"."+parts[1]]
+	if !ok {
+		return nil, fmt.Errorf("no facts of type %s.%s", parts[0], parts[1])
+	}
+
+	v := reflect.ValueOf(val)
+	for _, name := range parts[2:] {
+		for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+			v = v.Elem()
+		}
+
+		switch v.Kind() {
+		case reflect.Struct:
+			f := v.FieldByName(name)
+			if !f.IsValid() || !f.CanInterface() {
+				return nil, fmt.Errorf("%s has no exported field %s", v.Type(), name)
+			}
+			v = f
+		case reflect.Map:
+			f := v.MapIndex(reflect.ValueOf(name))
+			if !f.IsValid() {
+				return nil, fmt.Errorf("no field %s", name)
+			}
+			v = f
+		default:
+			return nil, fmt.Errorf("can't get field %s of %s", name, v.Type())
+		}
+	}
+
+	if !v.IsValid() {
+		return nil, nil
+	}
+	return v.Interface(), nil
+}
+
+// jsonEqual compares the json encodings of the values.
+func jsonEqual(a, b interface{}) bool {
+	var av, bv interface{}
+	if json.Unmarshal([]byte(jsonString(a)), &av) != nil || json.Unmarshal([]byte(jsonString(b)), &bv) != nil {
+		return false
+	}
+	return reflect.DeepEqual(av, bv)
+}
+
+func jsonString(v interface{}) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprintf("%v", v)
+	}
+	return string(data)
+}
@@ -0,0 +1,68 @@
+package roulette
+
+import (
+	"log"
+	"reflect"
+	"testing"
+)
+
+func TestRunRuleTests(t *testing.T) {
+	config := TextTemplateParserConfig{Types: NewTypeRegistry(T2{})}
+	config.Types.RegisterFacts("types.Person")
+	parser, err := NewTextTemplateParserFiles([]string{"testrules/rules_tests.xml"}, config)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	results, err := RunRuleTests(parser)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(results) != 4 {
+		log.Fatalf("Expected 4 test results, got %d", len(results))
+	}
+
+	for _, result := range results[:3] {
+		if !result.Passed() {
+			log.Fatalf("Expected the test to pass, got %s", result)
+		}
+	}
+
+	expected := []string{
+		"fired [setA], expected [adult]",
+		"put [5], expected [6]",
+		"roulette.T2.A is 5, expected 1",
+		"roulette.T2.C: roulette.T2 has no exported field C",
+	}
+	failing := results[3]
+	if failing.Pos != "testrules/rules_tests.xml:33" || !reflect.DeepEqual(failing.Failures, expected) {
+		log.Fatalf("Expected failures %q at line 33, got %s", expected, failing)
+	}
+
+	// the tests are kept by the other formats
+	for _, format := range []RuleFormat{FormatJSON, FormatYAML, FormatXML} {
+		converted, err := ConvertRules(readFile("testrules/rules_tests.xml"), FormatXML, format)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		parser, err := newFormatParser(converted, format, []TextTemplateParserConfig{config})
+		if err != nil {
+			log.Fatalf("%s: %v", format, err)
+		}
+
+		results, err := RunRuleTests(parser)
+		if err != nil {
+			log.Fatalf("%s: %v", format, err)
+		}
+
+		var passed []bool
+		for _, result := range results {
+			passed = append(passed, result.Passed())
+		}
+
+		if !reflect.DeepEqual(passed, []bool{true, true, true, false}) {
+			log.Fatalf("%s: expected the same test results, got %v", format, passed)
+		}
+	}
+}
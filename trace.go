@@ -0,0 +1,418 @@
+package roulette
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"text/template"
+	"text/template/parse"
+)
+
+// the funcs called by the commands added to the traced rule templates
+const (
+	traceArgFunc    = "__arg"
+	traceReturnFunc = "__return"
+)
+
+// Trace explains an execution: the stages of the pipelines of the evaluated rules with their arguments and
+// return values, e.g. le .types.Person.Vacations 5 -> false.
+type Trace struct {
+	Rulesets []RulesetTrace
+}
+
+// RulesetTrace is the trace of a ruleset.
+type RulesetTrace struct {
+	Name    string
+	Variant string
+	Skipped bool
+	Err     error // why the ruleset was skipped
+	Rules   []RuleTrace
+}
+
+// RuleTrace is the outcome of a rule and the stages of its pipelines in order of execution.
+type RuleTrace struct {
+	RuleReport
+	Stages []TraceStage
+}
+
+// TraceStage is a command of a rule pipeline: a function or method call, a field or a constant.
+type TraceStage struct {
+	Name   string     // the function, method or field, e.g. le or .result.Put
+	Args   []TraceArg // the last is prevVal if the value of the previous stage was piped to the stage
+	Return interface{}
+	Err    error        // the error of the rule if the stage did not return
+	Stages []TraceStage // the stages of the pipelines in the arguments
+}
+
+// TraceArg is an argument of a stage.
+type TraceArg struct {
+	Expr  string // e.g. .types.Person.Age, (eq .X 1) or prevVal
+	Value interface{}
+	Const bool // the argument is a constant
+}
+
+// Explain executes the parser's rulesets on the values like Execute and traces every stage of the pipelines
+// of the evaluated rules, e.g. to find out why a rule did not fire. The rules put results and call setters as
+// they do when executed. Only a TextTemplateParser or a ReloadingParser can be explained, the rules of the
+// expr syntax return ErrUnsupportedSyntax.
+func Explain(parser Parser, vals interface{}) (Trace, error) {
+	return ExplainContext(context.Background(), parser, vals)
+}
+
+// ExplainContext is like Explain but executes the rulesets like ExecuteContext: the rulesets are selected by
+// the workflow of the context and the rules are not evaluated once the context is done.
+func ExplainContext(ctx context.Context, parser Parser, vals interface{}) (Trace, error) {
+	switch p := parser.(type) {
+	case TextTemplateParser:
+		return p.explain(ctx, vals), nil
+	case *ReloadingParser:
+		return ExplainContext(ctx, p.Parser(), vals)
+	case ExprParser:
+		return Trace{}, ErrUnsupportedSyntax
+	}
+	return Trace{}, fmt.Errorf("parser %T cannot be explained", parser)
+}
+
+// explain traces the rulesets executed by executeRulesets, the rulesets of flows are skipped with ErrFlow.
+func (p TextTemplateParser) explain(ctx context.Context, vals interface{}) Trace {
+	var trace Trace
+	workflowMatches := p.workflowMatches(ctx)
+	for i, ruleset := range p.xml.Rulesets {
+		if err := ctx.Err(); err != nil {
+			trace.Rulesets = append(trace.Rulesets, RulesetTrace{Name: ruleset.Name, Skipped: true, Err: err})
+			continue
+		}
+		if ruleset.config.flow {
+			trace.Rulesets = append(trace.Rulesets, RulesetTrace{Name: ruleset.Name, Skipped: true, Err: ErrFlow})
+			continue
+		}
+		if workflowMatches != nil {
+			ruleset.config.workflowMatch = workflowMatches[i]
+		}
+
+		report, recorders := ruleset.execute(ctx, vals, true, nil)
+
+		rulesetTrace := RulesetTrace{Name: report.Name, Variant: report.Variant, Skipped: report.Skipped, Err: report.Err}
+		for i, rule := range report.Rules {
+			ruleTrace := RuleTrace{RuleReport: rule}
+			if recorders[i] != nil {
+				ruleTrace.Stages = recorders[i].result(rule.Err)
+			}
+			rulesetTrace.Rules = append(rulesetTrace.Rules, ruleTrace)
+		}
+
+		trace.Rulesets = append(trace.Rulesets, rulesetTrace)
+	}
+	return trace
+}
+
+// Rule returns the trace of the rule of the ruleset.
+func (t Trace) Rule(ruleset, rule string) (RuleTrace, bool) {
+	for _, r := range t.Rulesets {
+		if r.Name != ruleset {
+			continue
+		}
+		for _, ruleTrace := range r.Rules {
+			if ruleTrace.Name == rule {
+				return ruleTrace, true
+			}
+		}
+	}
+	return RuleTrace{}, false
+}
+
+// String prints the trace as a tree. The stages of the pipelines in the arguments of a stage and the values
+// of its arguments, other than constants, are printed below it.
+func (t Trace) String() string {
+	var buf bytes.Buffer
+	for _, ruleset := range t.Rulesets {
+		if ruleset.Skipped {
+			fmt.Fprintf(&buf, "ruleset %s: skipped: %v\n", ruleset.Name, ruleset.Err)
+			continue
+		}
+		fmt.Fprintf(&buf, "ruleset %s:\n", rulesetName(ruleset.Name, ruleset.Variant))
+		for _, rule := range ruleset.Rules {
+			if rule.Err != nil {
+				fmt.Fprintf(&buf, "  rule %s (priority %d): %s: %v\n", rule.Name, rule.Priority, rule.Status, rule.Err)
+			} else {
+				fmt.Fprintf(&buf, "  rule %s (priority %d): %s\n", rule.Name, rule.Priority, rule.Status)
+			}
+			writeStages(&buf, rule.Stages, "    ")
+		}
+	}
+	return buf.String()
+}
+
+func writeStages(buf *bytes.Buffer, stages []TraceStage, indent string) {
+	for _, stage := range stages {
+		buf.WriteString(indent + stage.Name)
+		for _, arg := range stage.Args {
+			buf.WriteString(" " + arg.Expr)
+		}
+		if stage.Err != nil {
+			buf.WriteString(" -> failed\n")
+		} else {
+			fmt.Fprintf(buf, " -> %s\n", traceValue(stage.Return))
+		}
+
+		writeStages(buf, stage.Stages, indent+"    ")
+		for _, arg := range stage.Args {
+			if !arg.Const {
+				fmt.Fprintf(buf, "%s    %s = %s\n", indent, arg.Expr, traceValue(arg.Value))
+			}
+		}
+	}
+}
+
+func traceValue(val interface{}) string {
+	if s, ok := val.(string); ok {
+		return strconv.Quote(s)
+	}
+	return fmt.Sprint(val)
+}
+
+type traceStageInfo struct {
+	stage  TraceStage // the name and the arguments, with the values of the constants
+	parent int        // the stage the pipeline is an argument of, -1 for none
+	next   int        // the stage the value is piped to, -1 for none
+}
+
+// traceRecorder records the stages of the executions of a traced template.
+type traceRecorder struct {
+	mu      sync.Mutex
+	dataKey string
+	stages  []traceStageInfo
+	open    map[int]*TraceStage  // the stages whose arguments are being evaluated
+	done    map[int][]TraceStage // the stages which returned by parent, -1 for the top level
+}
+
+// traceTemplate returns a copy of the template whose stages are recorded by the returned recorder.
+func traceTemplate(tmpl *template.Template, dataKey string) (*template.Template, *traceRecorder) {
+	rec := &traceRecorder{dataKey: dataKey, open: make(map[int]*TraceStage), done: make(map[int][]TraceStage)}
+
+	// the parse trees are shared by clones
+	traced, err := tmpl.Clone()
+	if err != nil {
+		return tmpl, rec
+	}
+
+	templates := traced.Templates()
+	sort.Slice(templates, func(i, j int) bool { return templates[i].Name() < templates[j].Name() })
+
+	for _, t := range templates {
+		if t.Tree == nil {
+			continue
+		}
+
+		tree := t.Tree.Copy()
+		walkNodes(tree.Root, func(node parse.Node) {
+			switch n := node.(type) {
+			case *parse.ActionNode:
+				rec.tracePipe(n.Pipe, -1)
+			case *parse.IfNode:
+				rec.tracePipe(n.Pipe, -1)
+			case *parse.WithNode:
+				rec.tracePipe(n.Pipe, -1)
+			case *parse.RangeNode:
+				rec.tracePipe(n.Pipe, -1)
+			case *parse.TemplateNode:
+				rec.tracePipe(n.Pipe, -1)
+			}
+		})
+
+		_, err := traced.AddParseTree(t.Name(), tree)
+		if err != nil {
+			return tmpl, rec
+		}
+	}
+
+	traced.Funcs(template.FuncMap{
+		traceArgFunc:    rec.arg,
+		traceReturnFunc: rec.ret,
+		// explaining is not covered
+		coverFunc: func(probe int) string { return "" },
+	})
+
+	return traced, rec
+}
+
+// tracePipe adds the stages of the pipeline. Every command is followed by {{__return id}} and its arguments,
+// other than constants, are evaluated by (__arg id i arg), so that they are recorded.
+func (r *traceRecorder) tracePipe(pipe *parse.PipeNode, parent int) {
+	if pipe == nil {
+		return
+	}
+
+	// the data of the ruleset, e.g. with .TestData, is not traced
+	if len(pipe.Cmds) == 1 && len(pipe.Cmds[0].Args) == 1 {
+		switch n := pipe.Cmds[0].Args[0].(type) {
+		case *parse.DotNode:
+			return
+		case *parse.FieldNode:
+			if len(n.Ident) == 1 && n.Ident[0] == r.dataKey {
+				return
+			}
+		}
+	}
+
+	cmds := make([]*parse.CommandNode, 0, 2*len(pipe.Cmds))
+	prev := -1
+	for _, cmd := range pipe.Cmds {
+		id := len(r.stages)
+		info := traceStageInfo{stage: TraceStage{Name: cmd.Args[0].String()}, parent: parent, next: -1}
+		for _, arg := range cmd.Args[1:] {
+			expr := arg.String()
+			if _, ok := arg.(*parse.PipeNode); ok {
+				expr = "(" + expr + ")"
+			}
+			val, isConst := constValue(arg)
+			info.stage.Args = append(info.stage.Args, TraceArg{Expr: expr, Value: val, Const: isConst})
+		}
+		if prev >= 0 {
+			r.stages[prev].next = id
+			info.stage.Args = append(info.stage.Args, TraceArg{Expr: "prevVal"})
+		}
+		r.stages = append(r.stages, info)
+
+		r.traceArg(cmd.Args[0], id)
+		for i := 1; i < len(cmd.Args); i++ {
+			if info.stage.Args[i-1].Const {
+				continue
+			}
+			r.traceArg(cmd.Args[i], id)
+			cmd.Args[i] = &parse.PipeNode{
+				NodeType: parse.NodePipe,
+				Cmds:     []*parse.CommandNode{traceCommand(traceArgFunc, id, i-1, cmd.Args[i])},
+			}
+		}
+
+		cmds = append(cmds, cmd, traceCommand(traceReturnFunc, id))
+		prev = id
+	}
+	pipe.Cmds = cmds
+}
+
+// traceArg adds the stages of the pipelines of the argument.
+func (r *traceRecorder) traceArg(node parse.Node, parent int) {
+	switch n := node.(type) {
+	case *parse.PipeNode:
+		r.tracePipe(n, parent)
+	case *parse.ChainNode:
+		r.traceArg(n.Node, parent)
+	}
+}
+
+// traceCommand returns the command {{name id [i] [arg]}}.
+func traceCommand(name string, id int, args ...interface{}) *parse.CommandNode {
+	cmd := &parse.CommandNode{
+		NodeType: parse.NodeCommand,
+		Args:     []parse.Node{&parse.IdentifierNode{NodeType: parse.NodeIdentifier, Ident: name}, numberNode(id)},
+	}
+	for _, arg := range args {
+		switch a := arg.(type) {
+		case int:
+			cmd.Args = append(cmd.Args, numberNode(a))
+		case parse.Node:
+			cmd.Args = append(cmd.Args, a)
+		}
+	}
+	return cmd
+}
+
+func numberNode(n int) *parse.NumberNode {
+	return &parse.NumberNode{NodeType: parse.NodeNumber, IsInt: true, Int64: int64(n), Text: strconv.Itoa(n)}
+}
+
+// constValue returns the value of a constant argument.
+func constValue(node parse.Node) (interface{}, bool) {
+	switch n := node.(type) {
+	case *parse.BoolNode:
+		return n.True, true
+	case *parse.StringNode:
+		return n.Text, true
+	case *parse.NilNode:
+		return nil, true
+	case *parse.NumberNode:
+		switch {
+		case n.IsInt:
+			return n.Int64, true
+		case n.IsUint:
+			return n.Uint64, true
+		case n.IsFloat:
+			return n.Float64, true
+		}
+		return n.Complex128, true
+	}
+	return nil, false
+}
+
+func (r *traceRecorder) openStage(id int) *TraceStage {
+	stage, ok := r.open[id]
+	if !ok {
+		s := r.stages[id].stage
+		s.Args = append([]TraceArg(nil), s.Args...)
+		stage = &s
+		r.open[id] = stage
+	}
+	return stage
+}
+
+// arg records the value of the argument i of the stage.
+func (r *traceRecorder) arg(id, i int, val interface{}) interface{} {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.openStage(id).Args[i].Value = val
+	return val
+}
+
+// ret records the value returned by the stage, which is piped to the next stage.
+func (r *traceRecorder) ret(id int, val interface{}) interface{} {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stage := r.openStage(id)
+	delete(r.open, id)
+	stage.Return = val
+	stage.Stages = r.done[id]
+	delete(r.done, id)
+
+	parent := r.stages[id].parent
+	r.done[parent] = append(r.done[parent], *stage)
+
+	if next := r.stages[id].next; next >= 0 {
+		args := r.openStage(next).Args
+		args[len(args)-1].Value = val
+	}
+	return val
+}
+
+// result returns the recorded stages. The stages which did not return failed with the rule's error.
+func (r *traceRecorder) result(err error) []TraceStage {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// the stages of the arguments are numbered after the stage
+	for id := len(r.stages) - 1; id >= 0; id-- {
+		stage, ok := r.open[id]
+		if !ok && len(r.done[id]) == 0 {
+			continue
+		}
+		if !ok {
+			stage = r.openStage(id)
+		}
+		delete(r.open, id)
+
+		stage.Err = err
+		stage.Stages = r.done[id]
+		delete(r.done, id)
+
+		parent := r.stages[id].parent
+		r.done[parent] = append(r.done[parent], *stage)
+	}
+
+	return append([]TraceStage(nil), r.done[-1]...)
+}
@@ -0,0 +1,107 @@
+package roulette
+
+import (
+	"context"
+	"log"
+	"strings"
+	"testing"
+)
+
+func TestExplain(t *testing.T) {
+	parser, err := NewTextTemplateParserFiles([]string{"testrules/rules_explain.xml"}, TextTemplateParserConfig{})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	t2 := &T2{A: 1, B: 3}
+	trace, err := Explain(parser, t2)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	promote, ok := trace.Rule("promotion", "promote")
+	if !ok || promote.Status != RuleFalse || len(promote.Stages) != 3 {
+		log.Fatalf("Expected the three stages of the promote rule, got %+v", promote)
+	}
+
+	le, and := promote.Stages[0], promote.Stages[1]
+	if le.Name != "le" || le.Return != true || le.Args[0].Value != 1 || !le.Args[1].Const {
+		log.Fatalf("Expected the le stage, got %+v", le)
+	}
+	if and.Name != "and" || and.Return != false || and.Args[1].Expr != "prevVal" || and.Args[1].Value != true {
+		log.Fatalf("Expected the and stage with the piped value, got %+v", and)
+	}
+	if len(and.Stages) != 1 || and.Stages[0].Name != "eq" || and.Stages[0].Return != false {
+		log.Fatalf("Expected the eq stage nested in the and stage, got %+v", and.Stages)
+	}
+	if t2.A != 1 {
+		log.Fatalf("Expected the rule not to set A, got %d", t2.A)
+	}
+
+	broken, _ := trace.Rule("promotion", "broken")
+	if broken.Status != RuleErrored || len(broken.Stages) != 1 || broken.Stages[0].Err == nil {
+		log.Fatalf("Expected the lt stage to fail, got %+v", broken)
+	}
+
+	for _, line := range []string{
+		"ruleset promotion:",
+		"  rule promote (priority 1): false",
+		"    le .roulette.T2.A 5 -> true",
+		"        .roulette.T2.A = 1",
+		"    and (eq .roulette.T2.B 2) prevVal -> false",
+		"        eq .roulette.T2.B 2 -> false",
+		"        prevVal = true",
+		"    .roulette.T2.SetA 10 prevVal -> false",
+		"    lt .roulette.T2.B \"two\" -> failed",
+		"ruleset skipped: skipped: input types do not match filterTypes",
+	} {
+		if !strings.Contains(trace.String(), line+"\n") {
+			log.Fatalf("Expected %q in the trace, got\n%s", line, trace)
+		}
+	}
+
+	// the traced templates are copies
+	report := parser.Execute(&T2{A: 1, B: 2})
+	if ruleset, _ := report.Ruleset("promotion"); len(ruleset.Fired()) != 1 {
+		log.Fatalf("Expected promote to fire, got %v", report)
+	}
+}
+
+func TestExplainContext(t *testing.T) {
+	config := TextTemplateParserConfig{Result: NewResultCallback(func(interface{}) {})}
+	parser, err := NewTextTemplateParserFiles([]string{"testrules/rules_flow.xml"}, config)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// the rulesets of flows are only executed by the flows
+	trace, err := Explain(parser, &T2{A: 150, B: 1})
+	if err != nil {
+		log.Fatal(err)
+	}
+	if ruleset := trace.Rulesets[0]; ruleset.Name != "classifyOrder" || !ruleset.Skipped || ruleset.Err != ErrFlow {
+		log.Fatalf("Expected classifyOrder to be skipped with %v, got %+v", ErrFlow, ruleset)
+	}
+	if audit, _ := trace.Rule("audit", "audit"); audit.Status != RuleFired {
+		log.Fatalf("Expected the audit rule to fire, got %+v", audit)
+	}
+
+	parser, err = NewTextTemplateParserFiles([]string{"testrules/rules_chaining.xml"}, TextTemplateParserConfig{})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	tt := &T{}
+	trace, err = ExplainContext(WithWorkflow(context.Background(), "loop"), parser, tt)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, ruleset := range trace.Rulesets {
+		if ruleset.Name != "loop" && ruleset.Err != ErrWorkflowMismatch {
+			log.Fatalf("Expected ruleset %s to be skipped with %v, got %v", ruleset.Name, ErrWorkflowMismatch, ruleset.Err)
+		}
+	}
+	if tt.A != 1 {
+		log.Fatalf("Expected only the loop ruleset to increment A, got %d", tt.A)
+	}
+}
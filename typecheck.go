@@ -0,0 +1,452 @@
+package roulette
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"text/template"
+	"text/template/parse"
+)
+
+// TypeRegistry maps the type names used in filterTypes, e.g. types.Person, to their go types. When it is set
+// in the parser config the rule expressions are checked against the registered types at compile time, and
+// every filter type must be registered. The type names of Facts inputs are registered without a go type.
+type TypeRegistry map[string]reflect.Type
+
+// NewTypeRegistry returns a registry of the types of the values.
+func NewTypeRegistry(vals ...interface{}) TypeRegistry {
+	r := TypeRegistry{}
+	r.Register(vals...)
+	return r
+}
+
+// Register registers the types of the values. A value or a pointer to it registers the same type.
+func (r TypeRegistry) Register(vals ...interface{}) {
+	for _, val := range vals {
+		typ := reflect.TypeOf(val)
+		if typ.Kind() == reflect.Ptr {
+			typ = typ.Elem()
+		}
+		// rules are executed on pointers to the values
+		r[typ.String()] = reflect.PtrTo(typ)
+	}
+}
+
+// RegisterFacts registers the type names of Facts inputs, e.g. types.Person. Their values are not checked.
+func (r TypeRegistry) RegisterFacts(typeNames ...string) {
+	for _, typeName := range typeNames {
+		r[typeName] = nil
+	}
+}
+
+// lookup returns the type of pkg.name. name can be followed by the index of a value of the same type.
+func (r TypeRegistry) lookup(pkg, name string) (reflect.Type, bool) {
+	for i := len(name); i > 0; i-- {
+		if i < len(name) && (name[i] < '0' || name[i] > '9') {
+			break
+		}
+		if typ, ok := r[pkg+"."+name[:i]]; ok {
+			return typ, true
+		}
+	}
+	return nil, false
+}
+
+func (r TypeRegistry) hasPkg(pkg string) bool {
+	for name := range r {
+		if strings.HasPrefix(name, pkg+".") {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	resultType       = reflect.TypeOf((*Result)(nil)).Elem()
+	templateDataType = reflect.TypeOf(&templateData{})
+)
+
+// symKind is what is known about a value of the template data at compile time.
+type symKind int
+
+const (
+	symUnknown symKind = iota // not checked
+	symRoot                   // the template data, keyed by dataKey
+	symData                   // the values keyed by package, resultKey and R
+	symPkg                    // the values of a package keyed by type name
+	symType                   // a value of a go type
+)
+
+type sym struct {
+	kind symKind
+	typ  reflect.Type
+	pkg  string
+}
+
+func typeSym(typ reflect.Type) sym {
+	if typ == nil || typ.Kind() == reflect.Interface && typ.NumMethod() == 0 {
+		return sym{}
+	}
+	return sym{kind: symType, typ: typ}
+}
+
+type checkVar struct {
+	name string
+	sym  sym
+}
+
+// typeChecker walks the parse trees of a rule's template and checks the field and method paths, the
+// argument counts and the functions against the registered types.
+type typeChecker struct {
+	types     TypeRegistry
+	dataKey   string
+	resultKey string
+	tmpl      *template.Template
+	funcs     template.FuncMap
+	vars      []checkVar
+	checked   map[string]bool // defined templates already checked
+	errs      []error
+}
+
+// typeCheck returns an error for every invalid reference of the rule's template.
+func typeCheck(types TypeRegistry, ruleset *TextTemplateRuleset, rule *Rule) []error {
+	tmpl := rule.config.template
+	if tmpl == nil || tmpl.Tree == nil {
+		return nil
+	}
+
+	c := &typeChecker{
+		types:     types,
+		dataKey:   ruleset.DataKey,
+		resultKey: ruleset.ResultKey,
+		tmpl:      tmpl,
+		funcs:     rule.config.allfuncs,
+		checked:   map[string]bool{tmpl.Name(): true},
+	}
+
+	root := sym{kind: symRoot}
+	c.vars = []checkVar{{"$", root}}
+	c.walk(tmpl.Tree.Root, root)
+
+	// the blocks of a structured rule
+	for _, name := range []string{thenTemplate, elseTemplate} {
+		if block := tmpl.Lookup(name); block != nil && block.Tree != nil && !c.checked[name] {
+			c.checked[name] = true
+			c.vars = []checkVar{{"$", root}}
+			c.walk(block.Tree.Root, root)
+		}
+	}
+	return c.errs
+}
+
+func (c *typeChecker) errorf(node parse.Node, format string, args ...interface{}) {
+	location, _ := c.tmpl.Tree.ErrorContext(node)
+	c.errs = append(c.errs, fmt.Errorf("%s: %s", location, fmt.Sprintf(format, args...)))
+}
+
+func (c *typeChecker) walk(node parse.Node, dot sym) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, node := range n.Nodes {
+			c.walk(node, dot)
+		}
+	case *parse.ActionNode:
+		c.pipe(n.Pipe, dot)
+	case *parse.IfNode:
+		c.branch(&n.BranchNode, dot, false)
+	case *parse.WithNode:
+		c.branch(&n.BranchNode, dot, true)
+	case *parse.RangeNode:
+		vars := len(c.vars)
+		s := elemSym(c.pipe(n.Pipe, dot))
+		if len(n.Pipe.Decl) > 0 {
+			// the last variable is the element, the first the key or index
+			c.vars[len(c.vars)-1].sym = s
+			if len(n.Pipe.Decl) > 1 {
+				c.vars[len(c.vars)-2].sym = sym{}
+			}
+		}
+		c.walk(n.List, s)
+		c.vars = c.vars[:vars]
+		c.walk(n.ElseList, dot)
+	case *parse.TemplateNode:
+		s := sym{}
+		if n.Pipe != nil {
+			s = c.pipe(n.Pipe, dot)
+		}
+		defined := c.tmpl.Lookup(n.Name)
+		if defined == nil || defined.Tree == nil || c.checked[n.Name] {
+			return
+		}
+		c.checked[n.Name] = true
+
+		// a defined template has its own variables
+		vars := c.vars
+		c.vars = []checkVar{{"$", s}}
+		c.walk(defined.Tree.Root, s)
+		c.vars = vars
+	}
+}
+
+// branch checks an if or with. with sets dot to the value of its pipeline.
+func (c *typeChecker) branch(n *parse.BranchNode, dot sym, with bool) {
+	vars := len(c.vars)
+	s := c.pipe(n.Pipe, dot)
+	if with {
+		c.walk(n.List, s)
+	} else {
+		c.walk(n.List, dot)
+	}
+	c.vars = c.vars[:vars]
+	c.walk(n.ElseList, dot)
+}
+
+func (c *typeChecker) pipe(pipe *parse.PipeNode, dot sym) sym {
+	if pipe == nil {
+		return sym{}
+	}
+
+	s := sym{}
+	for i, cmd := range pipe.Cmds {
+		s = c.command(cmd, dot, i > 0)
+	}
+
+	for _, v := range pipe.Decl {
+		if pipe.IsAssign {
+			c.setVar(v.Ident[0], s)
+		} else {
+			c.vars = append(c.vars, checkVar{v.Ident[0], s})
+		}
+	}
+
+	return s
+}
+
+// command checks a command. piped is true if the previous command's value is its last argument.
+func (c *typeChecker) command(cmd *parse.CommandNode, dot sym, piped bool) sym {
+	args := cmd.Args[1:]
+	nargs := len(args)
+	if piped {
+		nargs++
+	}
+
+	for _, arg := range args {
+		c.arg(arg, dot)
+	}
+
+	switch n := cmd.Args[0].(type) {
+	case *parse.FieldNode:
+		return c.fields(n, dot, n.Ident, nargs)
+	case *parse.ChainNode:
+		return c.fields(n, c.arg(n.Node, dot), n.Field, nargs)
+	case *parse.VariableNode:
+		if len(n.Ident) == 1 {
+			return c.lookupVar(n.Ident[0])
+		}
+		return c.fields(n, c.lookupVar(n.Ident[0]), n.Ident[1:], nargs)
+	case *parse.IdentifierNode:
+		return c.function(n, nargs)
+	}
+
+	return c.arg(cmd.Args[0], dot)
+}
+
+// arg checks an argument of a command and returns its value.
+func (c *typeChecker) arg(node parse.Node, dot sym) sym {
+	switch n := node.(type) {
+	case *parse.DotNode:
+		return dot
+	case *parse.FieldNode:
+		return c.fields(n, dot, n.Ident, 0)
+	case *parse.ChainNode:
+		return c.fields(n, c.arg(n.Node, dot), n.Field, 0)
+	case *parse.VariableNode:
+		if len(n.Ident) == 1 {
+			return c.lookupVar(n.Ident[0])
+		}
+		return c.fields(n, c.lookupVar(n.Ident[0]), n.Ident[1:], 0)
+	case *parse.PipeNode:
+		vars := len(c.vars)
+		s := c.pipe(n, dot)
+		c.vars = c.vars[:vars]
+		return s
+	case *parse.IdentifierNode:
+		return c.function(n, 0)
+	case *parse.BoolNode:
+		return typeSym(reflect.TypeOf(true))
+	case *parse.StringNode:
+		return typeSym(reflect.TypeOf(""))
+	}
+	return sym{}
+}
+
+// fields checks the path of fields and methods. Only the last one is given the arguments.
+func (c *typeChecker) fields(node parse.Node, s sym, idents []string, nargs int) sym {
+	for i, ident := range idents {
+		n := 0
+		if i == len(idents)-1 {
+			n = nargs
+		}
+
+		s = c.field(node, s, ident, n)
+		if s.kind == symUnknown {
+			return s
+		}
+	}
+	return s
+}
+
+func (c *typeChecker) field(node parse.Node, s sym, name string, nargs int) sym {
+	switch s.kind {
+	case symRoot:
+		if name != c.dataKey {
+			c.errorf(node, "%s is not the dataKey %s", name, c.dataKey)
+			return sym{}
+		}
+		return sym{kind: symData}
+	case symData:
+		switch {
+		case name == c.resultKey:
+			return typeSym(resultType)
+		case name == "R":
+			return typeSym(templateDataType)
+		case c.types.hasPkg(name):
+			return sym{kind: symPkg, pkg: name}
+		}
+		// values which are not registered types are not checked
+		return sym{}
+	case symPkg:
+		typ, ok := c.types.lookup(s.pkg, name)
+		if !ok {
+			c.errorf(node, "type %s.%s is not registered", s.pkg, name)
+			return sym{}
+		}
+		if typ == nil {
+			// the facts of the type are not checked
+			return sym{}
+		}
+		return typeSym(typ)
+	case symType:
+		return c.method(node, s.typ, name, nargs)
+	}
+	return sym{}
+}
+
+// method checks the method or field of the type.
+func (c *typeChecker) method(node parse.Node, typ reflect.Type, name string, nargs int) sym {
+	method, ok := typ.MethodByName(name)
+	if !ok && typ.Kind() != reflect.Ptr && typ.Kind() != reflect.Interface {
+		method, ok = reflect.PtrTo(typ).MethodByName(name)
+	}
+
+	if ok {
+		in := method.Type.NumIn()
+		if method.Func.IsValid() {
+			// the receiver, methods of interfaces have none
+			in--
+		}
+		if !c.checkArgs(node, typ.String()+"."+name, method.Type, in, nargs) {
+			return sym{}
+		}
+		return typeSym(method.Type.Out(0))
+	}
+
+	if nargs > 0 {
+		c.errorf(node, "%s has no exported method %s", typ, name)
+		return sym{}
+	}
+
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+
+	switch typ.Kind() {
+	case reflect.Struct:
+		field, ok := typ.FieldByName(name)
+		if !ok || field.PkgPath != "" {
+			c.errorf(node, "%s has no exported field or method %s", typ, name)
+			return sym{}
+		}
+		return typeSym(field.Type)
+	case reflect.Map:
+		return typeSym(typ.Elem())
+	case reflect.Interface:
+		return sym{}
+	}
+
+	c.errorf(node, "can't evaluate field %s in type %s", name, typ)
+	return sym{}
+}
+
+// function checks the arguments of a parser func. The builtin funcs are not checked.
+func (c *typeChecker) function(node *parse.IdentifierNode, nargs int) sym {
+	fn, ok := c.funcs[node.Ident]
+	if !ok {
+		return sym{}
+	}
+
+	typ := reflect.TypeOf(fn)
+	if !c.checkArgs(node, node.Ident, typ, typ.NumIn(), nargs) {
+		return sym{}
+	}
+	return typeSym(typ.Out(0))
+}
+
+// checkArgs checks the number of args of a call and returns false if the call returns no value.
+func (c *typeChecker) checkArgs(node parse.Node, name string, typ reflect.Type, in, nargs int) bool {
+	if typ.NumOut() == 0 {
+		c.errorf(node, "%s returns no value", name)
+		return false
+	}
+
+	if typ.IsVariadic() {
+		if nargs < in-1 {
+			c.errorf(node, "wrong number of args for %s: want at least %d got %d", name, in-1, nargs)
+		}
+		return true
+	}
+
+	if nargs != in {
+		c.errorf(node, "wrong number of args for %s: want %d got %d", name, in, nargs)
+	}
+	return true
+}
+
+func (c *typeChecker) lookupVar(name string) sym {
+	for i := len(c.vars) - 1; i >= 0; i-- {
+		if c.vars[i].name == name {
+			return c.vars[i].sym
+		}
+	}
+	return sym{}
+}
+
+func (c *typeChecker) setVar(name string, s sym) {
+	for i := len(c.vars) - 1; i >= 0; i-- {
+		if c.vars[i].name == name {
+			c.vars[i].sym = s
+			return
+		}
+	}
+}
+
+// elemSym returns the value of the elements ranged over.
+func elemSym(s sym) sym {
+	if s.kind != symType {
+		return sym{}
+	}
+
+	typ := s.typ
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+
+	switch typ.Kind() {
+	case reflect.Slice, reflect.Array, reflect.Map, reflect.Chan:
+		return typeSym(typ.Elem())
+	}
+	return sym{}
+}
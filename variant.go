@@ -0,0 +1,119 @@
+package roulette
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// ErrVariant is reported for the rules of the variants which were not assigned to the input.
+var ErrVariant = errors.New("rule is not in the assigned variant")
+
+// Variant is a named group of rules of an experiment. Every input is assigned to one variant of the ruleset,
+// in proportion to their weights, by the hash of the ruleset's bucketKey, so that an input always gets the
+// same variant. The rules of the assigned variant are executed with the rules of the ruleset in order of
+// priority.
+//
+//	<ruleset name="pricing" bucketKey=".types.User.ID" ...>
+//	    <variant name="control" weight="90">...</variant>
+//	    <variant name="discount" weight="10">
+//	        <rule name="discount" priority="1">...</rule>
+//	    </variant>
+//	</ruleset>
+type Variant struct {
+	Name   string  `xml:"name,attr" json:"name" yaml:"name"`
+	Weight float64 `xml:"weight,attr,omitempty" json:"weight,omitempty" yaml:"weight,omitempty"` // default 1
+	Rules  []Rule  `xml:"rule" json:"rules" yaml:"rules"`
+
+	pos sourcePos
+}
+
+// variants assigns the inputs to the variants of a ruleset.
+type variants struct {
+	salt   string
+	names  []string
+	bounds []int // the variant of a bucket is the first whose bound is greater than the bucket
+	key    *template.Template
+}
+
+// newVariants returns the assignment of the ruleset's variants.
+func newVariants(ruleset *TextTemplateRuleset, funcs template.FuncMap) (*variants, error) {
+	if strings.TrimSpace(ruleset.BucketKey) == "" {
+		return nil, fmt.Errorf("variants have no bucketKey")
+	}
+
+	key, err := parseBucketKey(ruleset.BucketKey, funcs)
+	if err != nil {
+		return nil, err
+	}
+
+	// salted differently from the ruleset's rollout, so that the inputs of a rollout are spread over the variants
+	v := &variants{salt: "variant:" + ruleset.Name, key: key}
+
+	total := 0.0
+	names := make(map[string]bool)
+	for _, variant := range ruleset.Variants {
+		switch {
+		case variant.Name == "":
+			return nil, fmt.Errorf("variant has no name")
+		case names[variant.Name]:
+			return nil, fmt.Errorf("duplicate variant %s", variant.Name)
+		case variant.Weight < 0:
+			return nil, fmt.Errorf("variant %s has a negative weight", variant.Name)
+		}
+		names[variant.Name] = true
+		total += variantWeight(variant)
+	}
+
+	if total == 0 {
+		return nil, fmt.Errorf("variants have no weight")
+	}
+
+	sum := 0.0
+	for _, variant := range ruleset.Variants {
+		sum += variantWeight(variant)
+		v.names = append(v.names, variant.Name)
+		v.bounds = append(v.bounds, int(sum/total*rolloutBuckets+0.5))
+	}
+	v.bounds[len(v.bounds)-1] = rolloutBuckets
+
+	return v, nil
+}
+
+func variantWeight(variant Variant) float64 {
+	if variant.Weight == 0 {
+		return 1
+	}
+	return variant.Weight
+}
+
+// assign evaluates the bucketKey on the values of the dataKey and returns the name of the input's variant.
+func (v *variants) assign(valsData map[string]interface{}) (string, error) {
+	b, err := keyBucket(v.key, v.salt, valsData)
+	if err != nil {
+		return "", err
+	}
+
+	for i, bound := range v.bounds {
+		if b < bound {
+			return v.names[i], nil
+		}
+	}
+	return v.names[len(v.names)-1], nil
+}
+
+// variantRules returns the rules of the ruleset followed by the rules of its variants.
+func variantRules(ruleset *TextTemplateRuleset) []Rule {
+	rules := append([]Rule(nil), ruleset.Rules...)
+	for _, variant := range ruleset.Variants {
+		for _, rule := range variant.Rules {
+			if rule.pos.line == 0 {
+				rule.pos = variant.pos
+			}
+			rule.variant = variant.Name
+			rules = append(rules, rule)
+		}
+	}
+	return rules
+}
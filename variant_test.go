@@ -0,0 +1,125 @@
+package roulette
+
+import (
+	"log"
+	"os"
+	"strings"
+	"testing"
+)
+
+type envelopes []ResultEnvelope
+
+func (e *envelopes) Put(val interface{}, prevVal ...bool) bool {
+	return e.PutEnvelope(ResultEnvelope{Value: val}, prevVal...)
+}
+
+func (e *envelopes) PutEnvelope(env ResultEnvelope, prevVal ...bool) bool {
+	*e = append(*e, env)
+	return true
+}
+
+func (e *envelopes) Get() interface{} {
+	return *e
+}
+
+func TestVariants(t *testing.T) {
+	var results envelopes
+	parser, err := NewTextTemplateParserFiles([]string{"testrules/rules_variants.xml"}, TextTemplateParserConfig{Result: &results})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	assigned := make(map[string]int)
+	for id := 0; id < 1000; id++ {
+		results = results[:0]
+		report := parser.Execute(&T2{A: 10, B: id})
+
+		ruleset, _ := report.Ruleset("pricing")
+		assigned[ruleset.Variant]++
+
+		if again, _ := parser.Execute(&T2{A: 10, B: id}).Ruleset("pricing"); again.Variant != ruleset.Variant {
+			log.Fatalf("Expected the variant of %d to be sticky", id)
+		}
+
+		// the rule of the ruleset and of the assigned variant fire
+		fired := ruleset.Fired()
+		if len(fired) != 2 || fired[0] != "everyone" {
+			log.Fatalf("Expected two rules to fire, got %v", report)
+		}
+
+		expected := map[string]string{"control": "fullPrice", "discount": "discount"}[ruleset.Variant]
+		for _, rule := range ruleset.Rules[1:] {
+			if rule.Name != expected && rule.Err != ErrVariant {
+				log.Fatalf("Expected the rule of the other variant to be skipped, got %v", report)
+			}
+		}
+
+		if len(results) == 0 || results[0].Variant != ruleset.Variant || results[0].Rule != expected {
+			log.Fatalf("Expected the envelope of the variant, got %+v", results)
+		}
+	}
+
+	if len(assigned) != 2 || assigned["control"] < 700 || assigned["control"] > 800 {
+		log.Fatalf("Expected about 75%% of the inputs in control, got %v", assigned)
+	}
+
+	report := parser.Execute(&T2{A: 10, B: 1})
+	if !strings.HasPrefix(report.String(), "ruleset pricing (variant ") {
+		log.Fatalf("Expected the variant in the report, got %s", report)
+	}
+}
+
+func TestBadVariants(t *testing.T) {
+	for attrs, variants := range map[string]string{
+		``:                            `<variant name="a"/>`,
+		`bucketKey=".roulette.T2.B"`:  `<variant name="a"/><variant name="a"/>`,
+		` bucketKey=".roulette.T2.B"`: `<variant name="a" weight="-1"/>`,
+	} {
+		data := `<roulette><ruleset name="r" dataKey="TestData" filterTypes="roulette.T2" ` + attrs + `>` + variants +
+			`</ruleset></roulette>`
+		_, err := NewParser([]byte(data))
+		if err == nil || !strings.Contains(err.Error(), "variant") {
+			log.Fatalf("Expected invalid variants for %s, got %v", variants, err)
+		}
+	}
+}
+
+func TestVariantFormats(t *testing.T) {
+	data, err := os.ReadFile("testrules/rules_variants.xml")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	for _, format := range []RuleFormat{FormatJSON, FormatYAML, FormatXML} {
+		converted, err := ConvertRules(data, FormatXML, format)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		xmldata, err := decodeRules(converted, format)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		variants := xmldata.Rulesets[0].Variants
+		if len(variants) != 2 || variants[1].Weight != 25 || variants[1].Rules[0].Name != "discount" {
+			log.Fatalf("Expected the variants in %s, got %+v", format, variants)
+		}
+	}
+}
+
+func TestVariantLint(t *testing.T) {
+	parser, err := NewTextTemplateParserFiles([]string{"testrules/rules_variants.xml"}, TextTemplateParserConfig{Result: &envelopes{}})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if warnings, err := Lint(parser); err != nil || len(warnings) != 1 || warnings[0].Rule != "everyone" {
+		log.Fatalf("Expected the rules of different variants to share a priority, got %v", warnings)
+	}
+
+	rules := parser.(TextTemplateParser).xml.Rulesets[0].Rules
+	if pos := rules[len(rules)-1].pos.String(); pos != "testrules/rules_variants.xml:14" {
+		log.Fatalf("Expected the line of the rule of the variant, got %s", pos)
+	}
+}
@@ -0,0 +1,82 @@
+package roulette
+
+import (
+	"log"
+	"strings"
+	"testing"
+)
+
+func TestWhenThenElse(t *testing.T) {
+	var results []interface{}
+	config := TextTemplateParserConfig{
+		Result: NewResultCallback(func(val interface{}) {
+			results = append(results, val)
+		}),
+	}
+
+	parser, err := NewTextTemplateParserFiles([]string{"testrules/rules_when.xml"}, config)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	adult := &T2{A: 20}
+	report := parser.Execute(adult)
+	if report.Rulesets[0].Rules[0].Status != RuleFired || len(results) != 1 || results[0] != adult {
+		log.Fatalf("Expected then to put the result, got %v %v", report, results)
+	}
+
+	child := &T2{A: 10}
+	report = parser.Execute(child)
+	if report.Rulesets[0].Rules[0].Status != RuleFalse || child.A != 1 || len(results) != 1 {
+		log.Fatalf("Expected else to set A, got %v %d", report, child.A)
+	}
+	if report.Rulesets[0].Rules[1].Status != RuleFired {
+		log.Fatalf("Expected the rules with an expression to be executed, got %v", report)
+	}
+}
+
+func TestWhenThenElseFormats(t *testing.T) {
+	data := readFile("testrules/rules_when.xml")
+
+	converted, err := ConvertRules(data, FormatXML, FormatJSON)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if !strings.Contains(string(converted), `"when":`) || strings.Contains(string(converted), "<when>") {
+		log.Fatalf("Expected the blocks as json keys, got %s", converted)
+	}
+
+	converted, err = ConvertRules(converted, FormatJSON, FormatXML)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	parser, err := NewParser(converted, TextTemplateParserConfig{Result: NewResultCallback(func(interface{}) {})})
+	if err != nil {
+		log.Fatal(err)
+	}
+	child := &T2{A: 10}
+	parser.Execute(child)
+	if child.A != 1 {
+		log.Fatalf("Expected the converted rules to execute the else block, got %d", child.A)
+	}
+}
+
+func TestWhenThenElseInvalid(t *testing.T) {
+	rules := []struct {
+		rule string
+		err  string
+	}{
+		{`<rule name="mixed"><r>true</r><when><r>true</r></when></rule>`, "mixes an expression"},
+		{`<rule name="then"><then><r>true</r></then></rule>`, "no when block"},
+		{`<rule name="parse"><when><r>true</r></when><then><r>(</r></then></rule>`, "then"},
+	}
+
+	for _, r := range rules {
+		data := `<roulette><ruleset name="r" dataKey="TestData" filterTypes="roulette.T2">` + r.rule + `</ruleset></roulette>`
+		_, err := NewParser([]byte(data), TextTemplateParserConfig{Strict: true})
+		if err == nil || !strings.Contains(err.Error(), r.err) {
+			log.Fatalf("Expected an error containing %q for %s, got %v", r.err, r.rule, err)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package roulette
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// WorkflowMatcher matches the workflow pattern which selects the rulesets of an execution against the workflow
+// of a ruleset. The pattern is the WorkflowPattern config or the workflow of the context of an execution, the
+// workflow is the workflow attribute of a ruleset. Rulesets without a workflow match every pattern and an empty
+// pattern matches every ruleset, the matcher is not called for them.
+type WorkflowMatcher interface {
+	MatchWorkflow(pattern, workflow string) bool
+}
+
+// WorkflowMatcherFunc is a func which is a WorkflowMatcher.
+type WorkflowMatcherFunc func(pattern, workflow string) bool
+
+// MatchWorkflow calls f.
+func (f WorkflowMatcherFunc) MatchWorkflow(pattern, workflow string) bool {
+	return f(pattern, workflow)
+}
+
+var (
+	// ExactWorkflowMatcher matches the workflow equal to the pattern.
+	ExactWorkflowMatcher WorkflowMatcher = WorkflowMatcherFunc(func(pattern, workflow string) bool {
+		return pattern == workflow
+	})
+
+	// WildcardWorkflowMatcher matches the workflow with the wildcard pattern. * matches any characters and ? a
+	// single character, e.g. summer* matches summersale.
+	WildcardWorkflowMatcher WorkflowMatcher = WorkflowMatcherFunc(func(pattern, workflow string) bool {
+		return wildcardMatcher(workflow, pattern)
+	})
+
+	// RegexWorkflowMatcher matches the workflow containing a match of the regex pattern, e.g. sale$ matches
+	// summersale. An invalid regex matches no workflow.
+	RegexWorkflowMatcher WorkflowMatcher = WorkflowMatcherFunc(func(pattern, workflow string) bool {
+		regex, err := regexp.Compile(pattern)
+		return err == nil && regex.MatchString(workflow)
+	})
+)
+
+// MultiWorkflowMatcher returns a matcher of the rulesets which belong to a comma separated list of workflows,
+// e.g. workflow="iplsale,summersale". A ruleset is matched if the matcher matches one of its workflows.
+func MultiWorkflowMatcher(matcher WorkflowMatcher) WorkflowMatcher {
+	return WorkflowMatcherFunc(func(pattern, workflow string) bool {
+		for _, name := range strings.Split(workflow, ",") {
+			if matcher.MatchWorkflow(pattern, strings.TrimSpace(name)) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// defaultWorkflowMatcher is the matcher of the workflow patterns which are not wildcard patterns.
+var defaultWorkflowMatcher = MultiWorkflowMatcher(RegexWorkflowMatcher)
+
+// wildcardWorkflowMatcher is the matcher of the wildcard workflow patterns.
+var wildcardWorkflowMatcher = MultiWorkflowMatcher(WildcardWorkflowMatcher)
+
+// maxCachedWorkflows bounds the number of workflow patterns whose matching rulesets are cached by a parser.
+const maxCachedWorkflows = 256
+
+// workflowKey is the context key of the workflow selected for an execution.
+type workflowKey struct{}
+
+// workflowSelection is a workflow pattern and whether it is a wildcard pattern.
+type workflowSelection struct {
+	pattern  string
+	wildcard bool
+}
+
+// WithWorkflow returns a context which selects the rulesets to execute by the workflow pattern instead of the
+// parser's WorkflowPattern. The pattern is matched by the parser's WorkflowMatcher. An empty pattern executes
+// every ruleset.
+//
+//	report := parser.ExecuteContext(roulette.WithWorkflow(ctx, "iplsale"), vals)
+func WithWorkflow(ctx context.Context, pattern string) context.Context {
+	return context.WithValue(ctx, workflowKey{}, workflowSelection{pattern: pattern})
+}
+
+// WithWildcardWorkflow is like WithWorkflow but the pattern is a wildcard pattern, whatever the parser's
+// WorkflowMatcher.
+func WithWildcardWorkflow(ctx context.Context, pattern string) context.Context {
+	return context.WithValue(ctx, workflowKey{}, workflowSelection{pattern: pattern, wildcard: true})
+}
+
+// matchesWorkflow returns true if the ruleset is executed with the workflow pattern.
+func (t TextTemplateRuleset) matchesWorkflow(pattern string, matcher WorkflowMatcher) bool {
+	if pattern == "" || t.Workflow == "" {
+		return true
+	}
+	return matcher.MatchWorkflow(pattern, t.Workflow)
+}
+
+// workflowIndex caches the rulesets matching the workflow patterns selected per execution.
+type workflowIndex struct {
+	mu      sync.RWMutex
+	matches map[workflowSelection][]bool
+}
+
+func newWorkflowIndex() *workflowIndex {
+	return &workflowIndex{matches: make(map[workflowSelection][]bool)}
+}
+
+// get returns whether each ruleset is executed with the workflow pattern. The patterns which are not wildcard
+// patterns are matched by the matcher.
+func (w *workflowIndex) get(selection workflowSelection, rulesets []TextTemplateRuleset, matcher WorkflowMatcher) []bool {
+	if w != nil {
+		w.mu.RLock()
+		matches, ok := w.matches[selection]
+		w.mu.RUnlock()
+		if ok {
+			return matches
+		}
+	}
+
+	if selection.wildcard {
+		matcher = wildcardWorkflowMatcher
+	}
+
+	matches := make([]bool, len(rulesets))
+	for i := range rulesets {
+		matches[i] = rulesets[i].matchesWorkflow(selection.pattern, matcher)
+	}
+
+	if w != nil {
+		w.mu.Lock()
+		if len(w.matches) < maxCachedWorkflows {
+			w.matches[selection] = matches
+		}
+		w.mu.Unlock()
+	}
+
+	return matches
+}
@@ -0,0 +1,140 @@
+package roulette
+
+import (
+	"context"
+	"log"
+	"strings"
+	"testing"
+)
+
+func TestExecuteWorkflow(t *testing.T) {
+	// the parser's pattern is replaced by the workflow of the context
+	parser, err := NewParser(readFile("testrules/rules_workflows.xml"), TextTemplateParserConfig{WorkflowPattern: "iplsale"})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	executor := NewSimpleExecutor(parser)
+	for _, v := range workflowPatterns {
+		t21 := &T2{A: 1, B: 2}
+		executor.ExecuteContext(WithWildcardWorkflow(context.Background(), v.workflowPattern), t21)
+		if t21.A != v.expectedVal {
+			log.Fatalf("%s: expected value to be %d got %d", v.workflowPattern, v.expectedVal, t21.A)
+		}
+	}
+
+	for pattern, expected := range map[string]int{"summersale": 20, "iplsale": 10, "wintersale": 1} {
+		t21 := &T2{A: 1, B: 2}
+		report := parser.ExecuteContext(WithWorkflow(context.Background(), pattern), t21)
+		if t21.A != expected {
+			log.Fatalf("%s: expected value to be %d got %d", pattern, expected, t21.A)
+		}
+		if pattern == "wintersale" && report.Rulesets[0].Err != ErrWorkflowMismatch {
+			log.Fatalf("Expected the rulesets to be skipped by the workflow, got %v", report)
+		}
+	}
+
+	// without a workflow in the context the parser's pattern is used
+	t21 := &T2{A: 1, B: 2}
+	parser.Execute(t21)
+	if t21.A != 10 {
+		log.Fatalf("Expected the parser's workflow, got %d", t21.A)
+	}
+
+	if cached := len(parser.(TextTemplateParser).workflows.matches); cached != 6 {
+		log.Fatalf("Expected the matches of 6 workflows to be cached, got %d", cached)
+	}
+}
+
+func TestWorkflowIndexLimit(t *testing.T) {
+	index := newWorkflowIndex()
+	rulesets := []TextTemplateRuleset{{Workflow: "sale"}}
+	for i := 0; i < maxCachedWorkflows+10; i++ {
+		index.get(workflowSelection{pattern: string(rune('a' + i)), wildcard: true}, rulesets, defaultWorkflowMatcher)
+	}
+
+	if len(index.matches) != maxCachedWorkflows {
+		log.Fatalf("Expected the cache to be bounded, got %d", len(index.matches))
+	}
+}
+
+var workflowMatcherTests = []struct {
+	matcher  string
+	pattern  string
+	workflow string
+	expected bool
+}{
+	{"exact", "iplsale", "iplsale", true},
+	{"exact", "ipl", "iplsale", false},
+	{"exact", "ipl*", "iplsale", false},
+	{"wildcard", "ipl*", "iplsale", true},
+	{"wildcard", "ipl?ale", "iplsale", true},
+	{"wildcard", "*sale", "summersale", true},
+	{"wildcard", "summer*", "iplsale", false},
+	{"wildcard", "iplsale", "iplsale", true},
+	{"regex", "^ipl", "iplsale", true},
+	{"regex", "sale$", "summersale", true},
+	{"regex", "^summer", "iplsale", false},
+	{"regex", "ipl(", "ipl(", false},
+	{"multi exact", "summersale", "iplsale, summersale", true},
+	{"multi exact", "wintersale", "iplsale,summersale", false},
+	{"multi wildcard", "summer*", "iplsale,summersale", true},
+	{"multi regex", "^ipl", "summersale,iplsale", true},
+	{"multi regex", "^ipl", "summersale", false},
+}
+
+func TestWorkflowMatchers(t *testing.T) {
+	matchers := map[string]WorkflowMatcher{
+		"exact":          ExactWorkflowMatcher,
+		"wildcard":       WildcardWorkflowMatcher,
+		"regex":          RegexWorkflowMatcher,
+		"multi exact":    MultiWorkflowMatcher(ExactWorkflowMatcher),
+		"multi wildcard": MultiWorkflowMatcher(WildcardWorkflowMatcher),
+		"multi regex":    MultiWorkflowMatcher(RegexWorkflowMatcher),
+	}
+
+	for _, test := range workflowMatcherTests {
+		if matched := matchers[test.matcher].MatchWorkflow(test.pattern, test.workflow); matched != test.expected {
+			t.Errorf("%s matcher: pattern %s workflow %s: expected %v, got %v", test.matcher, test.pattern,
+				test.workflow, test.expected, matched)
+		}
+	}
+}
+
+func TestConfigWorkflowMatcher(t *testing.T) {
+	data := []byte(`<roulette>
+		<ruleset name="both" dataKey="TestData" filterTypes="roulette.T2" workflow="iplsale,summersale">
+			<rule name="setA"><r>with .TestData</r><r>.roulette.T2.SetA 10</r><r>end</r></rule>
+		</ruleset>
+		<ruleset name="summer" dataKey="TestData" filterTypes="roulette.T2" workflow="summersale">
+			<rule name="setA"><r>with .TestData</r><r>.roulette.T2.SetA 20</r><r>end</r></rule>
+		</ruleset>
+	</roulette>`)
+
+	for _, test := range []struct {
+		config   TextTemplateParserConfig
+		executed []string
+	}{
+		{TextTemplateParserConfig{WorkflowPattern: "iplsale"}, []string{"both"}},
+		{TextTemplateParserConfig{WorkflowPattern: "^summer"}, []string{"both", "summer"}},
+		{TextTemplateParserConfig{WorkflowPattern: "summer*", IsWildcardWorkflowPattern: true}, []string{"both", "summer"}},
+		{TextTemplateParserConfig{WorkflowPattern: "sale", WorkflowMatcher: ExactWorkflowMatcher}, nil},
+		{TextTemplateParserConfig{WorkflowPattern: "summersale", WorkflowMatcher: ExactWorkflowMatcher}, []string{"summer"}},
+	} {
+		parser, err := NewParser(data, test.config)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		var executed []string
+		for _, ruleset := range parser.Execute(&T2{}).Rulesets {
+			if !ruleset.Skipped {
+				executed = append(executed, ruleset.Name)
+			}
+		}
+
+		if strings.Join(executed, ",") != strings.Join(test.executed, ",") {
+			t.Errorf("pattern %s: expected %v to be executed, got %v", test.config.WorkflowPattern, test.executed, executed)
+		}
+	}
+}